@@ -0,0 +1,137 @@
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+
+	"github.com/StackExchange/wmi/wql"
+)
+
+// AssocOptions are the options of Associators and References. They map to
+// the keywords of the WHERE clause of ASSOCIATORS OF and REFERENCES OF
+// queries; empty names are left out.
+type AssocOptions struct {
+	// AssocClass only follows associations of this class or its
+	// subclasses. Associators only.
+	AssocClass string
+	// ResultClass only returns objects of this class or its subclasses:
+	// associated objects for Associators and associations for References.
+	ResultClass string
+	// Role only follows associations whose reference property of this name
+	// refers to the source object.
+	Role string
+	// ResultRole only returns objects that associations refer to with the
+	// reference property of this name. Associators only.
+	ResultRole string
+	// ClassDefsOnly returns the classes of the results instead of the
+	// instances.
+	ClassDefsOnly bool
+	// KeysOnly returns only the key properties of the results. Other fields
+	// of dst are missing, so Client.AllowMissingFields must be set for
+	// them.
+	KeysOnly bool
+}
+
+// Associators runs an ASSOCIATORS OF query for the objects associated with
+// the object at path, such as the partitions of a disk or the services a
+// service depends on, and appends them to dst.
+//
+// path is an object path, as a string or an ObjectPath, or a struct queried
+// from the object as for CallMethodStruct. See Query for dst and
+// connectServerArgs. For example:
+//
+//	var deps []Win32_Service
+//	err := c.Associators(`Win32_Service.Name="Spooler"`, &deps, wmi.AssocOptions{
+//		AssocClass: "Win32_DependentService",
+//		Role:       "Dependent",
+//	})
+func (c *Client) Associators(path, dst interface{}, opts AssocOptions, connectServerArgs ...interface{}) error {
+	if err := checkAssocNames(opts.AssocClass, opts.ResultClass, opts.Role, opts.ResultRole); err != nil {
+		return err
+	}
+	return c.assocQuery(path, dst, func(object string) wql.Statement {
+		return &wql.AssociatorsStatement{
+			Object:        object,
+			AssocClass:    opts.AssocClass,
+			ResultClass:   opts.ResultClass,
+			Role:          opts.Role,
+			ResultRole:    opts.ResultRole,
+			ClassDefsOnly: opts.ClassDefsOnly,
+			KeysOnly:      opts.KeysOnly,
+		}
+	}, connectServerArgs)
+}
+
+// References runs a REFERENCES OF query for the associations that refer to
+// the object at path, such as the Win32_DependentService instances of a
+// service, and appends them to dst. Their reference properties decode into
+// ObjectPath fields.
+//
+// path is as for Associators. opts.AssocClass and opts.ResultRole must be
+// empty; opts.ResultClass selects the associations.
+func (c *Client) References(path, dst interface{}, opts AssocOptions, connectServerArgs ...interface{}) error {
+	if opts.AssocClass != "" || opts.ResultRole != "" {
+		return errors.New("wmi: AssocClass and ResultRole don't apply to REFERENCES OF")
+	}
+	if err := checkAssocNames(opts.ResultClass, opts.Role); err != nil {
+		return err
+	}
+	return c.assocQuery(path, dst, func(object string) wql.Statement {
+		return &wql.ReferencesStatement{
+			Object:        object,
+			ResultClass:   opts.ResultClass,
+			Role:          opts.Role,
+			ClassDefsOnly: opts.ClassDefsOnly,
+			KeysOnly:      opts.KeysOnly,
+		}
+	}, connectServerArgs)
+}
+
+// checkAssocNames checks that the class and property names of AssocOptions
+// can't change the query.
+func checkAssocNames(names ...string) error {
+	for _, name := range names {
+		if name != "" && !isIdent(name) {
+			return fmt.Errorf("wmi: invalid class or property name %q", name)
+		}
+	}
+	return nil
+}
+
+// assocQuery runs the statement build makes for the path of target and
+// appends the results to dst.
+func (c *Client) assocQuery(target, dst interface{}, build func(object string) wql.Statement, connectServerArgs []interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return ErrInvalidEntityType
+	}
+	dv = dv.Elem()
+	mat, elemType := checkMultiArg(dv)
+	if mat == multiArgTypeInvalid {
+		return ErrInvalidEntityType
+	}
+
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	path, err := targetPath(service, target)
+	if err != nil {
+		return err
+	}
+	// Check the path and quote its values with double quotes, which the
+	// braces of the query require.
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	return c.execQuery(service, build(p.String()).String(), dv, mat, elemType)
+}
@@ -0,0 +1,181 @@
+package wmi
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoBackend is returned when a Client has no Backend and there is no
+// DefaultBackend for the current platform.
+var ErrNoBackend = errors.New("wmi: no backend available")
+
+// ErrTimedOut is returned by EventSource.NextEvent and ObjectSet.NextTimeout
+// when nothing arrives in time (wbemErrTimedOut).
+var ErrTimedOut = errors.New("wmi: timed out")
+
+// ErrDisconnected is matched, with errors.Is, by the errors of backends
+// whose connection to WMI broke, such as RPC failures when a remote host
+// restarts. SWbemServices reconnects when a query fails with it.
+var ErrDisconnected = errors.New("wmi: disconnected")
+
+// ErrNotFound is matched, with errors.Is, by the errors of Service.Get and
+// Service.Delete for objects that don't exist (wbemErrNotFound).
+var ErrNotFound = errors.New("wmi: not found")
+
+// DefaultBackend is the Backend used by clients that don't set one. On
+// Windows it talks to WMI through COM; on other platforms it is nil.
+var DefaultBackend Backend
+
+// A Backend provides access to a WMI implementation. The reflection and
+// decoding logic of Client and SWbemServices only talks to WMI through this
+// interface, which allows it to be replaced in tests.
+type Backend interface {
+	// Locator returns a new Locator. Backends built on COM require the
+	// calling goroutine to be locked to its OS thread until the Locator, and
+	// everything obtained through it, has been released.
+	Locator() (Locator, error)
+}
+
+// A Locator connects to WMI namespaces. It corresponds to an SWbemLocator.
+type Locator interface {
+	// ConnectServer connects to a namespace. See
+	// https://docs.microsoft.com/en-us/windows/desktop/WmiSdk/swbemlocator-connectserver
+	// for the meaning of connectServerArgs. The context argument, the
+	// eighth, may be a map[string]interface{} of named values, as
+	// ConnectOptions.Args returns it.
+	ConnectServer(connectServerArgs ...interface{}) (Service, error)
+	Release()
+}
+
+// A Service is a connection to a WMI namespace. It corresponds to an
+// SWbemServices.
+type Service interface {
+	// ExecQuery runs a WQL query.
+	ExecQuery(query string) (ObjectSet, error)
+	// ExecQuerySemisync runs a WQL query semisynchronously, with the
+	// wbemFlagReturnImmediately and wbemFlagForwardOnly flags. It returns
+	// before the results are ready, and NextTimeout of the ObjectSet waits
+	// for them. Count of the ObjectSet is not supported.
+	ExecQuerySemisync(query string) (ObjectSet, error)
+	// Get returns the class or instance at the given object path.
+	Get(path string) (Object, error)
+	// Delete deletes the class or instance at the given object path.
+	Delete(path string) error
+	// ExecNotificationQuery runs a WQL event query.
+	ExecNotificationQuery(query string) (EventSource, error)
+	// SetSecurity sets the impersonation and authentication levels of the
+	// connection (Security_), unless they are zero, and enables the named
+	// privileges, such as SeShutdownPrivilege.
+	SetSecurity(impersonation ImpersonationLevel, authentication AuthenticationLevel, privileges []string) error
+	Release()
+}
+
+// An EventSource delivers the events of an event query. It corresponds to
+// an SWbemEventSource.
+type EventSource interface {
+	// NextEvent waits up to timeout for the next event and returns it, or
+	// returns ErrTimedOut.
+	NextEvent(timeout time.Duration) (Object, error)
+	Release()
+}
+
+// An ObjectSet holds the result of a query. It corresponds to an
+// SWbemObjectSet.
+type ObjectSet interface {
+	// Count returns the number of objects in the set.
+	Count() (int, error)
+	// Next returns the next object in the set, or io.EOF when there are no
+	// more objects.
+	Next() (Object, error)
+	// NextTimeout is like Next, but returns ErrTimedOut if the next object
+	// isn't available within timeout. Next may not be called after it.
+	NextTimeout(timeout time.Duration) (Object, error)
+	Release()
+}
+
+// An Object is a WMI class or instance. It corresponds to an SWbemObject.
+type Object interface {
+	// GetProperty returns the value of the named property. Values have the
+	// Go types go-ole uses for the VARIANT types returned by the WMI
+	// scripting API, NULL is returned as nil and arrays as []interface{}.
+	// Embedded objects are returned as Objects, which the caller must
+	// release.
+	GetProperty(name string) (interface{}, error)
+	// Properties lists the properties of the object, without the system
+	// properties, in the order of its Properties_ collection.
+	Properties() ([]Property, error)
+	// SetProperty sets the value of the named property of an instance. The
+	// value has the representation GetProperty returns.
+	SetProperty(name string, value interface{}) error
+	// SpawnInstance returns a new instance of a class, which exists only
+	// until it is written with Put.
+	SpawnInstance() (Object, error)
+	// RelPath returns the relative path of the object, such as
+	// Win32_Service.Name="Spooler". For a new instance it is made from the
+	// key properties, and empty if they are not all set.
+	RelPath() (string, error)
+	// Put writes an instance to WMI (Put_) with the given
+	// wbemChangeFlagEnum flags and returns its path.
+	Put(flags int) (string, error)
+	// CallMethod calls the named method with params and returns its result.
+	CallMethod(name string, params ...interface{}) (interface{}, error)
+	// InParameters returns a new instance of the in-parameters of the named
+	// method, to be set and passed to ExecMethod, or nil if the method has
+	// no in-parameters.
+	InParameters(method string) (Object, error)
+	// ExecMethod executes the named method (ExecMethod_) with the given
+	// in-parameters, which may be nil, and returns its out-parameters.
+	ExecMethod(method string, in Object) (Object, error)
+	Release()
+}
+
+// A Property describes a property of an Object.
+type Property struct {
+	Name string
+	Type CIMType
+}
+
+// backend returns the Backend used by c.
+func (c *Client) backend() (Backend, error) {
+	if c.Backend != nil {
+		return c.Backend, nil
+	}
+	if DefaultBackend == nil {
+		return nil, ErrNoBackend
+	}
+	return DefaultBackend, nil
+}
+
+// connectService connects to WMI using the client's backend. If no error is
+// returned, a cleanup function is returned which must be executed (usually
+// deferred) to clean up allocated resources.
+func (c *Client) connectService(connectServerArgs ...interface{}) (Service, func(), error) {
+	backend, err := c.backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	connectServerArgs, opts, err := connectArgs(connectServerArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	locator, err := backend.Locator()
+	if err != nil {
+		return nil, nil, err
+	}
+	service, err := locator.ConnectServer(connectServerArgs...)
+	if err != nil {
+		locator.Release()
+		return nil, nil, err
+	}
+	if err := applySecurity(service, opts); err != nil {
+		service.Release()
+		locator.Release()
+		return nil, nil, err
+	}
+	// be sure teardown happens in the reverse
+	// order from that which they were created
+	return service, func() {
+		service.Release()
+		locator.Release()
+	}, nil
+}
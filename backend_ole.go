@@ -0,0 +1,719 @@
+// +build windows
+
+package wmi
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+func init() {
+	DefaultBackend = oleBackend{}
+}
+
+// oleBackend is the Backend that talks to WMI through COM using the
+// WbemScripting.SWbemLocator scripting object.
+type oleBackend struct{}
+
+// Locator coinitializes COM on the calling thread and creates an
+// SWbemLocator. Releasing the locator uninitializes COM again.
+func (oleBackend) Locator() (Locator, error) {
+	var unknown *ole.IUnknown
+	var dispatch *ole.IDispatch
+
+	// if we error'ed here, clean up immediately
+	var err error
+	defer func() {
+		if err != nil {
+			if dispatch != nil {
+				dispatch.Release()
+			}
+			if unknown != nil {
+				unknown.Release()
+			}
+			ole.CoUninitialize()
+		}
+	}()
+
+	err = ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
+	if err != nil {
+		oleCode := err.(*ole.OleError).Code()
+		if oleCode != ole.S_OK && oleCode != S_FALSE {
+			return nil, err
+		}
+	}
+
+	unknown, err = oleutil.CreateObject("WbemScripting.SWbemLocator")
+	if err != nil {
+		return nil, err
+	} else if unknown == nil {
+		err = ErrNilCreateObject
+		return nil, err
+	}
+
+	dispatch, err = unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, err
+	}
+	return &oleLocator{unknown: unknown, dispatch: dispatch}, nil
+}
+
+type oleLocator struct {
+	unknown  *ole.IUnknown
+	dispatch *ole.IDispatch
+}
+
+func (l *oleLocator) ConnectServer(connectServerArgs ...interface{}) (Service, error) {
+	if len(connectServerArgs) > 7 {
+		if values, ok := connectServerArgs[7].(map[string]interface{}); ok {
+			set, err := newNamedValueSet(values)
+			if err != nil {
+				return nil, err
+			}
+			defer set.Release()
+			connectServerArgs = append(connectServerArgs[:7:7], set)
+		}
+	}
+	// service is a SWbemServices
+	serviceRaw, err := oleutil.CallMethod(l.dispatch, "ConnectServer", connectServerArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return &oleService{raw: serviceRaw, dispatch: serviceRaw.ToIDispatch()}, nil
+}
+
+// newNamedValueSet returns an SWbemNamedValueSet holding values, sorted by
+// name.
+func newNamedValueSet(values map[string]interface{}) (*ole.IDispatch, error) {
+	unknown, err := oleutil.CreateObject("WbemScripting.SWbemNamedValueSet")
+	if err != nil {
+		return nil, err
+	} else if unknown == nil {
+		return nil, ErrNilCreateObject
+	}
+	defer unknown.Release()
+	set, err := unknown.QueryInterface(ole.IID_IDispatch)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		valueRaw, err := oleutil.CallMethod(set, "Add", name, values[name])
+		if err != nil {
+			set.Release()
+			return nil, fmt.Errorf("wmi: context value %s: %v", name, err)
+		}
+		valueRaw.Clear()
+	}
+	return set, nil
+}
+
+func (l *oleLocator) Release() {
+	l.dispatch.Release()
+	l.unknown.Release()
+	ole.CoUninitialize()
+}
+
+type oleService struct {
+	raw      *ole.VARIANT
+	dispatch *ole.IDispatch
+}
+
+// HRESULTs of calls on a broken connection.
+const (
+	rpcServerUnavailable    = 0x800706BA // RPC_S_SERVER_UNAVAILABLE
+	rpcCallFailed           = 0x800706BE // RPC_S_CALL_FAILED
+	rpcCallFailedDNE        = 0x800706BF // RPC_S_CALL_FAILED_DNE
+	rpcDisconnected         = 0x80010108 // RPC_E_DISCONNECTED
+	wbemErrTransportFailure = 0x80041015
+)
+
+// disconnectedError is an error of a broken connection, which matches
+// ErrDisconnected.
+type disconnectedError struct{ err error }
+
+func (e *disconnectedError) Error() string        { return e.err.Error() }
+func (e *disconnectedError) Unwrap() error        { return e.err }
+func (e *disconnectedError) Is(target error) bool { return target == ErrDisconnected }
+
+// checkDisconnected returns err, or a disconnectedError if it is an error of
+// a broken connection.
+func checkDisconnected(err error) error {
+	oleErr, ok := err.(*ole.OleError)
+	if !ok {
+		return err
+	}
+	code := uint32(oleErr.Code())
+	if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+		code = info.SCODE()
+	}
+	switch code {
+	case rpcServerUnavailable, rpcCallFailed, rpcCallFailedDNE, rpcDisconnected, wbemErrTransportFailure:
+		return &disconnectedError{err}
+	}
+	return err
+}
+
+// wbemErrNotFound is the HRESULT of Get and Delete for objects that don't
+// exist.
+const wbemErrNotFound = 0x80041002
+
+// notFoundError is an error of an object that doesn't exist, which matches
+// ErrNotFound.
+type notFoundError struct{ err error }
+
+func (e *notFoundError) Error() string        { return e.err.Error() }
+func (e *notFoundError) Unwrap() error        { return e.err }
+func (e *notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// checkNotFound returns err, or a notFoundError if it is wbemErrNotFound,
+// and otherwise checks it as checkDisconnected does.
+func checkNotFound(err error) error {
+	if oleErr, ok := err.(*ole.OleError); ok {
+		code := uint32(oleErr.Code())
+		if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok {
+			code = info.SCODE()
+		}
+		if code == wbemErrNotFound {
+			return &notFoundError{err}
+		}
+	}
+	return checkDisconnected(err)
+}
+
+func (s *oleService) ExecQuery(query string) (ObjectSet, error) {
+	// result is a SWBemObjectSet
+	resultRaw, err := oleutil.CallMethod(s.dispatch, "ExecQuery", query)
+	if err != nil {
+		return nil, checkDisconnected(err)
+	}
+	return newOLEObjectSet(resultRaw)
+}
+
+// Flags of SWbemServices.ExecQuery for semisynchronous queries.
+const (
+	wbemFlagReturnImmediately = 0x10
+	wbemFlagForwardOnly       = 0x20
+)
+
+func (s *oleService) ExecQuerySemisync(query string) (ObjectSet, error) {
+	resultRaw, err := oleutil.CallMethod(s.dispatch, "ExecQuery", query, "WQL", int32(wbemFlagReturnImmediately|wbemFlagForwardOnly))
+	if err != nil {
+		return nil, checkDisconnected(err)
+	}
+	return newOLEObjectSet(resultRaw)
+}
+
+func (s *oleService) Get(path string) (Object, error) {
+	objectRaw, err := oleutil.CallMethod(s.dispatch, "Get", path)
+	if err != nil {
+		return nil, checkNotFound(err)
+	}
+	return &oleObject{dispatch: objectRaw.ToIDispatch()}, nil
+}
+
+func (s *oleService) Delete(path string) error {
+	resultRaw, err := oleutil.CallMethod(s.dispatch, "Delete", path)
+	if err != nil {
+		return checkNotFound(err)
+	}
+	return resultRaw.Clear()
+}
+
+func (s *oleService) ExecNotificationQuery(query string) (EventSource, error) {
+	// sourceRaw is a SWbemEventSource
+	sourceRaw, err := oleutil.CallMethod(s.dispatch, "ExecNotificationQuery", query)
+	if err != nil {
+		return nil, err
+	}
+	return &oleEventSource{raw: sourceRaw}, nil
+}
+
+func (s *oleService) SetSecurity(impersonation ImpersonationLevel, authentication AuthenticationLevel, privileges []string) error {
+	// security is a SWbemSecurity
+	securityRaw, err := oleutil.GetProperty(s.dispatch, "Security_")
+	if err != nil {
+		return err
+	}
+	defer securityRaw.Clear()
+	security := securityRaw.ToIDispatch()
+
+	if impersonation != 0 {
+		resultRaw, err := oleutil.PutProperty(security, "ImpersonationLevel", int32(impersonation))
+		if err != nil {
+			return err
+		}
+		resultRaw.Clear()
+	}
+	if authentication != 0 {
+		resultRaw, err := oleutil.PutProperty(security, "AuthenticationLevel", int32(authentication))
+		if err != nil {
+			return err
+		}
+		resultRaw.Clear()
+	}
+	if len(privileges) == 0 {
+		return nil
+	}
+	// privileges is a SWbemPrivilegeSet
+	privilegesRaw, err := oleutil.GetProperty(security, "Privileges")
+	if err != nil {
+		return err
+	}
+	defer privilegesRaw.Clear()
+	for _, p := range privileges {
+		resultRaw, err := oleutil.CallMethod(privilegesRaw.ToIDispatch(), "AddAsString", p, true)
+		if err != nil {
+			return fmt.Errorf("wmi: privilege %s: %v", p, err)
+		}
+		resultRaw.Clear()
+	}
+	return nil
+}
+
+func (s *oleService) Release() {
+	s.raw.Clear()
+}
+
+type oleObjectSet struct {
+	raw          *ole.VARIANT
+	enumProperty *ole.VARIANT
+	enum         *ole.IEnumVARIANT
+
+	// The fetch goroutine started by NextTimeout sends the results of Next
+	// to items until stop is closed. fetchErr is set if it couldn't
+	// initialize COM, in which case it has returned.
+	fetching sync.Once
+	items    chan oleNextResult
+	stop     chan struct{}
+	fetchErr error
+}
+
+type oleNextResult struct {
+	item Object
+	err  error
+}
+
+// newOLEObjectSet takes ownership of resultRaw, an SWbemObjectSet.
+func newOLEObjectSet(resultRaw *ole.VARIANT) (*oleObjectSet, error) {
+	set := &oleObjectSet{raw: resultRaw}
+	enumProperty, err := resultRaw.ToIDispatch().GetProperty("_NewEnum")
+	if err != nil {
+		set.Release()
+		return nil, err
+	}
+	set.enumProperty = enumProperty
+
+	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+	if err != nil {
+		set.Release()
+		return nil, err
+	}
+	if enum == nil {
+		set.Release()
+		return nil, fmt.Errorf("can't get IEnumVARIANT, enum is nil")
+	}
+	set.enum = enum
+	return set, nil
+}
+
+func (s *oleObjectSet) Count() (int, error) {
+	count, err := oleInt64(s.raw.ToIDispatch(), "Count")
+	return int(count), err
+}
+
+func (s *oleObjectSet) Next() (Object, error) {
+	itemRaw, length, err := s.enum.Next(1)
+	if length == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, checkDisconnected(err)
+	}
+	// item is a SWbemObject, but really a Win32_Process
+	return &oleObject{dispatch: itemRaw.ToIDispatch()}, nil
+}
+
+// NextTimeout calls Next on a goroutine of its own, since IEnumVARIANT has
+// no timeout, and waits up to timeout for its result. The goroutine keeps
+// fetching objects, one at a time, until the set is released.
+func (s *oleObjectSet) NextTimeout(timeout time.Duration) (Object, error) {
+	s.fetching.Do(func() {
+		s.items = make(chan oleNextResult)
+		s.stop = make(chan struct{})
+		initErr := make(chan error)
+		go s.fetch(initErr)
+		s.fetchErr = <-initErr
+	})
+	if s.fetchErr != nil {
+		return nil, s.fetchErr
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-s.items:
+		return r.item, r.err
+	case <-t.C:
+		return nil, ErrTimedOut
+	}
+}
+
+// fetch sends the results of Next to s.items until Next fails or s.stop is
+// closed, and then releases s. A provider that doesn't respond blocks it
+// until WMI gives up on the call. It reports to initErr whether it could
+// initialize COM first; if not, it returns without touching s.
+func (s *oleObjectSet) fetch(initErr chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// The set lives in the multithreaded apartment, which this thread joins
+	// for as long as it uses the set.
+	if err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED); err != nil && err.(*ole.OleError).Code() != S_FALSE {
+		initErr <- err
+		return
+	}
+	defer ole.CoUninitialize()
+	initErr <- nil
+	defer s.release()
+
+	for {
+		item, err := s.Next()
+		select {
+		case s.items <- oleNextResult{item, err}:
+			if err != nil {
+				<-s.stop
+				return
+			}
+		case <-s.stop:
+			if item != nil {
+				item.Release()
+			}
+			return
+		}
+	}
+}
+
+// Release releases the set, or has the fetch goroutine release it once its
+// current call to Next returns.
+func (s *oleObjectSet) Release() {
+	started := true
+	s.fetching.Do(func() { started = false })
+	if started && s.fetchErr == nil {
+		close(s.stop)
+		return
+	}
+	s.release()
+}
+
+func (s *oleObjectSet) release() {
+	if s.enum != nil {
+		s.enum.Release()
+	}
+	if s.enumProperty != nil {
+		s.enumProperty.Clear()
+	}
+	s.raw.Clear()
+}
+
+// wbemErrTimedOut is the error of SWbemEventSource.NextEvent when no event
+// arrives in time.
+const wbemErrTimedOut = 0x80043001
+
+type oleEventSource struct {
+	raw *ole.VARIANT
+}
+
+func (s *oleEventSource) NextEvent(timeout time.Duration) (Object, error) {
+	eventRaw, err := oleutil.CallMethod(s.raw.ToIDispatch(), "NextEvent", int32(timeout/time.Millisecond))
+	if err != nil {
+		if oleErr, ok := err.(*ole.OleError); ok {
+			if info, ok := oleErr.SubError().(ole.EXCEPINFO); ok && info.SCODE() == wbemErrTimedOut {
+				return nil, ErrTimedOut
+			}
+		}
+		return nil, err
+	}
+	return &oleObject{dispatch: eventRaw.ToIDispatch()}, nil
+}
+
+func (s *oleEventSource) Release() {
+	s.raw.Clear()
+}
+
+type oleObject struct {
+	dispatch *ole.IDispatch
+}
+
+func (o *oleObject) GetProperty(name string) (interface{}, error) {
+	prop, err := oleutil.GetProperty(o.dispatch, name)
+	if err != nil {
+		return nil, err
+	}
+	defer prop.Clear()
+	return oleValue(prop), nil
+}
+
+// Properties enumerates the SWbemPropertySet of the object.
+func (o *oleObject) Properties() ([]Property, error) {
+	setRaw, err := oleutil.GetProperty(o.dispatch, "Properties_")
+	if err != nil {
+		return nil, err
+	}
+	defer setRaw.Clear()
+
+	var props []Property
+	err = oleutil.ForEach(setRaw.ToIDispatch(), func(item *ole.VARIANT) error {
+		defer item.Clear()
+		// item is a SWbemProperty
+		p := item.ToIDispatch()
+		name, err := oleutil.GetProperty(p, "Name")
+		if err != nil {
+			return err
+		}
+		defer name.Clear()
+		cimType, err := oleInt64(p, "CIMType")
+		if err != nil {
+			return err
+		}
+		isArray, err := oleutil.GetProperty(p, "IsArray")
+		if err != nil {
+			return err
+		}
+		defer isArray.Clear()
+		t := CIMType(cimType)
+		if isArray.Val != 0 {
+			t |= CIMTypeArray
+		}
+		props = append(props, Property{Name: name.ToString(), Type: t})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+func (o *oleObject) CallMethod(name string, params ...interface{}) (interface{}, error) {
+	resultRaw, err := oleutil.CallMethod(o.dispatch, name, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer resultRaw.Clear()
+	return oleValue(resultRaw), nil
+}
+
+func (o *oleObject) InParameters(method string) (Object, error) {
+	methodsRaw, err := oleutil.GetProperty(o.dispatch, "Methods_")
+	if err != nil {
+		return nil, err
+	}
+	defer methodsRaw.Clear()
+	// methodRaw is a SWbemMethod
+	methodRaw, err := oleutil.CallMethod(methodsRaw.ToIDispatch(), "Item", method)
+	if err != nil {
+		return nil, err
+	}
+	defer methodRaw.Clear()
+	inRaw, err := oleutil.GetProperty(methodRaw.ToIDispatch(), "InParameters")
+	if err != nil {
+		return nil, err
+	}
+	defer inRaw.Clear()
+	if inRaw.VT != ole.VT_DISPATCH || inRaw.Val == 0 {
+		return nil, nil
+	}
+	instRaw, err := oleutil.CallMethod(inRaw.ToIDispatch(), "SpawnInstance_")
+	if err != nil {
+		return nil, err
+	}
+	return &oleObject{dispatch: instRaw.ToIDispatch()}, nil
+}
+
+func (o *oleObject) ExecMethod(method string, in Object) (Object, error) {
+	params := []interface{}{method}
+	if in != nil {
+		inObj, ok := in.(*oleObject)
+		if !ok {
+			return nil, fmt.Errorf("wmi: in-parameters of type %T", in)
+		}
+		params = append(params, inObj.dispatch)
+	}
+	outRaw, err := oleutil.CallMethod(o.dispatch, "ExecMethod_", params...)
+	if err != nil {
+		return nil, err
+	}
+	return &oleObject{dispatch: outRaw.ToIDispatch()}, nil
+}
+
+func (o *oleObject) SetProperty(name string, value interface{}) error {
+	if arr, ok := value.([]interface{}); ok {
+		sa, err := oleArray(arr)
+		if err != nil {
+			return err
+		}
+		defer sa.Clear()
+		value = sa
+	}
+	v, err := oleutil.PutProperty(o.dispatch, name, value)
+	if err != nil {
+		return err
+	}
+	return v.Clear()
+}
+
+func (o *oleObject) SpawnInstance() (Object, error) {
+	instRaw, err := oleutil.CallMethod(o.dispatch, "SpawnInstance_")
+	if err != nil {
+		return nil, err
+	}
+	return &oleObject{dispatch: instRaw.ToIDispatch()}, nil
+}
+
+func (o *oleObject) RelPath() (string, error) {
+	pathRaw, err := oleutil.GetProperty(o.dispatch, "Path_")
+	if err != nil {
+		return "", err
+	}
+	defer pathRaw.Clear()
+	return olePathString(pathRaw, "RelPath")
+}
+
+func (o *oleObject) Put(flags int) (string, error) {
+	// pathRaw is a SWbemObjectPath
+	pathRaw, err := oleutil.CallMethod(o.dispatch, "Put_", int32(flags))
+	if err != nil {
+		return "", err
+	}
+	defer pathRaw.Clear()
+	return olePathString(pathRaw, "Path")
+}
+
+// olePathString returns the named string property of the SWbemObjectPath in
+// pathRaw.
+func olePathString(pathRaw *ole.VARIANT, name string) (string, error) {
+	v, err := oleutil.GetProperty(pathRaw.ToIDispatch(), name)
+	if err != nil {
+		return "", err
+	}
+	defer v.Clear()
+	if v.VT == ole.VT_NULL {
+		return "", nil
+	}
+	return v.ToString(), nil
+}
+
+func (o *oleObject) Release() {
+	o.dispatch.Release()
+}
+
+// oleValue converts v to the representation documented on Object.GetProperty.
+// Embedded objects (VT_DISPATCH) get a reference of their own, so the result
+// outlives v.
+func oleValue(v *ole.VARIANT) interface{} {
+	if v.VT == ole.VT_NULL {
+		return nil
+	}
+	if v.VT&ole.VT_ARRAY != 0 {
+		safeArray := v.ToArray()
+		if safeArray == nil {
+			return nil
+		}
+		values := safeArray.ToValueArray()
+		for i, e := range values {
+			// Arrays of objects are arrays of VARIANTs, which
+			// ToValueArray copies, so each element already holds a
+			// reference of its own.
+			if d, ok := e.(*ole.IDispatch); ok {
+				values[i] = &oleObject{dispatch: d}
+			}
+		}
+		return values
+	}
+	if v.VT == ole.VT_DISPATCH {
+		d := v.ToIDispatch()
+		if d == nil {
+			return nil
+		}
+		d.AddRef()
+		return &oleObject{dispatch: d}
+	}
+	return v.Value()
+}
+
+var (
+	modoleaut32               = syscall.NewLazyDLL("oleaut32.dll")
+	procSafeArrayCreateVector = modoleaut32.NewProc("SafeArrayCreateVector")
+	procSafeArrayPutElement   = modoleaut32.NewProc("SafeArrayPutElement")
+	procSafeArrayDestroy      = modoleaut32.NewProc("SafeArrayDestroy")
+)
+
+// oleArray converts arr, in the representation of Object.GetProperty, to a
+// VT_ARRAY|VT_VARIANT, which go-ole can't do for property values. The
+// caller must clear the result.
+func oleArray(arr []interface{}) (*ole.VARIANT, error) {
+	sa, _, err := procSafeArrayCreateVector.Call(uintptr(ole.VT_VARIANT), 0, uintptr(len(arr)))
+	if sa == 0 {
+		return nil, err
+	}
+	for i, e := range arr {
+		var v ole.VARIANT
+		switch e := e.(type) {
+		case bool:
+			b := int64(0)
+			if e {
+				b = -1 // VARIANT_TRUE
+			}
+			v = ole.NewVariant(ole.VT_BOOL, b)
+		case uint8:
+			v = ole.NewVariant(ole.VT_UI1, int64(e))
+		case int16:
+			v = ole.NewVariant(ole.VT_I2, int64(e))
+		case int32:
+			v = ole.NewVariant(ole.VT_I4, int64(e))
+		case float32:
+			v = ole.NewVariant(ole.VT_R4, int64(math.Float32bits(e)))
+		case float64:
+			v = ole.NewVariant(ole.VT_R8, int64(math.Float64bits(e)))
+		case string:
+			v = ole.NewVariant(ole.VT_BSTR, int64(uintptr(unsafe.Pointer(ole.SysAllocStringLen(e)))))
+		default:
+			procSafeArrayDestroy.Call(sa)
+			return nil, fmt.Errorf("wmi: unsupported array element type %T", e)
+		}
+		// SafeArrayPutElement copies the element.
+		index := int32(i)
+		hr, _, _ := procSafeArrayPutElement.Call(sa, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&v)))
+		v.Clear()
+		if hr != 0 {
+			procSafeArrayDestroy.Call(sa)
+			return nil, ole.NewError(hr)
+		}
+	}
+	v := ole.NewVariant(ole.VT_ARRAY|ole.VT_VARIANT, int64(sa))
+	return &v, nil
+}
+
+func oleInt64(item *ole.IDispatch, prop string) (int64, error) {
+	v, err := oleutil.GetProperty(item, prop)
+	if err != nil {
+		return 0, err
+	}
+	defer v.Clear()
+
+	i := int64(v.Val)
+	return i, nil
+}
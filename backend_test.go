@@ -0,0 +1,851 @@
+package wmi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubBackend is a Backend serving a fixed set of objects for every query.
+type stubBackend struct {
+	mu       sync.Mutex // guards the fields below objects, for SWbemServices workers
+	objects  []stubObject
+	queries  []string
+	released int
+	// stall makes semisynchronous queries time out after their objects
+	// instead of ending, like a provider that stopped responding.
+	stall bool
+	// security records the calls of SetSecurity.
+	security []stubSecurity
+	// connects counts the calls of ConnectServer, and disconnects is the
+	// number of queries to fail with ErrDisconnected.
+	connects, disconnects int
+	// connectArgs are the arguments of the last call of ConnectServer.
+	connectArgs []interface{}
+	// delay is how long ExecQuery takes, and running and maxRunning count
+	// the calls running at once.
+	delay               time.Duration
+	running, maxRunning int
+}
+
+type stubSecurity struct {
+	impersonation  ImpersonationLevel
+	authentication AuthenticationLevel
+	privileges     []string
+}
+
+type stubObject map[string]interface{}
+
+func (b *stubBackend) Locator() (Locator, error) { return stubLocator{b}, nil }
+
+type stubLocator struct{ b *stubBackend }
+
+func (l stubLocator) ConnectServer(connectServerArgs ...interface{}) (Service, error) {
+	l.b.mu.Lock()
+	defer l.b.mu.Unlock()
+	l.b.connects++
+	l.b.connectArgs = connectServerArgs
+	return stubService(l), nil
+}
+
+func (l stubLocator) Release() { l.b.release() }
+
+type stubService struct{ b *stubBackend }
+
+func (s stubService) ExecQuery(query string) (ObjectSet, error) {
+	if s.b.delay > 0 {
+		s.b.mu.Lock()
+		s.b.running++
+		if s.b.running > s.b.maxRunning {
+			s.b.maxRunning = s.b.running
+		}
+		s.b.mu.Unlock()
+		time.Sleep(s.b.delay)
+		defer func() {
+			s.b.mu.Lock()
+			s.b.running--
+			s.b.mu.Unlock()
+		}()
+	}
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	if s.b.disconnects > 0 {
+		s.b.disconnects--
+		return nil, fmt.Errorf("call failed: %w", ErrDisconnected)
+	}
+	s.b.queries = append(s.b.queries, query)
+	return &stubObjectSet{objects: s.b.objects}, nil
+}
+
+func (s stubService) ExecQuerySemisync(query string) (ObjectSet, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.queries = append(s.b.queries, query)
+	return &stubObjectSet{objects: s.b.objects, stall: s.b.stall}, nil
+}
+
+func (s stubService) Get(path string) (Object, error) {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.queries = append(s.b.queries, "GET "+path)
+	if len(s.b.objects) == 0 {
+		return nil, errors.New("not found")
+	}
+	return s.b.objects[0], nil
+}
+
+func (s stubService) Delete(path string) error {
+	return errors.New("not implemented")
+}
+
+func (s stubService) ExecNotificationQuery(query string) (EventSource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s stubService) SetSecurity(impersonation ImpersonationLevel, authentication AuthenticationLevel, privileges []string) error {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.security = append(s.b.security, stubSecurity{impersonation, authentication, privileges})
+	return nil
+}
+
+func (s stubService) Release() { s.b.release() }
+
+func (b *stubBackend) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released++
+}
+
+type stubObjectSet struct {
+	objects []stubObject
+	stall   bool
+}
+
+func (s *stubObjectSet) Count() (int, error) { return len(s.objects), nil }
+
+func (s *stubObjectSet) Next() (Object, error) {
+	if len(s.objects) == 0 {
+		return nil, io.EOF
+	}
+	o := s.objects[0]
+	s.objects = s.objects[1:]
+	return o, nil
+}
+
+func (s *stubObjectSet) NextTimeout(timeout time.Duration) (Object, error) {
+	if len(s.objects) == 0 && s.stall {
+		time.Sleep(timeout)
+		return nil, ErrTimedOut
+	}
+	return s.Next()
+}
+
+func (s *stubObjectSet) Release() {}
+
+func (o stubObject) GetProperty(name string) (interface{}, error) {
+	v, ok := o[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+// Properties lists the properties of o by name, with a CIM type guessed from
+// their values. NULL properties are strings.
+func (o stubObject) Properties() ([]Property, error) {
+	var props []Property
+	for name, v := range o {
+		t := CIMTypeString
+		switch v := v.(type) {
+		case int32:
+			t = CIMTypeSint32
+		case bool:
+			t = CIMTypeBoolean
+		case stubObject:
+			t = CIMTypeObject
+		case []interface{}:
+			t = CIMTypeString | CIMTypeArray
+			if len(v) > 0 {
+				if _, ok := v[0].(stubObject); ok {
+					t = CIMTypeObject | CIMTypeArray
+				}
+			}
+		}
+		props = append(props, Property{Name: name, Type: t})
+	}
+	sort.Slice(props, func(i, j int) bool { return props[i].Name < props[j].Name })
+	return props, nil
+}
+
+func (o stubObject) CallMethod(name string, params ...interface{}) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (o stubObject) SetProperty(name string, value interface{}) error {
+	o[name] = value
+	return nil
+}
+
+func (o stubObject) SpawnInstance() (Object, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (o stubObject) RelPath() (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (o stubObject) Put(flags int) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (o stubObject) InParameters(method string) (Object, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (o stubObject) ExecMethod(method string, in Object) (Object, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (o stubObject) Release() {}
+
+func TestBackendQuery(t *testing.T) {
+	type stubProcess struct {
+		Name         string
+		ProcessId    uint32
+		VirtualSize  uint64
+		CreationDate time.Time
+		Caption      *string
+		Status       *string
+		Args         []string
+	}
+	b := &stubBackend{objects: []stubObject{
+		{
+			"Name":         "lsass.exe",
+			"ProcessId":    int32(672),
+			"VirtualSize":  "2203387416576",
+			"CreationDate": "20210917100000.000000-060",
+			"Caption":      "lsass.exe",
+			"Status":       nil,
+			"Args":         []interface{}{"-a", "-b"},
+		},
+	}}
+	c := &Client{Backend: b, PtrNil: true}
+	var dst []stubProcess
+	q := CreateQuery(&dst, "WHERE Name = 'lsass.exe'")
+	if err := c.Query(q, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.queries) != 1 || b.queries[0] != q {
+		t.Fatalf("got queries %q, want %q", b.queries, q)
+	}
+	if b.released != 2 {
+		t.Errorf("released %d backend objects, want 2", b.released)
+	}
+	if len(dst) != 1 {
+		t.Fatalf("got %d results, want 1", len(dst))
+	}
+	p := dst[0]
+	if p.Name != "lsass.exe" || p.ProcessId != 672 || p.VirtualSize != 2203387416576 {
+		t.Errorf("bad scalar fields: %+v", p)
+	}
+	if want := time.Date(2021, 9, 17, 10, 0, 0, 0, time.FixedZone("", -60*60)); !p.CreationDate.Equal(want) {
+		t.Errorf("CreationDate = %v, want %v", p.CreationDate, want)
+	}
+	if p.Caption == nil || *p.Caption != "lsass.exe" {
+		t.Errorf("Caption = %v, want lsass.exe", p.Caption)
+	}
+	if p.Status != nil {
+		t.Errorf("Status = %v, want nil", *p.Status)
+	}
+	if len(p.Args) != 2 || p.Args[0] != "-a" || p.Args[1] != "-b" {
+		t.Errorf("Args = %q", p.Args)
+	}
+}
+
+func TestBackendFieldMismatch(t *testing.T) {
+	type s struct {
+		Name string
+		Blah uint32
+	}
+	c := &Client{Backend: &stubBackend{objects: []stubObject{{"Name": "x"}}}}
+	var dst []s
+	err := c.Query("SELECT Name FROM Win32_Process", &dst)
+	if err == nil || err.Error() != `wmi: cannot load field "Blah" into a "uint32": no such struct field` {
+		t.Errorf("got %v, want field mismatch", err)
+	}
+	if len(dst) != 1 || dst[0].Name != "x" {
+		t.Errorf("got %+v", dst)
+	}
+}
+
+func TestBackendNull(t *testing.T) {
+	type s struct {
+		Name   string
+		Count  uint32
+		Status *string
+		Args   []string
+	}
+	b := &stubBackend{objects: []stubObject{{"Name": nil, "Count": nil, "Status": nil, "Args": nil}}}
+	for _, ptrNil := range []bool{false, true} {
+		c := &Client{Backend: b, PtrNil: ptrNil}
+		var dst []s
+		if err := c.Query("SELECT * FROM Win32_Process", &dst); err != nil {
+			t.Fatalf("PtrNil=%v: %v", ptrNil, err)
+		}
+		if len(dst) != 1 {
+			t.Fatalf("PtrNil=%v: got %d results, want 1", ptrNil, len(dst))
+		}
+		p := dst[0]
+		if p.Name != "" || p.Count != 0 || p.Args != nil {
+			t.Errorf("PtrNil=%v: got %+v, want zero values", ptrNil, p)
+		}
+		if ptrNil && p.Status != nil {
+			t.Errorf("PtrNil=true: Status = %q, want nil", *p.Status)
+		}
+		if !ptrNil && (p.Status == nil || *p.Status != "") {
+			t.Errorf("PtrNil=false: Status = %v, want pointer to \"\"", p.Status)
+		}
+	}
+}
+
+func TestBackendStructTags(t *testing.T) {
+	type service struct {
+		_           struct{} `wmi:"Win32_Service"`
+		Name        string
+		DisplayName string `wmi:"Caption"`
+		Class       string `wmi:"__CLASS,noselect"`
+		Notes       string `wmi:"-"`
+		cache       string
+	}
+	var dst []service
+	q := CreateQuery(&dst, "")
+	if want := "SELECT Name, Caption FROM Win32_Service "; q != want {
+		t.Errorf("CreateQuery = %q, want %q", q, want)
+	}
+	if q := CreateQuery(&dst, "", "Win32_BaseService"); q != "SELECT Name, Caption FROM Win32_BaseService " {
+		t.Errorf("CreateQuery with class = %q", q)
+	}
+
+	// Notes and cache are not loaded, so the missing properties are no
+	// field mismatch.
+	c := &Client{Backend: &stubBackend{objects: []stubObject{
+		{"Name": "Spooler", "Caption": "Print Spooler", "__CLASS": "Win32_Service"},
+	}}}
+	if err := c.Query(q, &dst); err != nil {
+		t.Fatal(err)
+	}
+	want := service{Name: "Spooler", DisplayName: "Print Spooler", Class: "Win32_Service"}
+	if len(dst) != 1 || dst[0] != want {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+func TestBackendEmbedded(t *testing.T) {
+	type Common struct {
+		Caption     string
+		Description string
+	}
+	type Extra struct {
+		Status string
+	}
+	type process struct {
+		Name      string
+		ProcessId uint32
+	}
+	type event struct {
+		Common
+		*Extra
+		TargetInstance   process
+		PreviousInstance *process
+		Children         []process
+	}
+	b := &stubBackend{objects: []stubObject{{
+		"Caption":          "created",
+		"Description":      "a process was created",
+		"Status":           "OK",
+		"TargetInstance":   stubObject{"Name": "notepad.exe", "ProcessId": int32(42)},
+		"PreviousInstance": nil,
+		"Children": []interface{}{
+			stubObject{"Name": "a.exe", "ProcessId": int32(1)},
+			stubObject{"Name": "b.exe", "ProcessId": int32(2)},
+		},
+	}}}
+	c := &Client{Backend: b, PtrNil: true}
+	var dst []event
+	q := CreateQuery(&dst, "")
+	if want := "SELECT Caption, Description, Status, TargetInstance, PreviousInstance, Children FROM event "; q != want {
+		t.Errorf("CreateQuery = %q, want %q", q, want)
+	}
+	if err := c.Query(q, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 {
+		t.Fatalf("got %d results, want 1", len(dst))
+	}
+	e := dst[0]
+	if e.Caption != "created" || e.Description != "a process was created" {
+		t.Errorf("Common = %+v", e.Common)
+	}
+	if e.Extra == nil || e.Status != "OK" {
+		t.Errorf("Extra = %+v", e.Extra)
+	}
+	if e.TargetInstance != (process{"notepad.exe", 42}) {
+		t.Errorf("TargetInstance = %+v", e.TargetInstance)
+	}
+	if e.PreviousInstance != nil {
+		t.Errorf("PreviousInstance = %+v, want nil", e.PreviousInstance)
+	}
+	if len(e.Children) != 2 || e.Children[0] != (process{"a.exe", 1}) || e.Children[1] != (process{"b.exe", 2}) {
+		t.Errorf("Children = %+v", e.Children)
+	}
+
+	var bad []struct{ TargetInstance string }
+	err := c.Query("SELECT TargetInstance FROM event", &bad)
+	if _, ok := err.(*ErrFieldMismatch); !ok {
+		t.Errorf("got %v, want field mismatch for object in string field", err)
+	}
+}
+
+func TestBackendRecords(t *testing.T) {
+	b := &stubBackend{objects: []stubObject{{
+		"Name":           "notepad.exe",
+		"ProcessId":      int32(42),
+		"CommandLine":    nil,
+		"Args":           []interface{}{"-a", "-b"},
+		"TargetInstance": stubObject{"Name": "child.exe"},
+	}}}
+	c := &Client{Backend: b}
+	var records []Record
+	if err := c.Query("SELECT * FROM Win32_Process", &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if got, want := r.Names(), []string{"Args", "CommandLine", "Name", "ProcessId", "TargetInstance"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+	if f, ok := r.Field("commandline"); !ok || !f.Null || f.Value != nil || f.Type != CIMTypeString {
+		t.Errorf("CommandLine = %+v, %v", f, ok)
+	}
+	if f, _ := r.Field("ProcessId"); f.Type != CIMTypeSint32 || f.Value != int32(42) || f.Null {
+		t.Errorf("ProcessId = %+v", f)
+	}
+	if v, ok := r.Get("Args").([]string); !ok || !reflect.DeepEqual(v, []string{"-a", "-b"}) {
+		t.Errorf("Args = %#v", r.Get("Args"))
+	}
+	if v, ok := r.Get("TargetInstance").(Record); !ok || v.Get("Name") != "child.exe" {
+		t.Errorf("TargetInstance = %#v", r.Get("TargetInstance"))
+	}
+
+	var maps []map[string]interface{}
+	if err := c.Query("SELECT * FROM Win32_Process", &maps); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Name":           "notepad.exe",
+		"ProcessId":      int32(42),
+		"CommandLine":    nil,
+		"Args":           []string{"-a", "-b"},
+		"TargetInstance": map[string]interface{}{"Name": "child.exe"},
+	}
+	if len(maps) != 1 || !reflect.DeepEqual(maps[0], want) {
+		t.Errorf("got %#v, want %#v", maps, want)
+	}
+}
+
+func TestBackendResolve(t *testing.T) {
+	var dst struct{ Name string }
+	tests := []struct {
+		path string
+		args []interface{}
+		want []interface{}
+	}{
+		{`\\HOST\root\cimv2:Win32_Process.Handle="1"`, nil, []interface{}{"HOST", `root\cimv2`}},
+		{`root\StandardCimv2:MSFT_NetAdapter.DeviceID="1"`, nil, []interface{}{nil, `root\StandardCimv2`}},
+		{`Win32_Process.Handle="1"`, nil, nil},
+		{`\\HOST\root\cimv2:Win32_Process.Handle="1"`, []interface{}{"HOST", `root\cimv2`, `CORP\admin`, "secret"}, []interface{}{"HOST", `root\cimv2`, `CORP\admin`, "secret"}},
+	}
+	for _, tt := range tests {
+		b := &stubBackend{objects: []stubObject{{"Name": "a"}}}
+		p, err := ParsePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Client{Backend: b}).Resolve(p, &dst, tt.args...); err != nil {
+			t.Fatalf("Resolve(%s): %v", tt.path, err)
+		}
+		if !reflect.DeepEqual(b.connectArgs, tt.want) {
+			t.Errorf("Resolve(%s) connected with %#v, want %#v", tt.path, b.connectArgs, tt.want)
+		}
+		if dst.Name != "a" {
+			t.Errorf("Resolve(%s) = %+v", tt.path, dst)
+		}
+	}
+}
+
+func TestBackendCallMethodStructPath(t *testing.T) {
+	p := ObjectPath{Server: "HOST", Namespace: `root\wmi`, Class: "MSNdis", Keys: []Key{{"InstanceName", "1"}}}
+	tests := []struct {
+		target interface{}
+		args   []interface{}
+		want   []interface{}
+	}{
+		{p, nil, []interface{}{"HOST", `root\wmi`}},
+		{&p, nil, []interface{}{"HOST", `root\wmi`}},
+		{p, []interface{}{"HOST", `root\wmi`, `CORP\admin`, "secret"}, []interface{}{"HOST", `root\wmi`, `CORP\admin`, "secret"}},
+		{p.RelPath(), nil, nil},
+	}
+	for _, tt := range tests {
+		b := &stubBackend{objects: []stubObject{{"InstanceName": "1"}}}
+		// The stub can't run methods, so only the connection is checked.
+		(&Client{Backend: b}).CallMethodStruct(tt.target, "WmiQueryAllData", nil, nil, tt.args...)
+		if !reflect.DeepEqual(b.connectArgs, tt.want) {
+			t.Errorf("CallMethodStruct(%v) connected with %#v, want %#v", tt.target, b.connectArgs, tt.want)
+		}
+	}
+}
+
+func TestBackendSWbemServices(t *testing.T) {
+	type s struct {
+		Name string
+	}
+	b := &stubBackend{objects: []stubObject{{"Name": "a"}, {"Name": "b"}}}
+	sw, err := InitializeSWbemServices(&Client{Backend: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst []*s
+	if err := sw.Query("SELECT Name FROM Win32_Process", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 || dst[0].Name != "a" || dst[1].Name != "b" {
+		t.Errorf("got %+v", dst)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackendSWbemServicesCache(t *testing.T) {
+	b := &stubBackend{objects: []stubObject{{"Name": "a"}}}
+	query := func(sw *SWbemServices, connectServerArgs ...interface{}) {
+		t.Helper()
+		var dst []struct{ Name string }
+		if err := sw.Query("SELECT Name FROM Win32_Process", &dst, connectServerArgs...); err != nil || len(dst) != 1 {
+			t.Fatalf("got %+v, %v", dst, err)
+		}
+	}
+
+	sw, err := InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{IdleTimeout: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query(sw)
+	query(sw)
+	query(sw, nil, `ROOT\cimv2`)
+	query(sw, ".", "root/cimv2")
+	query(sw, ConnectOptions{Namespace: `root\cimv2`})
+	if b.connects != 2 {
+		t.Errorf("connected %d times, want 2", b.connects)
+	}
+	b.disconnects = 1
+	query(sw)
+	if b.connects != 3 {
+		t.Errorf("connected %d times after a broken connection, want 3", b.connects)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.connects = 0
+	sw, err = InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{IdleTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query(sw)
+	time.Sleep(50 * time.Millisecond)
+	query(sw)
+	if b.connects != 2 {
+		t.Errorf("connected %d times with an idle connection, want 2", b.connects)
+	}
+	sw.Close()
+
+	b.connects = 0
+	sw, err = InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{IdleTimeout: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	query(sw)
+	query(sw)
+	if b.connects != 2 {
+		t.Errorf("connected %d times without caching, want 2", b.connects)
+	}
+}
+
+func TestBackendSWbemServicesWorkers(t *testing.T) {
+	b := &stubBackend{objects: []stubObject{{"Name": "a"}}, stall: true}
+	var (
+		mu      sync.Mutex
+		metrics []QueryMetrics
+	)
+	record := func(m QueryMetrics) {
+		mu.Lock()
+		defer mu.Unlock()
+		metrics = append(metrics, m)
+	}
+	sw, err := InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{Workers: 2, Metrics: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stalled query holds up one worker only.
+	ctx, cancel := context.WithCancel(context.Background())
+	stalled := make(chan error)
+	go func() {
+		var dst []struct{ Name string }
+		stalled <- sw.QueryContext(ctx, "SELECT Name FROM Win32_Process WHERE Name = 'stalled'", &dst)
+	}()
+	for started := false; !started; {
+		time.Sleep(time.Millisecond)
+		b.mu.Lock()
+		started = len(b.queries) > 0
+		b.mu.Unlock()
+	}
+	var dst []struct{ Name string }
+	if err := sw.Query("SELECT Name FROM Win32_Process", &dst); err != nil || len(dst) != 1 {
+		t.Fatalf("got %+v, %v", dst, err)
+	}
+	cancel()
+	if err := <-stalled; err != context.Canceled {
+		t.Errorf("stalled query: got %v, want %v", err, context.Canceled)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(metrics))
+	}
+	if m := metrics[0]; m.Query != "SELECT Name FROM Win32_Process" || m.Err != nil || m.QueueTime < 0 || m.RunTime < 0 {
+		t.Errorf("got %+v", m)
+	}
+	if m := metrics[1]; m.Err != context.Canceled {
+		t.Errorf("stalled query: got %+v", m)
+	}
+	if metrics[0].Worker == metrics[1].Worker {
+		t.Errorf("both queries ran on worker %d", metrics[0].Worker)
+	}
+
+	// With affinity, the queries to a namespace run on the same worker.
+	b.stall = false
+	metrics = nil
+	sw, err = InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{Workers: 4, Affinity: true, Metrics: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := []string{`root\cimv2`, `root\wmi`, `root\StandardCimv2`, `ROOT\CIMV2`}
+	for i := 0; i < 3; i++ {
+		for _, ns := range namespaces {
+			if err := sw.Query("SELECT Name FROM Win32_Process", &dst, nil, ns); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	sw.Close()
+	for i, m := range metrics {
+		if want := metrics[i%len(namespaces)].Worker; m.Worker != want {
+			t.Errorf("query %d to %s ran on worker %d, want %d", i, namespaces[i%len(namespaces)], m.Worker, want)
+		}
+	}
+	if metrics[0].Worker != metrics[3].Worker {
+		t.Errorf(`queries to root\cimv2 and ROOT\CIMV2 ran on workers %d and %d`, metrics[0].Worker, metrics[3].Worker)
+	}
+
+	if _, err := InitializeSWbemServicesWithOptions(&Client{Backend: b}, SWbemServicesOptions{Workers: -1}); err == nil {
+		t.Error("expected error for a negative number of workers")
+	}
+}
+
+func TestBackendConcurrency(t *testing.T) {
+	query := func(c *Client, n int) {
+		t.Helper()
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var dst []struct{ Name string }
+				errs <- c.Query("SELECT Name FROM Win32_Process", &dst)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	b := &stubBackend{objects: []stubObject{{"Name": "a"}}, delay: 50 * time.Millisecond}
+	query(&Client{Backend: b}, 4)
+	if b.maxRunning < 2 {
+		t.Errorf("ran %d queries at once without a limit, want several", b.maxRunning)
+	}
+
+	b.maxRunning = 0
+	c := &Client{Backend: b, MaxConcurrency: 2}
+	// A copy made before the first call has a limit of its own.
+	early := *c
+	query(c, 6)
+	if b.maxRunning != 2 {
+		t.Errorf("ran %d queries at once with MaxConcurrency 2", b.maxRunning)
+	}
+
+	release := c.limit()
+	defer release()
+	release2 := c.limit()
+	defer release2()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var dst []struct{ Name string }
+	if err := c.QueryContext(ctx, "SELECT Name FROM Win32_Process", &dst); err != context.DeadlineExceeded {
+		t.Errorf("got %v waiting for a turn, want %v", err, context.DeadlineExceeded)
+	}
+	// Copies of a client share its limit.
+	copied := *c
+	if err := copied.QueryContext(ctx, "SELECT Name FROM Win32_Process", &dst); err != context.DeadlineExceeded {
+		t.Errorf("copy: got %v waiting for a turn, want %v", err, context.DeadlineExceeded)
+	}
+	if err := early.QueryContext(context.Background(), "SELECT Name FROM Win32_Process", &dst); err != nil {
+		t.Errorf("copy made before first use: %v", err)
+	}
+}
+
+func TestBackendQueryContext(t *testing.T) {
+	type s struct {
+		Name string
+	}
+	b := &stubBackend{objects: []stubObject{{"Name": "a"}, {"Name": "b"}}}
+	c := &Client{Backend: b}
+	var dst []s
+	if err := c.QueryContext(context.Background(), "SELECT Name FROM Win32_Process", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 || dst[0].Name != "a" || dst[1].Name != "b" {
+		t.Errorf("got %+v", dst)
+	}
+
+	b.stall = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.QueryContext(ctx, "SELECT Name FROM Win32_Process", &dst); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("QueryContext took %v after its deadline", d)
+	}
+
+	sw, err := InitializeSWbemServices(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	if err := sw.QueryContext(ctx, "SELECT Name FROM Win32_Process", &dst); err != context.DeadlineExceeded {
+		t.Errorf("SWbemServices: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	b.stall = false
+	dst = nil
+	if err := sw.QueryContext(context.Background(), "SELECT Name FROM Win32_Process", &dst); err != nil || len(dst) != 2 {
+		t.Errorf("SWbemServices: got %+v, %v", dst, err)
+	}
+}
+
+func TestBackendSecurity(t *testing.T) {
+	b := &stubBackend{objects: []stubObject{{"Message": "x"}}}
+	c := &Client{Backend: b}
+	var dst []struct{ Message string }
+	if err := c.Query("SELECT Message FROM Win32_NTLogEvent", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.security) != 0 {
+		t.Errorf("SetSecurity called without security settings: %+v", b.security)
+	}
+
+	opts := ConnectOptions{
+		ImpersonationLevel: ImpersonationImpersonate,
+		Privileges:         []string{"SeSecurityPrivilege"},
+	}
+	want := stubSecurity{ImpersonationImpersonate, AuthenticationDefault, []string{"SeSecurityPrivilege"}}
+	if err := c.Query("SELECT Message FROM Win32_NTLogEvent WHERE Logfile = 'Security'", &dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.security) != 1 || !reflect.DeepEqual(b.security[0], want) {
+		t.Errorf("got %+v, want %+v", b.security, want)
+	}
+
+	sw, err := InitializeSWbemServices(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	if err := sw.Query("SELECT Message FROM Win32_NTLogEvent WHERE Logfile = 'Security'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.security) != 2 || !reflect.DeepEqual(b.security[1], want) {
+		t.Errorf("SWbemServices: got %+v, want %+v", b.security, want)
+	}
+
+	opts.Privileges = []string{"Shutdown"}
+	if err := c.Query("SELECT Message FROM Win32_NTLogEvent", &dst, opts); err == nil {
+		t.Error("expected error for invalid privilege")
+	}
+}
+
+func TestNoBackend(t *testing.T) {
+	if DefaultBackend != nil {
+		t.Skip("platform has a default backend")
+	}
+	var dst []struct{ Name string }
+	if err := (&Client{}).Query("SELECT Name FROM Win32_Process", &dst); err != ErrNoBackend {
+		t.Errorf("got %v, want ErrNoBackend", err)
+	}
+}
+
+// BenchmarkBackendQueryParallel compares the throughput of concurrent
+// queries to a backend that takes a millisecond per query, one at a time as
+// with the global lock that Client used to take, and with MaxConcurrency.
+func BenchmarkBackendQueryParallel(b *testing.B) {
+	for _, max := range []int{1, 4, 0} {
+		name := fmt.Sprintf("MaxConcurrency=%d", max)
+		b.Run(name, func(b *testing.B) {
+			c := &Client{
+				Backend:        &stubBackend{objects: []stubObject{{"Name": "a"}}, delay: time.Millisecond},
+				MaxConcurrency: max,
+			}
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				var dst []struct{ Name string }
+				for pb.Next() {
+					dst = dst[:0]
+					if err := c.Query("SELECT Name FROM Win32_Process", &dst); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
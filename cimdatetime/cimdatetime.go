@@ -0,0 +1,289 @@
+// Package cimdatetime parses and formats CIM DATETIME values, the string
+// form WMI uses for datetime properties.
+//
+// A DATETIME is either an absolute time,
+//
+//	yyyymmddHHMMSS.mmmmmmsUUU   20210917100000.000000-060
+//
+// where sUUU is the offset from UTC in minutes, or an interval,
+//
+//	ddddddddHHMMSS.mmmmmm:000   00000001123000.000000:000
+//
+// of days, hours, minutes, seconds and microseconds, such as the uptime of
+// a system or a timeout. Any field may be written as asterisks to leave it
+// unspecified, and the trailing digits of the microseconds may be asterisks
+// to give a lower precision, as in 20210917100000.123***+000.
+//
+// Absolute times convert to time.Time and intervals to time.Duration:
+//
+//	t, err := cimdatetime.ParseTime("20210917100000.000000-060")
+//	s, err := cimdatetime.FormatInterval(90 * time.Minute) // 00000000013000.000000:000
+package cimdatetime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Len is the length of a DATETIME string.
+const Len = 25
+
+// A Field is a set of fields of a DATETIME.
+type Field uint
+
+// Fields of a DATETIME. Days is the day count of intervals; the other fields
+// except Offset apply to both forms.
+const (
+	Year Field = 1 << iota
+	Month
+	Day
+	Hour
+	Minute
+	Second
+	Offset
+	Days
+)
+
+// A Datetime is a parsed DATETIME value.
+type Datetime struct {
+	// Interval specifies whether the value is an interval rather than an
+	// absolute time.
+	Interval bool
+	// Time is the value of an absolute time, in a fixed zone of its offset.
+	// Unspecified fields are the zero value of their time.Date argument,
+	// except for the month and day, which are 1, and the offset, which is
+	// UTC.
+	Time time.Time
+	// Duration is the value of an interval. Unspecified fields are zero.
+	Duration time.Duration
+	// Wildcards are the fields written as asterisks.
+	Wildcards Field
+	// MicroWildcards is the number of trailing microsecond digits written
+	// as asterisks, from 0 to 6.
+	MicroWildcards int
+}
+
+// A ParseError describes a malformed DATETIME.
+type ParseError struct {
+	Value string
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("cimdatetime: parsing %q: %s", e.Value, e.Msg)
+}
+
+// Parse parses an absolute time or an interval.
+func Parse(s string) (Datetime, error) {
+	if len(s) != Len || s[14] != '.' {
+		return Datetime{}, &ParseError{s, "not a DATETIME"}
+	}
+	p := parser{s: s}
+	var d Datetime
+	micro := p.micro(&d)
+	if s[21] == ':' {
+		d.Interval = true
+		if s[22:] != "000" {
+			return Datetime{}, &ParseError{s, "interval must end in :000"}
+		}
+		days := p.field(0, 8, Days, &d)
+		hour := p.field(8, 2, Hour, &d)
+		min := p.field(10, 2, Minute, &d)
+		sec := p.field(12, 2, Second, &d)
+		if p.err != nil {
+			return Datetime{}, p.err
+		}
+		if hour > 23 || min > 59 || sec > 59 {
+			return Datetime{}, &ParseError{s, "interval field out of range"}
+		}
+		if days > math.MaxInt64/int64(24*time.Hour) {
+			return Datetime{}, &ParseError{s, "interval out of range"}
+		}
+		d.Duration = time.Duration(days)*24*time.Hour +
+			time.Duration(hour)*time.Hour +
+			time.Duration(min)*time.Minute +
+			time.Duration(sec)*time.Second +
+			time.Duration(micro)*time.Microsecond
+		return d, nil
+	}
+
+	sign := s[21]
+	if sign != '+' && sign != '-' {
+		return Datetime{}, &ParseError{s, "missing offset sign"}
+	}
+	year := p.field(0, 4, Year, &d)
+	month := p.field(4, 2, Month, &d)
+	day := p.field(6, 2, Day, &d)
+	hour := p.field(8, 2, Hour, &d)
+	min := p.field(10, 2, Minute, &d)
+	sec := p.field(12, 2, Second, &d)
+	offset := p.field(22, 3, Offset, &d)
+	if p.err != nil {
+		return Datetime{}, p.err
+	}
+	if d.Wildcards&Month != 0 {
+		month = 1
+	}
+	if d.Wildcards&Day != 0 {
+		day = 1
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || min > 59 || sec > 60 {
+		return Datetime{}, &ParseError{s, "field out of range"}
+	}
+	if sign == '-' {
+		offset = -offset
+	}
+	loc := time.UTC
+	if d.Wildcards&Offset == 0 {
+		loc = time.FixedZone("", int(offset)*60)
+	}
+	d.Time = time.Date(int(year), time.Month(month), int(day), int(hour), int(min), int(sec), int(micro)*1000, loc)
+	return d, nil
+}
+
+// ParseTime parses an absolute time.
+func ParseTime(s string) (time.Time, error) {
+	d, err := Parse(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if d.Interval {
+		return time.Time{}, &ParseError{s, "interval is not an absolute time"}
+	}
+	return d.Time, nil
+}
+
+// ParseInterval parses an interval.
+func ParseInterval(s string) (time.Duration, error) {
+	d, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if !d.Interval {
+		return 0, &ParseError{s, "absolute time is not an interval"}
+	}
+	return d.Duration, nil
+}
+
+// IsInterval reports whether s has the form of an interval. It does not
+// check the fields.
+func IsInterval(s string) bool {
+	return len(s) == Len && s[21] == ':'
+}
+
+type parser struct {
+	s   string
+	err error
+}
+
+// field parses the n-digit field at offset i, which may be all asterisks.
+func (p *parser) field(i, n int, f Field, d *Datetime) int64 {
+	v := p.s[i : i+n]
+	if v == strings.Repeat("*", n) {
+		d.Wildcards |= f
+		return 0
+	}
+	if !isDigits(v) {
+		if p.err == nil {
+			p.err = &ParseError{p.s, fmt.Sprintf("invalid field %q", v)}
+		}
+		return 0
+	}
+	x, _ := strconv.ParseInt(v, 10, 64)
+	return x
+}
+
+// micro parses the microseconds, whose trailing digits may be asterisks.
+func (p *parser) micro(d *Datetime) int64 {
+	v := p.s[15:21]
+	digits := strings.TrimRight(v, "*")
+	if !isDigits(digits) {
+		p.err = &ParseError{p.s, fmt.Sprintf("invalid microseconds %q", v)}
+		return 0
+	}
+	d.MicroWildcards = len(v) - len(digits)
+	x, _ := strconv.ParseInt(digits+strings.Repeat("0", d.MicroWildcards), 10, 64)
+	return x
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats d, writing its wildcard fields as asterisks.
+func (d Datetime) String() string {
+	var b strings.Builder
+	put := func(f Field, n int, v int64) {
+		if d.Wildcards&f != 0 {
+			b.WriteString(strings.Repeat("*", n))
+			return
+		}
+		fmt.Fprintf(&b, "%0*d", n, v)
+	}
+	var micro int64
+	if d.Interval {
+		dur := d.Duration
+		if dur < 0 {
+			dur = -dur
+		}
+		put(Days, 8, int64(dur/(24*time.Hour)))
+		put(Hour, 2, int64(dur/time.Hour%24))
+		put(Minute, 2, int64(dur/time.Minute%60))
+		put(Second, 2, int64(dur/time.Second%60))
+		micro = int64(dur / time.Microsecond % 1e6)
+	} else {
+		t := d.Time
+		put(Year, 4, int64(t.Year()))
+		put(Month, 2, int64(t.Month()))
+		put(Day, 2, int64(t.Day()))
+		put(Hour, 2, int64(t.Hour()))
+		put(Minute, 2, int64(t.Minute()))
+		put(Second, 2, int64(t.Second()))
+		micro = int64(t.Nanosecond() / 1000)
+	}
+	b.WriteByte('.')
+	n := 6 - d.MicroWildcards
+	if n < 0 {
+		n = 0
+	}
+	digits := fmt.Sprintf("%06d", micro)[:n]
+	b.WriteString(digits + strings.Repeat("*", 6-n))
+	if d.Interval {
+		b.WriteString(":000")
+		return b.String()
+	}
+	_, offset := d.Time.Zone()
+	sign := byte('+')
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	b.WriteByte(sign)
+	put(Offset, 3, int64(offset/60))
+	return b.String()
+}
+
+// FormatTime formats t as an absolute DATETIME. The offset of t is rounded
+// down to whole minutes and t to microseconds.
+func FormatTime(t time.Time) string {
+	return Datetime{Time: t}.String()
+}
+
+// FormatInterval formats d as an interval DATETIME, truncated to
+// microseconds. It is an error for d to be negative, which an interval
+// can't express.
+func FormatInterval(d time.Duration) (string, error) {
+	if d < 0 {
+		return "", errors.New("cimdatetime: negative interval")
+	}
+	return Datetime{Interval: true, Duration: d}.String(), nil
+}
@@ -0,0 +1,89 @@
+package cimdatetime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Datetime
+	}{
+		{"20210917100000.123456-060", Datetime{Time: time.Date(2021, 9, 17, 10, 0, 0, 123456000, time.FixedZone("", -3600))}},
+		{"20210917100000.000000+330", Datetime{Time: time.Date(2021, 9, 17, 10, 0, 0, 0, time.FixedZone("", 330*60))}},
+		{"20210917100000.123***+000", Datetime{Time: time.Date(2021, 9, 17, 10, 0, 0, 123000000, time.UTC), MicroWildcards: 3}},
+		{"2021********00.******+***", Datetime{
+			Time:           time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			Wildcards:      Month | Day | Hour | Minute | Offset,
+			MicroWildcards: 6,
+		}},
+		{"00000001123000.000500:000", Datetime{Interval: true, Duration: 24*time.Hour + 12*time.Hour + 30*time.Minute + 500*time.Microsecond}},
+		{"********000010.******:000", Datetime{Interval: true, Duration: 10 * time.Second, Wildcards: Days, MicroWildcards: 6}},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.in, err)
+			continue
+		}
+		if got.Interval != test.want.Interval || !got.Time.Equal(test.want.Time) || got.Duration != test.want.Duration ||
+			got.Wildcards != test.want.Wildcards || got.MicroWildcards != test.want.MicroWildcards {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+		_, off := got.Time.Zone()
+		if _, want := test.want.Time.Zone(); off != want {
+			t.Errorf("Parse(%q) has offset %d, want %d", test.in, off, want)
+		}
+		if s := got.String(); s != test.in {
+			t.Errorf("Parse(%q).String() = %q", test.in, s)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"20210917100000.000000",
+		"20210917100000,000000+000",
+		"20210917100000.000000*000",
+		"2021091710000a.000000+000",
+		"20211317100000.000000+000",
+		"20210917250000.000000+000",
+		"2021*917100000.000000+000",
+		"20210917100000.*12345+000",
+		"00000001240000.000000:000",
+		"00000001000000.000000:001",
+		"99999999000000.000000:000",
+	} {
+		if d, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %+v, want error", in, d)
+		}
+	}
+	if _, err := ParseTime("00000001000000.000000:000"); err == nil {
+		t.Error("ParseTime accepted an interval")
+	}
+	if _, err := ParseInterval("20210917100000.000000+000"); err == nil {
+		t.Error("ParseInterval accepted an absolute time")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tm := time.Date(2021, 9, 17, 10, 0, 0, 123456789, time.FixedZone("", -90*60))
+	if got, want := FormatTime(tm), "20210917100000.123456-090"; got != want {
+		t.Errorf("FormatTime = %q, want %q", got, want)
+	}
+	s, err := FormatInterval(90*time.Minute + 1500*time.Nanosecond)
+	if err != nil || s != "00000000013000.000001:000" {
+		t.Errorf("FormatInterval = %q, %v", s, err)
+	}
+	if d, err := ParseInterval(s); err != nil || d != 90*time.Minute+time.Microsecond {
+		t.Errorf("ParseInterval(%q) = %v, %v", s, d, err)
+	}
+	if _, err := FormatInterval(-time.Second); err == nil {
+		t.Error("FormatInterval accepted a negative duration")
+	}
+	if !IsInterval(s) || IsInterval(FormatTime(tm)) {
+		t.Error("IsInterval")
+	}
+}
@@ -0,0 +1,72 @@
+package wmi
+
+import "fmt"
+
+// CIMType is the type of a WMI property, as reported by the CIMType property
+// of an SWbemProperty. See
+// https://docs.microsoft.com/en-us/windows/win32/wmisdk/swbemproperty for
+// details.
+type CIMType int
+
+// CIM types. Arrays are represented by combining CIMTypeArray with the type
+// of their elements.
+const (
+	CIMTypeSint16    CIMType = 2
+	CIMTypeSint32    CIMType = 3
+	CIMTypeReal32    CIMType = 4
+	CIMTypeReal64    CIMType = 5
+	CIMTypeString    CIMType = 8
+	CIMTypeBoolean   CIMType = 11
+	CIMTypeObject    CIMType = 13
+	CIMTypeSint8     CIMType = 16
+	CIMTypeUint8     CIMType = 17
+	CIMTypeUint16    CIMType = 18
+	CIMTypeUint32    CIMType = 19
+	CIMTypeSint64    CIMType = 20
+	CIMTypeUint64    CIMType = 21
+	CIMTypeDatetime  CIMType = 101
+	CIMTypeReference CIMType = 102
+	CIMTypeChar16    CIMType = 103
+
+	CIMTypeArray CIMType = 0x2000
+)
+
+var cimTypeNames = map[CIMType]string{
+	CIMTypeSint16:    "sint16",
+	CIMTypeSint32:    "sint32",
+	CIMTypeReal32:    "real32",
+	CIMTypeReal64:    "real64",
+	CIMTypeString:    "string",
+	CIMTypeBoolean:   "boolean",
+	CIMTypeObject:    "object",
+	CIMTypeSint8:     "sint8",
+	CIMTypeUint8:     "uint8",
+	CIMTypeUint16:    "uint16",
+	CIMTypeUint32:    "uint32",
+	CIMTypeSint64:    "sint64",
+	CIMTypeUint64:    "uint64",
+	CIMTypeDatetime:  "datetime",
+	CIMTypeReference: "ref",
+	CIMTypeChar16:    "char16",
+}
+
+// IsArray reports whether t is an array type.
+func (t CIMType) IsArray() bool {
+	return t&CIMTypeArray != 0
+}
+
+// Elem returns the element type of an array type, or t itself otherwise.
+func (t CIMType) Elem() CIMType {
+	return t &^ CIMTypeArray
+}
+
+func (t CIMType) String() string {
+	name, ok := cimTypeNames[t.Elem()]
+	if !ok {
+		name = fmt.Sprintf("CIMType(%d)", int(t.Elem()))
+	}
+	if t.IsArray() {
+		return name + "[]"
+	}
+	return name
+}
@@ -0,0 +1,152 @@
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConnectFlagUseMaxWait is the wbemConnectFlagUseMaxWait security flag of
+// ConnectOptions: connecting fails after two minutes instead of waiting
+// indefinitely for the server.
+const ConnectFlagUseMaxWait = 0x80
+
+// ConnectOptions are the arguments of SWbemLocator.ConnectServer, which
+// select the server and namespace to connect to and the credentials to use.
+// Zero fields take their defaults: the local machine, the default namespace
+// (usually root\cimv2), and the credentials of the current user.
+//
+// Every function and method that takes connectServerArgs accepts a single
+// ConnectOptions, or a pointer to one, instead of the positional arguments:
+//
+//	err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst, wmi.ConnectOptions{
+//		Namespace: `root\StandardCimv2`,
+//	})
+type ConnectOptions struct {
+	// Server is the name or address of the computer to connect to. Empty
+	// and "." are the local machine.
+	Server string
+	// Namespace is the namespace to connect to, such as root\cimv2.
+	Namespace string
+	// User and Password are the credentials for a remote connection. User
+	// may be given as DOMAIN\user or user@domain, unless Authority names
+	// the domain. WMI doesn't accept credentials for the local machine.
+	User     string
+	Password string
+	// Locale is the locale of the connection, in the form MS_xxx, where
+	// xxx is a hexadecimal LCID such as 409 for U.S. English.
+	Locale string
+	// Authority is the authentication method and domain, in the form
+	// Kerberos:principal or NTLMDomain:domain.
+	Authority string
+	// SecurityFlags is 0 or ConnectFlagUseMaxWait.
+	SecurityFlags int
+	// ContextValues are passed to the provider as an SWbemNamedValueSet.
+	ContextValues map[string]interface{}
+
+	// ImpersonationLevel and AuthenticationLevel are set on the connection
+	// (SWbemServices.Security_) before it is used, unless they are zero.
+	ImpersonationLevel  ImpersonationLevel
+	AuthenticationLevel AuthenticationLevel
+	// Privileges are enabled on the connection before it is used, as
+	// required by some classes and methods, such as SeSecurityPrivilege to
+	// query the Security log with Win32_NTLogEvent or SeShutdownPrivilege to
+	// call Win32_OperatingSystem.Reboot.
+	Privileges []string
+}
+
+// Validate checks that the options are consistent and well-formed.
+func (o ConnectOptions) Validate() error {
+	if o.Password != "" && o.User == "" {
+		return errors.New("wmi: connect options: Password requires User")
+	}
+	if o.User != "" && (o.Server == "" || o.Server == ".") {
+		return errors.New("wmi: connect options: User and Password can't be used for the local machine")
+	}
+	if o.Locale != "" {
+		lcid := strings.TrimPrefix(o.Locale, "MS_")
+		if _, err := strconv.ParseUint(lcid, 16, 32); err != nil || lcid == o.Locale {
+			return fmt.Errorf("wmi: connect options: invalid locale %q, want MS_xxx", o.Locale)
+		}
+	}
+	if o.Authority != "" {
+		i := strings.IndexByte(o.Authority, ':')
+		if i < 0 || !strings.EqualFold(o.Authority[:i], "Kerberos") && !strings.EqualFold(o.Authority[:i], "NTLMDomain") {
+			return fmt.Errorf("wmi: connect options: invalid authority %q, want Kerberos:principal or NTLMDomain:domain", o.Authority)
+		}
+		if strings.EqualFold(o.Authority[:i], "NTLMDomain") && strings.ContainsAny(o.User, `\@`) {
+			return errors.New("wmi: connect options: User can't name a domain when Authority does")
+		}
+	}
+	if o.SecurityFlags&^ConnectFlagUseMaxWait != 0 {
+		return fmt.Errorf("wmi: connect options: invalid security flags %#x", o.SecurityFlags)
+	}
+	for name := range o.ContextValues {
+		if name == "" {
+			return errors.New("wmi: connect options: context value without a name")
+		}
+	}
+	return o.checkSecurity()
+}
+
+// Args validates the options and returns them as the positional
+// connectServerArgs of SWbemLocator.ConnectServer. Empty fields are nil,
+// and trailing ones are left out. ContextValues are passed as a
+// map[string]interface{}, which backends turn into an SWbemNamedValueSet.
+// The security settings are not arguments of ConnectServer; they are applied
+// to the connection afterwards.
+func (o ConnectOptions) Args() ([]interface{}, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, 8)
+	for i, s := range []string{o.Server, o.Namespace, o.User, o.Password, o.Locale, o.Authority} {
+		if s != "" {
+			args[i] = s
+		}
+	}
+	if o.SecurityFlags != 0 {
+		args[6] = int32(o.SecurityFlags)
+	}
+	if len(o.ContextValues) > 0 {
+		args[7] = o.ContextValues
+	}
+	for len(args) > 0 && args[len(args)-1] == nil {
+		args = args[:len(args)-1]
+	}
+	return args, nil
+}
+
+// connectArgs returns the arguments of Locator.ConnectServer for the
+// connectServerArgs given to a Client or SWbemServices method, which are
+// either positional or a single ConnectOptions. In the latter case it also
+// returns the options, whose security settings are to be applied to the
+// connection with applySecurity.
+func connectArgs(args []interface{}) ([]interface{}, *ConnectOptions, error) {
+	var o ConnectOptions
+	switch x := singleArg(args).(type) {
+	case ConnectOptions:
+		o = x
+	case *ConnectOptions:
+		if x == nil {
+			return nil, nil, nil
+		}
+		o = *x
+	default:
+		return args, nil, nil
+	}
+	args, err := o.Args()
+	if err != nil {
+		return nil, nil, err
+	}
+	return args, &o, nil
+}
+
+// singleArg returns the only element of args, or nil.
+func singleArg(args []interface{}) interface{} {
+	if len(args) != 1 {
+		return nil
+	}
+	return args[0]
+}
@@ -0,0 +1,73 @@
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConnectOptionsArgs(t *testing.T) {
+	tests := []struct {
+		opts ConnectOptions
+		want []interface{}
+	}{
+		{ConnectOptions{}, []interface{}{}},
+		{ConnectOptions{Namespace: `root\StandardCimv2`}, []interface{}{nil, `root\StandardCimv2`}},
+		{
+			ConnectOptions{Server: "host", User: `DOMAIN\user`, Password: "secret", Locale: "MS_409", SecurityFlags: ConnectFlagUseMaxWait},
+			[]interface{}{"host", nil, `DOMAIN\user`, "secret", "MS_409", nil, int32(0x80)},
+		},
+		{
+			ConnectOptions{Server: "host", User: "user", Authority: "NTLMDomain:DOMAIN", ContextValues: map[string]interface{}{"__ProviderArchitecture": 64}},
+			[]interface{}{"host", nil, "user", nil, nil, "NTLMDomain:DOMAIN", nil, map[string]interface{}{"__ProviderArchitecture": 64}},
+		},
+	}
+	for _, tt := range tests {
+		args, err := tt.opts.Args()
+		if err != nil {
+			t.Errorf("%+v: %v", tt.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.want) {
+			t.Errorf("%+v: got %#v, want %#v", tt.opts, args, tt.want)
+		}
+	}
+}
+
+func TestConnectOptionsValidate(t *testing.T) {
+	for _, opts := range []ConnectOptions{
+		{Server: "host", Password: "secret"},
+		{User: "user", Password: "secret"},
+		{Server: ".", User: "user"},
+		{Locale: "409"},
+		{Locale: "MS_english"},
+		{Authority: "DOMAIN"},
+		{Authority: "Basic:DOMAIN"},
+		{Server: "host", User: `DOMAIN\user`, Authority: "NTLMDomain:DOMAIN"},
+		{SecurityFlags: 1},
+		{ContextValues: map[string]interface{}{"": 1}},
+		{ImpersonationLevel: 5},
+		{AuthenticationLevel: -1},
+		{Privileges: []string{"Shutdown"}},
+		{Privileges: []string{"SeShutdown Privilege"}},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
+
+func TestConnectArgs(t *testing.T) {
+	positional := []interface{}{nil, `root\wmi`}
+	if args, _, err := connectArgs(positional); err != nil || !reflect.DeepEqual(args, positional) {
+		t.Errorf("positional: got %#v, %v", args, err)
+	}
+	want := []interface{}{nil, `root\wmi`}
+	for _, o := range []interface{}{ConnectOptions{Namespace: `root\wmi`}, &ConnectOptions{Namespace: `root\wmi`}} {
+		if args, _, err := connectArgs([]interface{}{o}); err != nil || !reflect.DeepEqual(args, want) {
+			t.Errorf("%T: got %#v, %v", o, args, err)
+		}
+	}
+	if _, _, err := connectArgs([]interface{}{ConnectOptions{Password: "secret"}}); err == nil {
+		t.Error("expected error for invalid options")
+	}
+}
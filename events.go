@@ -0,0 +1,99 @@
+package wmi
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+)
+
+// Subscribe runs the WQL event query and sends each event to ch until ctx
+// is done, for example:
+//
+//	type Win32_Process struct {
+//		Name      string
+//		ProcessId uint32
+//	}
+//	type processEvent struct {
+//		TargetInstance Win32_Process
+//	}
+//	ch := make(chan processEvent)
+//	go func() {
+//		for e := range ch {
+//			fmt.Println("started", e.TargetInstance.Name)
+//		}
+//	}()
+//	err := c.Subscribe(ctx, "SELECT * FROM __InstanceCreationEvent WITHIN 1 WHERE TargetInstance ISA 'Win32_Process'", ch)
+//
+// ch must be a channel of S or *S, for some struct type S, or of Record or
+// map[string]interface{}. Events are decoded as in Query, so embedded objects
+// such as the TargetInstance and PreviousInstance of intrinsic events decode
+// into struct fields.
+//
+// Subscribe blocks until ctx is done, when it returns ctx.Err(), or until the
+// subscription fails. It runs the subscription on its own locked OS thread,
+// like SWbemServices, so it doesn't hold up queries, and it doesn't close ch.
+// Field mismatches in an event are ignored rather than ending the
+// subscription.
+//
+// See Query for connectServerArgs.
+func (c *Client) Subscribe(ctx context.Context, query string, ch interface{}, connectServerArgs ...interface{}) error {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.SendDir == 0 {
+		return ErrInvalidEntityType
+	}
+	mat, elemType := checkElemType(cv.Type().Elem())
+	if mat == multiArgTypeInvalid {
+		return ErrInvalidEntityType
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		errc <- c.subscribe(ctx, query, cv, mat, elemType, connectServerArgs)
+	}()
+	return <-errc
+}
+
+func (c *Client) subscribe(ctx context.Context, query string, cv reflect.Value, mat multiArgType, elemType reflect.Type, connectServerArgs []interface{}) error {
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	events, err := service.ExecNotificationQuery(query)
+	if err != nil {
+		return err
+	}
+	defer events.Release()
+
+	done := reflect.ValueOf(ctx.Done())
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		event, err := events.NextEvent(pollInterval)
+		if err == ErrTimedOut {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ev, err := c.loadElem(event, mat, elemType)
+		event.Release()
+		if err != nil {
+			if _, ok := err.(*ErrFieldMismatch); !ok {
+				return err
+			}
+		}
+
+		chosen, _, _ := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectSend, Chan: cv, Send: ev},
+			{Dir: reflect.SelectRecv, Chan: done},
+		})
+		if chosen == 1 {
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Package fields maps Go struct types to the WMI properties they hold. It is
+// shared by package wmi, which decodes query results into structs, and
+// package wql, which builds queries from them.
+//
+// A field holds the property of the same name unless its wmi tag says
+// otherwise:
+//
+//	Name   string `wmi:"Caption"`          // holds the Caption property
+//	Secret string `wmi:"-"`                // holds no property
+//	Class  string `wmi:"__CLASS,noselect"` // decoded but not selected
+//	_      struct{} `wmi:"Win32_Service"`  // the class, for all of the struct
+//
+// Unexported fields hold no property. The fields of embedded structs are
+// promoted to the outer struct, unless the embedded struct has a wmi name:
+//
+//	type Win32_Process struct {
+//		CommonCIMFields // Caption, Description, ...
+//		ProcessId uint32
+//	}
+package fields
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Field is a struct field that holds a WMI property.
+type Field struct {
+	Name     string // property name
+	GoName   string // name of the struct field
+	Index    []int  // for reflect.Value.FieldByIndex
+	NoSelect bool   // left out of generated SELECT lists
+}
+
+// A Struct describes the properties held by a struct type.
+type Struct struct {
+	Class  string // class name from the tag of a _ field, or the type name
+	Fields []Field
+}
+
+// Selected returns the names of the properties that queries for the struct
+// select, in field order.
+func (s *Struct) Selected() []string {
+	names := make([]string, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		if !f.NoSelect {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+var cache sync.Map // map[reflect.Type]*Struct
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Of returns the description of the struct type t.
+//
+// The fields of anonymous struct fields without a wmi name are promoted as
+// in encoding/json: of several fields holding the same property, the least
+// nested one wins, and if there are several of those, the tagged one.
+// Otherwise none of them holds the property.
+func Of(t reflect.Type) *Struct {
+	if s, ok := cache.Load(t); ok {
+		return s.(*Struct)
+	}
+	s := &Struct{Class: t.Name()}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if tag, ok := sf.Tag.Lookup("wmi"); ok && sf.Name == "_" && tag != "" {
+			s.Class = tag
+		}
+	}
+	var all []candidate
+	collect(t, nil, map[reflect.Type]bool{t: true}, &all)
+	s.Fields = dominant(all)
+	cache.Store(t, s)
+	return s
+}
+
+// A candidate is a field that may hold a property.
+type candidate struct {
+	Field
+	tagged bool
+}
+
+// collect appends the fields of the struct type t, nested at index, to all.
+// Seen holds the struct types being visited, to stop at recursive types.
+func collect(t reflect.Type, index []int, seen map[reflect.Type]bool, all *[]candidate) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("wmi")
+		if sf.Name == "_" || tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		fi := make([]int, len(index)+1)
+		copy(fi, index)
+		fi[len(index)] = i
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			isPtr := ft.Kind() == reflect.Ptr
+			if isPtr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				// A pointer to an unexported type can't be allocated.
+				if !(isPtr && sf.PkgPath != "") && !seen[ft] {
+					seen[ft] = true
+					collect(ft, fi, seen, all)
+					delete(seen, ft)
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+		*all = append(*all, candidate{
+			Field: Field{
+				Name:     name,
+				GoName:   sf.Name,
+				Index:    fi,
+				NoSelect: opts.has("noselect"),
+			},
+			tagged: tagged,
+		})
+	}
+}
+
+// dominant returns the fields of all that hold a property, in the order of
+// all. Property names are case-insensitive.
+func dominant(all []candidate) []Field {
+	byName := make(map[string][]int)
+	for i, c := range all {
+		k := strings.ToLower(c.Name)
+		byName[k] = append(byName[k], i)
+	}
+	var fields []Field
+	for i, c := range all {
+		group := byName[strings.ToLower(c.Name)]
+		if best, ok := pick(all, group); ok && best == i {
+			fields = append(fields, c.Field)
+		}
+	}
+	return fields
+}
+
+// pick returns the index of the candidate in group that holds the property.
+func pick(all []candidate, group []int) (int, bool) {
+	depth := -1
+	var top []int
+	for _, i := range group {
+		d := len(all[i].Index)
+		switch {
+		case depth < 0 || d < depth:
+			depth, top = d, []int{i}
+		case d == depth:
+			top = append(top, i)
+		}
+	}
+	if len(top) == 1 {
+		return top[0], true
+	}
+	best := -1
+	for _, i := range top {
+		if all[i].tagged {
+			if best >= 0 {
+				return 0, false
+			}
+			best = i
+		}
+	}
+	return best, best >= 0
+}
+
+// Value returns the field f of the struct v, allocating nil pointers to
+// embedded structs on the way. v must be addressable.
+func (f Field) Value(v reflect.Value) reflect.Value {
+	for i, x := range f.Index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// Lookup returns the field f of the struct v, or false if a nil pointer to
+// an embedded struct leaves it out.
+func (f Field) Lookup(v reflect.Value) (reflect.Value, bool) {
+	for i, x := range f.Index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+func (o tagOptions) has(name string) bool {
+	for o != "" {
+		var opt string
+		if i := strings.IndexByte(string(o), ','); i >= 0 {
+			opt, o = string(o[:i]), o[i+1:]
+		} else {
+			opt, o = string(o), ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StructType returns the struct type S of v, which must be an S, []S or []*S,
+// or a pointer to one of those. It reports false for any other type.
+func StructType(v interface{}) (reflect.Type, bool) {
+	if v == nil {
+		return nil, false
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	return t, true
+}
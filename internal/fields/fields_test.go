@@ -0,0 +1,118 @@
+package fields
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	type service struct {
+		_           struct{} `wmi:"Win32_Service"`
+		Name        string
+		DisplayName string `wmi:"Caption"`
+		Class       string `wmi:"__CLASS,noselect"`
+		State       string `wmi:",noselect"`
+		Started     bool   `json:"started" wmi:"Started,other"`
+		notes       string
+		Skipped     string `wmi:"-"`
+	}
+	s := Of(reflect.TypeOf(service{}))
+	if s.Class != "Win32_Service" {
+		t.Errorf("Class = %q, want Win32_Service", s.Class)
+	}
+	want := []Field{
+		{Name: "Name", GoName: "Name", Index: []int{1}},
+		{Name: "Caption", GoName: "DisplayName", Index: []int{2}},
+		{Name: "__CLASS", GoName: "Class", Index: []int{3}, NoSelect: true},
+		{Name: "State", GoName: "State", Index: []int{4}, NoSelect: true},
+		{Name: "Started", GoName: "Started", Index: []int{5}},
+	}
+	if !reflect.DeepEqual(s.Fields, want) {
+		t.Errorf("Fields:\ngot  %+v\nwant %+v", s.Fields, want)
+	}
+	if got, want := s.Selected(), []string{"Name", "Caption", "Started"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Selected() = %q, want %q", got, want)
+	}
+	if Of(reflect.TypeOf(service{})) != s {
+		t.Error("Of is not cached")
+	}
+
+	type Win32_Process struct{ Name string }
+	if s := Of(reflect.TypeOf(Win32_Process{})); s.Class != "Win32_Process" {
+		t.Errorf("Class = %q, want Win32_Process", s.Class)
+	}
+}
+
+type Common struct {
+	Caption     string
+	Description string
+	Name        string
+}
+
+type Extra struct {
+	Status string
+	Label  string `wmi:"Description"`
+}
+
+type unexported struct{ Hidden string }
+
+func TestOfEmbedded(t *testing.T) {
+	type process struct {
+		Common
+		*Extra
+		*unexported
+		Named     Common `wmi:"Nested"`
+		Name      string
+		ProcessId uint32
+	}
+	s := Of(reflect.TypeOf(process{}))
+	// Name of the outer struct wins over Common.Name, and the tagged
+	// Extra.Label over Common.Description at the same depth.
+	want := []Field{
+		{Name: "Caption", GoName: "Caption", Index: []int{0, 0}},
+		{Name: "Status", GoName: "Status", Index: []int{1, 0}},
+		{Name: "Description", GoName: "Label", Index: []int{1, 1}},
+		{Name: "Nested", GoName: "Named", Index: []int{3}},
+		{Name: "Name", GoName: "Name", Index: []int{4}},
+		{Name: "ProcessId", GoName: "ProcessId", Index: []int{5}},
+	}
+	if !reflect.DeepEqual(s.Fields, want) {
+		t.Errorf("Fields:\ngot  %+v\nwant %+v", s.Fields, want)
+	}
+
+	var p process
+	v := reflect.ValueOf(&p).Elem()
+	s.Fields[2].Value(v).SetString("x")
+	if p.Extra == nil || p.Label != "x" {
+		t.Errorf("Value did not allocate the embedded pointer: %+v", p)
+	}
+
+	type ambiguous struct {
+		Common
+		Other struct{ Caption string }
+		*Recursive
+	}
+	if s := Of(reflect.TypeOf(ambiguous{})); len(s.Fields) != 5 {
+		t.Errorf("Fields = %+v", s.Fields)
+	}
+}
+
+type Recursive struct {
+	*Recursive
+	Depth int
+}
+
+func TestStructType(t *testing.T) {
+	type S struct{}
+	st := reflect.TypeOf(S{})
+	for _, v := range []interface{}{S{}, &S{}, []S{}, &[]S{}, []*S{}, &[]*S{}} {
+		if got, ok := StructType(v); !ok || got != st {
+			t.Errorf("StructType(%T) = %v, %v", v, got, ok)
+		}
+	}
+	for _, v := range []interface{}{nil, 3, &[]int{}, &[]**S{}} {
+		if got, ok := StructType(v); ok {
+			t.Errorf("StructType(%T) = %v, want false", v, got)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package wmi
+
+import (
+	"context"
+	"sync"
+)
+
+// limiterMu guards the creation of Client.limiter. It is a package variable
+// rather than a field so that Client values can still be copied.
+var limiterMu sync.Mutex
+
+// limit waits for a turn to run a call under c.MaxConcurrency and returns
+// the function that ends it.
+func (c *Client) limit() func() {
+	release, _ := c.limitContext(context.Background())
+	return release
+}
+
+// limitContext is like limit, but gives up when ctx is done and returns
+// ctx.Err().
+func (c *Client) limitContext(ctx context.Context) (func(), error) {
+	if c.MaxConcurrency <= 0 {
+		return func() {}, nil
+	}
+	limiterMu.Lock()
+	if c.limiter == nil {
+		c.limiter = make(chan struct{}, c.MaxConcurrency)
+	}
+	limiter := c.limiter
+	limiterMu.Unlock()
+	select {
+	case limiter <- struct{}{}:
+		return func() { <-limiter }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
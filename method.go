@@ -0,0 +1,194 @@
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/StackExchange/wmi/internal/fields"
+)
+
+// CallMethodStruct calls the named method of a class or instance with the
+// in-parameters in in and decodes its out-parameters into out.
+//
+// CallMethodStruct is a wrapper around DefaultClient.CallMethodStruct.
+func CallMethodStruct(target interface{}, method string, in, out interface{}, connectServerArgs ...interface{}) error {
+	return DefaultClient.CallMethodStruct(target, method, in, out, connectServerArgs...)
+}
+
+// CallMethodStruct calls the named method of a class or instance and decodes
+// its out-parameters into out.
+//
+// target is the name of a class, such as Win32_Process, for static methods,
+// or for instance methods the object path of an instance, as a string such
+// as Win32_Service.Name="Spooler" or an ObjectPath, or a struct, or a
+// pointer to one, that was queried from the instance. The path of the struct
+// is taken from its __PATH or __RELPATH field if it has one and is made from
+// its key properties otherwise, so the struct must include them:
+//
+//	type Win32_Service struct {
+//		Name  string
+//		State string
+//	}
+//	var services []Win32_Service
+//	err := wmi.Query("SELECT Name, State FROM Win32_Service WHERE State = 'Running'", &services)
+//	...
+//	var out struct{ ReturnValue uint32 }
+//	err = wmi.CallMethodStruct(&services[0], "StopService", nil, &out)
+//
+// in is a struct, or a pointer to one, whose fields are the in-parameters of
+// the method, encoded as in Put; it may be nil, or a nil pointer, if the
+// method takes no parameters. out is a pointer to a struct that the
+// out-parameters are decoded into as in Query, including the ReturnValue of
+// the method; it may be nil to ignore them. For example:
+//
+//	in := struct{ CommandLine string }{`notepad.exe`}
+//	var out struct {
+//		ProcessId   uint32
+//		ReturnValue uint32
+//	}
+//	err := wmi.CallMethodStruct("Win32_Process", "Create", in, &out)
+//
+// A non-zero ReturnValue usually reports a failure of the method, which is
+// left to the caller to check.
+//
+// See Query for connectServerArgs. Without them, an ObjectPath target is
+// called on its own server and namespace, like Resolve does.
+func (c *Client) CallMethodStruct(target interface{}, method string, in, out interface{}, connectServerArgs ...interface{}) error {
+	var iv reflect.Value
+	if in != nil {
+		iv = reflect.ValueOf(in)
+		if iv.Kind() == reflect.Ptr {
+			// A nil pointer means no parameters, like a nil in.
+			iv = iv.Elem()
+		}
+		if iv.IsValid() && iv.Kind() != reflect.Struct {
+			return ErrInvalidEntityType
+		}
+	}
+	if out != nil {
+		ov := reflect.ValueOf(out)
+		if ov.Kind() != reflect.Ptr || ov.IsNil() || ov.Elem().Kind() != reflect.Struct {
+			return ErrInvalidEntityType
+		}
+	}
+	switch t := target.(type) {
+	case ObjectPath:
+		connectServerArgs = t.connectServerArgs(connectServerArgs)
+	case *ObjectPath:
+		if t != nil {
+			connectServerArgs = t.connectServerArgs(connectServerArgs)
+		}
+	}
+
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	path, err := targetPath(service, target)
+	if err != nil {
+		return err
+	}
+	obj, err := service.Get(path)
+	if err != nil {
+		return err
+	}
+	defer obj.Release()
+
+	params, err := obj.InParameters(method)
+	if err != nil {
+		return fmt.Errorf("wmi: method %s: %v", method, err)
+	}
+	if params != nil {
+		defer params.Release()
+		if iv.IsValid() {
+			values, err := encodeFields(params, path+"."+method, fields.Of(iv.Type()), iv)
+			if err != nil {
+				return err
+			}
+			for _, pv := range values {
+				if err := params.SetProperty(pv.name, pv.value); err != nil {
+					return fmt.Errorf("wmi: cannot set parameter %s of %s: %v", pv.name, method, err)
+				}
+			}
+		}
+	} else if iv.IsValid() && len(fields.Of(iv.Type()).Fields) > 0 {
+		return fmt.Errorf("wmi: method %s has no in-parameters", method)
+	}
+
+	result, err := obj.ExecMethod(method, params)
+	if err != nil {
+		return fmt.Errorf("wmi: %s.%s: %v", path, method, err)
+	}
+	defer result.Release()
+	if out == nil {
+		return nil
+	}
+	return c.loadEntity(out, result)
+}
+
+// targetPath returns the object path of the target of a method call, as
+// documented on CallMethodStruct.
+func targetPath(service Service, target interface{}) (string, error) {
+	switch t := target.(type) {
+	case string:
+		return t, nil
+	case ObjectPath:
+		return t.String(), nil
+	case *ObjectPath:
+		if t != nil {
+			return t.String(), nil
+		}
+	}
+	v := reflect.ValueOf(target)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", ErrInvalidEntityType
+	}
+	s := fields.Of(v.Type())
+	for _, field := range s.Fields {
+		if !strings.EqualFold(field.Name, "__PATH") && !strings.EqualFold(field.Name, "__RELPATH") {
+			continue
+		}
+		f, ok := field.Lookup(v)
+		if ok && f.Kind() == reflect.String && f.String() != "" {
+			return f.String(), nil
+		}
+	}
+
+	// Make the path from the key properties, as Put does.
+	if s.Class == "" {
+		return "", fmt.Errorf("wmi: cannot call a method on %s without its class", v.Type())
+	}
+	cls, err := service.Get(s.Class)
+	if err != nil {
+		return "", err
+	}
+	defer cls.Release()
+	values, err := encodeFields(cls, s.Class, s, v)
+	if err != nil {
+		return "", err
+	}
+	inst, err := spawnInstance(cls, s.Class, values)
+	if err != nil {
+		return "", err
+	}
+	defer inst.Release()
+	relPath, err := inst.RelPath()
+	if err != nil {
+		return "", err
+	}
+	if relPath == "" {
+		return "", fmt.Errorf("wmi: cannot call a method on an instance of %s without its key properties", s.Class)
+	}
+	return relPath, nil
+}
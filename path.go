@@ -0,0 +1,339 @@
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An ObjectPath is the path of a WMI class or instance, as found in the
+// __PATH and __RELPATH system properties and in reference properties:
+//
+//	\\HOST\root\cimv2:Win32_Service.Name="Spooler"
+//	Win32_LogicalDiskToPartition.Antecedent="...",Dependent="..."
+//	Win32_OperatingSystem=@
+//
+// Server and Namespace are empty for a relative path. A path without keys
+// is a class path, unless it is the path of a singleton instance.
+//
+// Query decodes reference properties, such as the Antecedent and Dependent
+// of an association, into ObjectPath fields, and Put encodes them. Resolve
+// fetches the object a path refers to.
+type ObjectPath struct {
+	Server    string
+	Namespace string
+	Class     string
+	// Keys are the key properties of an instance, in the order of the path.
+	Keys []Key
+	// Singleton specifies whether the path is that of the only instance of
+	// a singleton class, Class=@.
+	Singleton bool
+}
+
+// A Key is a key property in an ObjectPath. Values are strings, int64s,
+// uint64s for integers beyond the range of int64, and bools. A string may
+// itself hold an object path, for reference keys.
+//
+// The name is empty in the short form Class="value" of a path to an
+// instance of a class with a single key.
+type Key struct {
+	Name  string
+	Value interface{}
+}
+
+// ParsePath parses a full or relative object path. Backslashes and forward
+// slashes separate the server and namespace, and key values may be quoted
+// with double or single quotes.
+func ParsePath(s string) (ObjectPath, error) {
+	var p ObjectPath
+	rest := s
+	if strings.HasPrefix(rest, `\\`) || strings.HasPrefix(rest, "//") {
+		rest = rest[2:]
+		i := strings.IndexAny(rest, `\/`)
+		if i <= 0 {
+			return ObjectPath{}, pathError(s, "missing namespace")
+		}
+		p.Server, rest = rest[:i], rest[i+1:]
+	}
+	// A namespace ends with the only colon before the class name.
+	if i := strings.IndexByte(rest, ':'); i >= 0 && i < strings.IndexAny(rest+".=", `.="'`) {
+		// Namespaces may be separated by forward slashes too, but String
+		// writes backslashes.
+		p.Namespace, rest = strings.Replace(rest[:i], "/", `\`, -1), rest[i+1:]
+		if p.Namespace == "" {
+			return ObjectPath{}, pathError(s, "empty namespace")
+		}
+	} else if p.Server != "" {
+		return ObjectPath{}, pathError(s, "missing namespace")
+	}
+
+	i := strings.IndexAny(rest, ".=")
+	if i < 0 {
+		i = len(rest)
+	}
+	p.Class, rest = rest[:i], rest[i:]
+	if !isIdent(p.Class) {
+		return ObjectPath{}, pathError(s, "invalid class name")
+	}
+	switch {
+	case rest == "":
+		return p, nil
+	case rest == "=@":
+		p.Singleton = true
+		return p, nil
+	case rest[0] == '=':
+		v, n, err := parseKeyValue(rest[1:])
+		if err != nil {
+			return ObjectPath{}, pathError(s, err.Error())
+		}
+		if n != len(rest)-1 {
+			return ObjectPath{}, pathError(s, "unexpected text after key value")
+		}
+		p.Keys = []Key{{Value: v}}
+		return p, nil
+	}
+
+	rest = rest[1:] // '.'
+	for {
+		i := strings.IndexByte(rest, '=')
+		if i < 0 {
+			return ObjectPath{}, pathError(s, "missing key value")
+		}
+		name := rest[:i]
+		if !isIdent(name) {
+			return ObjectPath{}, pathError(s, fmt.Sprintf("invalid key name %q", name))
+		}
+		v, n, err := parseKeyValue(rest[i+1:])
+		if err != nil {
+			return ObjectPath{}, pathError(s, err.Error())
+		}
+		p.Keys = append(p.Keys, Key{Name: name, Value: v})
+		rest = rest[i+1+n:]
+		if rest == "" {
+			return p, nil
+		}
+		if rest[0] != ',' {
+			return ObjectPath{}, pathError(s, "unexpected text after key value")
+		}
+		rest = rest[1:]
+	}
+}
+
+func pathError(s, msg string) error {
+	return fmt.Errorf("wmi: invalid object path %q: %s", s, msg)
+}
+
+func isIdent(s string) bool {
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// parseKeyValue parses the key value at the start of s and returns it and
+// its length.
+func parseKeyValue(s string) (interface{}, int, error) {
+	if s == "" {
+		return nil, 0, errors.New("missing key value")
+	}
+	if q := s[0]; q == '"' || q == '\'' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; c {
+			case q:
+				return b.String(), i + 1, nil
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					c = s[i]
+				}
+				b.WriteByte(c)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil, 0, errors.New("unterminated key value")
+	}
+
+	n := strings.IndexByte(s, ',')
+	if n < 0 {
+		n = len(s)
+	}
+	text := s[:n]
+	switch {
+	case strings.EqualFold(text, "TRUE"):
+		return true, n, nil
+	case strings.EqualFold(text, "FALSE"):
+		return false, n, nil
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, n, nil
+	}
+	if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+		return u, n, nil
+	}
+	return nil, 0, fmt.Errorf("invalid key value %q", text)
+}
+
+// String formats p as ParsePath accepts it. It is a relative path unless p
+// has a server or namespace; a server without a namespace gets the default
+// namespace, root\cimv2. Quotes and backslashes in string values are
+// escaped.
+func (p ObjectPath) String() string {
+	rel := p.RelPath()
+	switch {
+	case p.Server != "":
+		ns := p.Namespace
+		if ns == "" {
+			ns = `root\cimv2`
+		}
+		return `\\` + p.Server + `\` + ns + ":" + rel
+	case p.Namespace != "":
+		return p.Namespace + ":" + rel
+	}
+	return rel
+}
+
+// RelPath returns the path relative to its namespace, as in __RELPATH.
+func (p ObjectPath) RelPath() string {
+	if p.Singleton {
+		return p.Class + "=@"
+	}
+	var b strings.Builder
+	b.WriteString(p.Class)
+	for i, k := range p.Keys {
+		if i == 0 {
+			if k.Name != "" {
+				b.WriteByte('.')
+			}
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(k.Name)
+		b.WriteByte('=')
+		b.WriteString(formatKeyValue(k.Value))
+	}
+	return b.String()
+}
+
+func formatKeyValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return quoteKeyValue(x)
+	case ObjectPath:
+		return quoteKeyValue(x.String())
+	case bool:
+		if x {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return fmt.Sprint(v)
+}
+
+func quoteKeyValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p ObjectPath) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text is the zero
+// ObjectPath.
+func (p *ObjectPath) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = ObjectPath{}
+		return nil
+	}
+	q, err := ParsePath(string(text))
+	if err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+// IsClass reports whether p is the path of a class rather than an instance.
+func (p ObjectPath) IsClass() bool {
+	return len(p.Keys) == 0 && !p.Singleton
+}
+
+// IsRelative reports whether p has neither a server nor a namespace.
+func (p ObjectPath) IsRelative() bool {
+	return p.Server == "" && p.Namespace == ""
+}
+
+// Key returns the value of the named key property. Names are
+// case-insensitive; the empty name matches a key given without one.
+func (p ObjectPath) Key(name string) (interface{}, bool) {
+	for _, k := range p.Keys {
+		if strings.EqualFold(k.Name, name) {
+			return k.Value, true
+		}
+	}
+	return nil, false
+}
+
+// KeyString returns the value of the named key property if it is a string.
+func (p ObjectPath) KeyString(name string) (string, bool) {
+	v, ok := p.Key(name)
+	s, isString := v.(string)
+	return s, ok && isString
+}
+
+// Resolve fetches the class or instance at path into dst, which must be a
+// pointer to a struct. Fields are decoded as in Query.
+//
+// See Query for connectServerArgs. Without them, Resolve connects to the
+// server and namespace of path, or to the defaults for those path leaves
+// out. connectServerArgs should connect to the same server and namespace.
+func (c *Client) Resolve(path ObjectPath, dst interface{}, connectServerArgs ...interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidEntityType
+	}
+	connectServerArgs = path.connectServerArgs(connectServerArgs)
+
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	obj, err := service.Get(path.String())
+	if err != nil {
+		return err
+	}
+	defer obj.Release()
+	return c.loadEntity(dst, obj)
+}
+
+// connectServerArgs returns args, or if there are none, the arguments that
+// connect to the server and namespace of p.
+func (p ObjectPath) connectServerArgs(args []interface{}) []interface{} {
+	if len(args) > 0 || p.IsRelative() {
+		return args
+	}
+	args = []interface{}{nil, nil}
+	if p.Server != "" {
+		args[0] = p.Server
+	}
+	if p.Namespace != "" {
+		args[1] = p.Namespace
+	}
+	return args
+}
@@ -0,0 +1,143 @@
+package wmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ObjectPath
+		out  string // String(), if not in
+	}{
+		{
+			in:   `\\HOST\root\cimv2:Win32_Service.Name="Spooler"`,
+			want: ObjectPath{Server: "HOST", Namespace: `root\cimv2`, Class: "Win32_Service", Keys: []Key{{"Name", "Spooler"}}},
+		},
+		{
+			in:   `//host.example.com/root/cimv2:Win32_Service.Name="Spooler"`,
+			want: ObjectPath{Server: "host.example.com", Namespace: `root\cimv2`, Class: "Win32_Service", Keys: []Key{{"Name", "Spooler"}}},
+			out:  `\\host.example.com\root\cimv2:Win32_Service.Name="Spooler"`,
+		},
+		{
+			in:   `root/StandardCimv2:MSFT_NetAdapter.DeviceID="{1}"`,
+			want: ObjectPath{Namespace: `root\StandardCimv2`, Class: "MSFT_NetAdapter", Keys: []Key{{"DeviceID", "{1}"}}},
+			out:  `root\StandardCimv2:MSFT_NetAdapter.DeviceID="{1}"`,
+		},
+		{
+			in:   `root\StandardCimv2:MSFT_NetAdapter.CreationClassName="MSFT_NetAdapter",DeviceID="{1}",SystemCreationClassName="CIM_NetworkPort",SystemName="HOST"`,
+			want: ObjectPath{Namespace: `root\StandardCimv2`, Class: "MSFT_NetAdapter", Keys: []Key{{"CreationClassName", "MSFT_NetAdapter"}, {"DeviceID", "{1}"}, {"SystemCreationClassName", "CIM_NetworkPort"}, {"SystemName", "HOST"}}},
+		},
+		{
+			in:   `Win32_OperatingSystem=@`,
+			want: ObjectPath{Class: "Win32_OperatingSystem", Singleton: true},
+		},
+		{
+			in:   `Win32_Service`,
+			want: ObjectPath{Class: "Win32_Service"},
+		},
+		{
+			in:   `\\.\root\cimv2:Win32_Process`,
+			want: ObjectPath{Server: ".", Namespace: `root\cimv2`, Class: "Win32_Process"},
+		},
+		{
+			in:   `Win32_Process.Handle='672'`,
+			want: ObjectPath{Class: "Win32_Process", Keys: []Key{{"Handle", "672"}}},
+			out:  `Win32_Process.Handle="672"`,
+		},
+		{
+			in:   `Win32_Directory.Name="C:\\Program Files\\\"x\""`,
+			want: ObjectPath{Class: "Win32_Directory", Keys: []Key{{"Name", `C:\Program Files\"x"`}}},
+		},
+		{
+			in:   `Win32_LogicalDisk="C:"`,
+			want: ObjectPath{Class: "Win32_LogicalDisk", Keys: []Key{{"", "C:"}}},
+		},
+		{
+			in:   `Win32_Thing.Count=-3,Big=18446744073709551615,On=TRUE`,
+			want: ObjectPath{Class: "Win32_Thing", Keys: []Key{{"Count", int64(-3)}, {"Big", uint64(1<<64 - 1)}, {"On", true}}},
+		},
+		{
+			in: `Win32_LogicalDiskToPartition.Antecedent="\\\\HOST\\root\\cimv2:Win32_DiskPartition.DeviceID=\"Disk #0, Partition #1\"",Dependent="\\\\HOST\\root\\cimv2:Win32_LogicalDisk.DeviceID=\"C:\""`,
+			want: ObjectPath{Class: "Win32_LogicalDiskToPartition", Keys: []Key{
+				{"Antecedent", `\\HOST\root\cimv2:Win32_DiskPartition.DeviceID="Disk #0, Partition #1"`},
+				{"Dependent", `\\HOST\root\cimv2:Win32_LogicalDisk.DeviceID="C:"`},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.in)
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, tt.want) {
+			t.Errorf("ParsePath(%q) = %#v, want %#v", tt.in, p, tt.want)
+		}
+		out := tt.out
+		if out == "" {
+			out = tt.in
+		}
+		if s := p.String(); s != out {
+			t.Errorf("ParsePath(%q).String() = %q, want %q", tt.in, s, out)
+		}
+		if p2, err := ParsePath(p.String()); err != nil || !reflect.DeepEqual(p2, p) {
+			t.Errorf("ParsePath(%q) = %#v, %v after round trip", p.String(), p2, err)
+		}
+	}
+}
+
+func TestParsePathErrors(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`\\HOST`,
+		`\\HOST\Win32_Service`,
+		`:Win32_Service`,
+		`Win32_Service.`,
+		`Win32_Service.Name`,
+		`Win32_Service.Name=`,
+		`Win32_Service.Name="Spooler`,
+		`Win32_Service.Name="Spooler"x`,
+		`Win32_Service.Name="Spooler",`,
+		`Win32_Service.Name=Spooler`,
+		`Win32_Service.="Spooler"`,
+		`Win32 Service`,
+		`1Win32_Service`,
+	} {
+		if p, err := ParsePath(s); err == nil {
+			t.Errorf("ParsePath(%q) = %#v, want error", s, p)
+		}
+	}
+}
+
+func TestObjectPathKeys(t *testing.T) {
+	p, err := ParsePath(`Win32_Process.Handle="672"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsClass() || !p.IsRelative() {
+		t.Errorf("IsClass() = %v, IsRelative() = %v", p.IsClass(), p.IsRelative())
+	}
+	if v, ok := p.KeyString("handle"); !ok || v != "672" {
+		t.Errorf(`KeyString("handle") = %q, %v`, v, ok)
+	}
+	if _, ok := p.Key("Name"); ok {
+		t.Error(`Key("Name") found`)
+	}
+	ref := ObjectPath{Class: "Win32_LogicalDiskToPartition", Keys: []Key{{"Dependent", p}}}
+	if want := `Win32_LogicalDiskToPartition.Dependent="Win32_Process.Handle=\"672\""`; ref.String() != want {
+		t.Errorf("got %s, want %s", ref, want)
+	}
+}
+
+func TestObjectPathServerOnly(t *testing.T) {
+	p := ObjectPath{Server: "HOST", Class: "Win32_Service", Keys: []Key{{"Name", "Spooler"}}}
+	want := `\\HOST\root\cimv2:Win32_Service.Name="Spooler"`
+	if s := p.String(); s != want {
+		t.Errorf("String() = %s, want %s", s, want)
+	}
+	if _, err := ParsePath(p.String()); err != nil {
+		t.Error(err)
+	}
+}
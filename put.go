@@ -0,0 +1,386 @@
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StackExchange/wmi/cimdatetime"
+	"github.com/StackExchange/wmi/internal/fields"
+)
+
+// PutMode specifies whether Put creates or updates an instance. Its values
+// are the wbemChangeFlagEnum flags of Put_.
+type PutMode int
+
+const (
+	// CreateOrUpdate creates the instance if it doesn't exist and updates
+	// it otherwise.
+	CreateOrUpdate PutMode = 0
+	// UpdateOnly only updates an existing instance.
+	UpdateOnly PutMode = 1
+	// CreateOnly only creates a new instance.
+	CreateOnly PutMode = 2
+)
+
+// PutOptions are the options of Client.Put.
+type PutOptions struct {
+	Mode PutMode
+	// Class is the class of the instance. If empty, it is taken from the
+	// struct type as in CreateQuery.
+	Class string
+	// ZeroFields writes the fields with zero values, such as empty strings
+	// and nil pointers, when updating an instance. By default they are left
+	// out, since they are usually properties that the struct wasn't filled
+	// with, for example by a query that selected other properties.
+	ZeroFields bool
+}
+
+// Put writes the struct src, or the struct src points to, to WMI as an
+// instance of its class. Fields map to properties as in Query, and must
+// include the key properties of the class.
+//
+// If the instance exists and opts.Mode allows updates, the properties whose
+// values differ from the fields are changed; the other properties are left
+// alone, as are those of fields with zero values unless opts.ZeroFields is
+// set. Use a pointer field to write a zero value without ZeroFields.
+// Otherwise a new instance is created from all the fields, but only if the
+// lookup found no instance (ErrNotFound); other errors are returned. System
+// properties such as __PATH are read-only and skipped, as are fields in
+// embedded structs left out by a nil pointer.
+//
+// Fields are encoded according to the CIM types of their properties, from
+// the Go types Query decodes them into. Embedded objects can't be written.
+//
+// See Query for connectServerArgs.
+func (c *Client) Put(src interface{}, opts PutOptions, connectServerArgs ...interface{}) error {
+	_, err := c.put(src, opts, connectServerArgs)
+	return err
+}
+
+// Create creates an instance of class from the struct src, or the struct
+// src points to, and returns its object path. If class is empty, it is
+// taken from the struct type as in CreateQuery. Fields map to properties as
+// in Put; it is an error for the instance to exist already.
+//
+// See Query for connectServerArgs.
+func (c *Client) Create(class string, src interface{}, connectServerArgs ...interface{}) (string, error) {
+	return c.put(src, PutOptions{Mode: CreateOnly, Class: class}, connectServerArgs)
+}
+
+// Delete deletes the instance at the given object path, such as a path
+// returned by Create.
+//
+// See Query for connectServerArgs.
+func (c *Client) Delete(path string, connectServerArgs ...interface{}) error {
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return service.Delete(path)
+}
+
+// put implements Put and returns the path of the instance.
+func (c *Client) put(src interface{}, opts PutOptions, connectServerArgs []interface{}) (string, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", ErrInvalidEntityType
+	}
+	s := fields.Of(v.Type())
+	class := opts.Class
+	if class == "" {
+		class = s.Class
+	}
+
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	return putInstance(service, class, s, v, opts)
+}
+
+// A propertyValue is the value of a property in the representation of
+// Object.GetProperty.
+type propertyValue struct {
+	name  string
+	value interface{}
+	zero  bool // whether the field had its zero value
+}
+
+// encodeFields encodes the fields of the struct v, described by s, as
+// properties of the class object cls.
+func encodeFields(cls Object, class string, s *fields.Struct, v reflect.Value) ([]propertyValue, error) {
+	props, err := cls.Properties()
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]Property, len(props))
+	for _, p := range props {
+		types[strings.ToLower(p.Name)] = p
+	}
+	var values []propertyValue
+	for _, field := range s.Fields {
+		if strings.HasPrefix(field.Name, "__") {
+			continue
+		}
+		f, ok := field.Lookup(v)
+		if !ok {
+			continue
+		}
+		p, ok := types[strings.ToLower(field.Name)]
+		if !ok {
+			return nil, fmt.Errorf("wmi: class %s has no property %s", class, field.Name)
+		}
+		value, err := encodeValue(p.Type, f)
+		if err != nil {
+			return nil, fmt.Errorf("wmi: cannot encode field %s as %s: %v", field.GoName, p.Type, err)
+		}
+		values = append(values, propertyValue{p.Name, value, f.IsZero()})
+	}
+	return values, nil
+}
+
+// putInstance writes v as an instance of class.
+func putInstance(service Service, class string, s *fields.Struct, v reflect.Value, opts PutOptions) (string, error) {
+	mode := opts.Mode
+	cls, err := service.Get(class)
+	if err != nil {
+		return "", err
+	}
+	defer cls.Release()
+	values, err := encodeFields(cls, class, s, v)
+	if err != nil {
+		return "", err
+	}
+
+	inst, err := spawnInstance(cls, class, values)
+	if err != nil {
+		return "", err
+	}
+	defer inst.Release()
+	if mode == CreateOnly {
+		return inst.Put(int(mode))
+	}
+
+	// Look the instance up by the path its key properties make.
+	relPath, err := inst.RelPath()
+	if err != nil {
+		return "", err
+	}
+	if relPath == "" {
+		if mode == UpdateOnly {
+			return "", fmt.Errorf("wmi: cannot update an instance of %s without its key properties", class)
+		}
+		return inst.Put(int(mode))
+	}
+	existing, err := service.Get(relPath)
+	if err != nil {
+		// Only create the instance if it doesn't exist, not when it can't
+		// be read, which would overwrite all of its properties.
+		if mode == UpdateOnly || !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		return inst.Put(int(mode))
+	}
+	defer existing.Release()
+	for _, pv := range values {
+		if pv.zero && !opts.ZeroFields {
+			// The keys are left out too, but they match existing already.
+			continue
+		}
+		old, err := existing.GetProperty(pv.name)
+		if err == nil {
+			same := reflect.DeepEqual(old, pv.value)
+			releaseObjects(old)
+			if same {
+				continue
+			}
+		}
+		if err := existing.SetProperty(pv.name, pv.value); err != nil {
+			return "", fmt.Errorf("wmi: cannot set %s.%s: %v", class, pv.name, err)
+		}
+	}
+	return existing.Put(int(mode))
+}
+
+// spawnInstance returns a new instance of the class object cls with the
+// given property values.
+func spawnInstance(cls Object, class string, values []propertyValue) (Object, error) {
+	inst, err := cls.SpawnInstance()
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range values {
+		if err := inst.SetProperty(pv.name, pv.value); err != nil {
+			inst.Release()
+			return nil, fmt.Errorf("wmi: cannot set %s.%s: %v", class, pv.name, err)
+		}
+	}
+	return inst, nil
+}
+
+// encodeValue encodes f as a value of CIM type t, in the representation of
+// Object.GetProperty. Nil pointers and slices are NULL.
+func encodeValue(t CIMType, f reflect.Value) (interface{}, error) {
+	switch f.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if f.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(t, f.Elem())
+	case reflect.Slice:
+		if !t.IsArray() {
+			break
+		}
+		if f.IsNil() {
+			return nil, nil
+		}
+		arr := make([]interface{}, f.Len())
+		for i := range arr {
+			e, err := encodeValue(t.Elem(), f.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = e
+		}
+		return arr, nil
+	}
+	if t.IsArray() {
+		return nil, fmt.Errorf("%s is not a slice", f.Type())
+	}
+
+	switch t {
+	case CIMTypeSint8, CIMTypeSint16, CIMTypeSint32, CIMTypeSint64:
+		i, err := encodeInt(f, cimTypeBits(t))
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case CIMTypeSint32:
+			return int32(i), nil // VT_I4
+		case CIMTypeSint64:
+			return strconv.FormatInt(i, 10), nil // VT_BSTR
+		}
+		return int16(i), nil // VT_I2
+	case CIMTypeUint8, CIMTypeUint16, CIMTypeUint32, CIMTypeUint64, CIMTypeChar16:
+		u, err := encodeUint(f, cimTypeBits(t))
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case CIMTypeUint8:
+			return uint8(u), nil // VT_UI1
+		case CIMTypeChar16:
+			return int16(u), nil // VT_I2
+		case CIMTypeUint64:
+			return strconv.FormatUint(u, 10), nil // VT_BSTR
+		}
+		return int32(u), nil // VT_I4
+	case CIMTypeReal32, CIMTypeReal64:
+		switch f.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if t == CIMTypeReal32 {
+				return float32(f.Float()), nil
+			}
+			return f.Float(), nil
+		}
+	case CIMTypeBoolean:
+		if f.Kind() == reflect.Bool {
+			return f.Bool(), nil
+		}
+	case CIMTypeString, CIMTypeReference:
+		if f.Type() == objectPathType {
+			return f.Interface().(ObjectPath).String(), nil
+		}
+		if f.Kind() == reflect.String {
+			return f.String(), nil
+		}
+	case CIMTypeDatetime:
+		switch f.Type() {
+		case timeType:
+			return cimdatetime.FormatTime(f.Interface().(time.Time)), nil
+		case durationType:
+			return cimdatetime.FormatInterval(time.Duration(f.Int()))
+		}
+		if f.Kind() == reflect.String {
+			if _, err := cimdatetime.Parse(f.String()); err != nil {
+				return nil, err
+			}
+			return f.String(), nil
+		}
+	case CIMTypeObject:
+		return nil, errors.New("embedded objects are not supported")
+	}
+	return nil, fmt.Errorf("unsupported type %s", f.Type())
+}
+
+// cimTypeBits returns the size of an integer CIM type.
+func cimTypeBits(t CIMType) uint {
+	switch t {
+	case CIMTypeSint8, CIMTypeUint8:
+		return 8
+	case CIMTypeSint16, CIMTypeUint16, CIMTypeChar16:
+		return 16
+	case CIMTypeSint32, CIMTypeUint32:
+		return 32
+	}
+	return 64
+}
+
+func encodeInt(f reflect.Value, bits uint) (int64, error) {
+	var i int64
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = f.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d overflows sint%d", f.Uint(), bits)
+		}
+		i = int64(f.Uint())
+	default:
+		return 0, fmt.Errorf("unsupported type %s", f.Type())
+	}
+	if bits < 64 && (i < -1<<(bits-1) || i > 1<<(bits-1)-1) {
+		return 0, fmt.Errorf("value %d overflows sint%d", i, bits)
+	}
+	return i, nil
+}
+
+func encodeUint(f reflect.Value, bits uint) (uint64, error) {
+	var u uint64
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.Int() < 0 {
+			return 0, fmt.Errorf("value %d overflows uint%d", f.Int(), bits)
+		}
+		u = uint64(f.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u = f.Uint()
+	default:
+		return 0, fmt.Errorf("unsupported type %s", f.Type())
+	}
+	if bits < 64 && u > 1<<bits-1 {
+		return 0, fmt.Errorf("value %d overflows uint%d", u, bits)
+	}
+	return u, nil
+}
@@ -0,0 +1,68 @@
+package wmi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeValue(t *testing.T) {
+	s := "x"
+	var nilString *string
+	tests := []struct {
+		t    CIMType
+		v    interface{}
+		want interface{}
+	}{
+		{CIMTypeSint8, int8(-3), int16(-3)},
+		{CIMTypeSint16, 300, int16(300)},
+		{CIMTypeSint32, int64(-5), int32(-5)},
+		{CIMTypeSint64, int64(-9000000000), "-9000000000"},
+		{CIMTypeUint8, uint8(200), uint8(200)},
+		{CIMTypeUint16, uint16(65535), int32(65535)},
+		{CIMTypeUint32, uint32(4294967295), int32(-1)},
+		{CIMTypeUint64, uint64(18446744073709551615), "18446744073709551615"},
+		{CIMTypeChar16, uint16('A'), int16(65)},
+		{CIMTypeReal32, float32(1.5), float32(1.5)},
+		{CIMTypeReal64, 2.5, 2.5},
+		{CIMTypeBoolean, true, true},
+		{CIMTypeString, &s, "x"},
+		{CIMTypeString, nilString, nil},
+		{CIMTypeDatetime, time.Date(2021, 9, 17, 10, 0, 0, 0, time.UTC), "20210917100000.000000+000"},
+		{CIMTypeDatetime, 90 * time.Minute, "00000000013000.000000:000"},
+		{CIMTypeReference, ObjectPath{Class: "Win32_Service", Keys: []Key{{"Name", "Spooler"}}}, `Win32_Service.Name="Spooler"`},
+		{CIMTypeString | CIMTypeArray, []string{"a", "b"}, []interface{}{"a", "b"}},
+		{CIMTypeUint32 | CIMTypeArray, []uint32(nil), nil},
+	}
+	for _, test := range tests {
+		got, err := encodeValue(test.t, reflect.ValueOf(test.v))
+		if err != nil {
+			t.Errorf("%s %#v: %v", test.t, test.v, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %#v = %#v, want %#v", test.t, test.v, got, test.want)
+		}
+	}
+
+	bad := []struct {
+		t CIMType
+		v interface{}
+	}{
+		{CIMTypeSint8, 128},
+		{CIMTypeUint8, -1},
+		{CIMTypeUint16, 65536},
+		{CIMTypeSint64, uint64(1 << 63)},
+		{CIMTypeBoolean, "true"},
+		{CIMTypeString, []string{"a"}},
+		{CIMTypeString | CIMTypeArray, "a"},
+		{CIMTypeDatetime, "yesterday"},
+		{CIMTypeDatetime, -time.Second},
+		{CIMTypeObject, struct{}{}},
+	}
+	for _, test := range bad {
+		if got, err := encodeValue(test.t, reflect.ValueOf(test.v)); err == nil {
+			t.Errorf("%s %#v = %#v, want error", test.t, test.v, got)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package wmi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StackExchange/wmi/cimdatetime"
+)
+
+// A Record holds all properties of an object, for queries whose results
+// have no struct type. Query decodes into a *[]Record or a
+// *[]map[string]interface{} by listing the properties of every object.
+//
+// Values have the natural Go type of their CIM type:
+//
+//	sint8, sint16, sint32, sint64   int8, int16, int32, int64
+//	uint8, uint16, uint32, uint64   uint8, uint16, uint32, uint64
+//	real32, real64                  float32, float64
+//	char16                          uint16
+//	boolean                         bool
+//	string, ref                     string
+//	datetime                        time.Time, or time.Duration for intervals
+//	object                          Record
+//
+// Arrays are slices of the element type, such as []string or []Record.
+type Record struct {
+	Fields []Field
+}
+
+// A Field is a property of a Record.
+type Field struct {
+	Name  string
+	Type  CIMType
+	Value interface{} // nil if Null
+	Null  bool
+}
+
+// Names returns the names of the properties of r, in order.
+func (r Record) Names() []string {
+	names := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Field returns the named property. Names are case-insensitive.
+func (r Record) Field(name string) (Field, bool) {
+	for _, f := range r.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Get returns the value of the named property, which is nil if the property
+// is NULL or r has no such property.
+func (r Record) Get(name string) interface{} {
+	f, _ := r.Field(name)
+	return f.Value
+}
+
+// Map returns the properties of r by name. Embedded objects are maps as
+// well.
+func (r Record) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(r.Fields))
+	for _, f := range r.Fields {
+		m[f.Name] = mapValue(f.Value)
+	}
+	return m
+}
+
+func mapValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Record:
+		return v.Map()
+	case []Record:
+		maps := make([]map[string]interface{}, len(v))
+		for i, r := range v {
+			maps[i] = r.Map()
+		}
+		return maps
+	}
+	return v
+}
+
+// loadRecord loads all properties of src into a Record.
+func loadRecord(src Object) (Record, error) {
+	props, err := src.Properties()
+	if err != nil {
+		return Record{}, err
+	}
+	r := Record{Fields: make([]Field, 0, len(props))}
+	for _, p := range props {
+		prop, err := src.GetProperty(p.Name)
+		if err != nil {
+			return Record{}, err
+		}
+		v, err := recordValue(p.Type, prop)
+		releaseObjects(prop)
+		if err != nil {
+			return Record{}, fmt.Errorf("wmi: property %s: %v", p.Name, err)
+		}
+		r.Fields = append(r.Fields, Field{Name: p.Name, Type: p.Type, Value: v, Null: prop == nil})
+	}
+	return r, nil
+}
+
+var recordTypes = map[CIMType]reflect.Type{
+	CIMTypeSint8:     reflect.TypeOf(int8(0)),
+	CIMTypeSint16:    reflect.TypeOf(int16(0)),
+	CIMTypeSint32:    reflect.TypeOf(int32(0)),
+	CIMTypeSint64:    reflect.TypeOf(int64(0)),
+	CIMTypeUint8:     reflect.TypeOf(uint8(0)),
+	CIMTypeUint16:    reflect.TypeOf(uint16(0)),
+	CIMTypeUint32:    reflect.TypeOf(uint32(0)),
+	CIMTypeUint64:    reflect.TypeOf(uint64(0)),
+	CIMTypeReal32:    reflect.TypeOf(float32(0)),
+	CIMTypeReal64:    reflect.TypeOf(float64(0)),
+	CIMTypeChar16:    reflect.TypeOf(uint16(0)),
+	CIMTypeBoolean:   reflect.TypeOf(false),
+	CIMTypeString:    reflect.TypeOf(""),
+	CIMTypeReference: reflect.TypeOf(""),
+	CIMTypeObject:    reflect.TypeOf(Record{}),
+}
+
+// recordValue converts the property value v of type t to its natural Go
+// type, as documented on Record.
+func recordValue(t CIMType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if t.IsArray() {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s value of type %T", t, v)
+		}
+		if t.Elem() == CIMTypeDatetime {
+			return datetimeArray(arr)
+		}
+		et, ok := recordTypes[t.Elem()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %s", t)
+		}
+		s := reflect.MakeSlice(reflect.SliceOf(et), len(arr), len(arr))
+		for i, e := range arr {
+			ev, err := recordValue(t.Elem(), e)
+			if err != nil {
+				return nil, err
+			}
+			if ev != nil {
+				s.Index(i).Set(reflect.ValueOf(ev))
+			}
+		}
+		return s.Interface(), nil
+	}
+
+	switch t {
+	case CIMTypeDatetime:
+		s, ok := v.(string)
+		if !ok {
+			break
+		}
+		if cimdatetime.IsInterval(s) {
+			return cimdatetime.ParseInterval(s)
+		}
+		return cimdatetime.ParseTime(s)
+	case CIMTypeObject:
+		o, ok := v.(Object)
+		if !ok {
+			break
+		}
+		return loadRecord(o)
+	}
+	rt, ok := recordTypes[t]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+	rv := reflect.New(rt).Elem()
+	switch val := v.(type) {
+	case int8, int16, int32, int64, int:
+		i := reflect.ValueOf(val).Int()
+		switch rt.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			rv.SetInt(i)
+			return rv.Interface(), nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			// The scripting API returns uint16 and uint32 as VT_I4.
+			rv.SetUint(uint64(i))
+			return rv.Interface(), nil
+		}
+	case uint8, uint16, uint32, uint64:
+		u := reflect.ValueOf(val).Uint()
+		switch rt.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			rv.SetInt(int64(u))
+			return rv.Interface(), nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			rv.SetUint(u)
+			return rv.Interface(), nil
+		}
+	case string:
+		switch rt.Kind() {
+		case reflect.String:
+			return val, nil
+		case reflect.Int64:
+			// sint64 and uint64 are returned as strings.
+			i, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return i, nil
+		case reflect.Uint64:
+			u, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return u, nil
+		}
+	case float32, float64:
+		switch rt.Kind() {
+		case reflect.Float32, reflect.Float64:
+			rv.SetFloat(reflect.ValueOf(val).Float())
+			return rv.Interface(), nil
+		}
+	case bool:
+		if rt.Kind() == reflect.Bool {
+			return val, nil
+		}
+	}
+	return nil, fmt.Errorf("%s value of type %T", t, v)
+}
+
+// datetimeArray converts an array of CIM DATETIMEs to a []time.Time, or to a
+// []time.Duration if it holds intervals.
+func datetimeArray(arr []interface{}) (interface{}, error) {
+	if len(arr) > 0 {
+		if s, ok := arr[0].(string); ok && cimdatetime.IsInterval(s) {
+			durations := make([]time.Duration, len(arr))
+			for i, e := range arr {
+				s, _ := e.(string)
+				d, err := cimdatetime.ParseInterval(s)
+				if err != nil {
+					return nil, err
+				}
+				durations[i] = d
+			}
+			return durations, nil
+		}
+	}
+	times := make([]time.Time, len(arr))
+	for i, e := range arr {
+		s, _ := e.(string)
+		t, err := cimdatetime.ParseTime(s)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = t
+	}
+	return times, nil
+}
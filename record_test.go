@@ -0,0 +1,48 @@
+package wmi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordValue(t *testing.T) {
+	tests := []struct {
+		t    CIMType
+		v    interface{}
+		want interface{}
+	}{
+		{CIMTypeSint8, int16(-3), int8(-3)},
+		{CIMTypeUint8, uint8(200), uint8(200)},
+		{CIMTypeUint16, int32(65535), uint16(65535)},
+		{CIMTypeUint32, int32(-1), uint32(4294967295)},
+		{CIMTypeSint64, "-9000000000", int64(-9000000000)},
+		{CIMTypeUint64, "18446744073709551615", uint64(18446744073709551615)},
+		{CIMTypeReal32, float32(1.5), float32(1.5)},
+		{CIMTypeChar16, int16(65), uint16(65)},
+		{CIMTypeReference, `Win32_Service.Name="Spooler"`, `Win32_Service.Name="Spooler"`},
+		{CIMTypeDatetime, "20210917100000.000000+000", time.Date(2021, 9, 17, 10, 0, 0, 0, time.FixedZone("", 0))},
+		{CIMTypeDatetime, "00000001000000.000000:000", 24 * time.Hour},
+		{CIMTypeDatetime | CIMTypeArray, []interface{}{"00000000000001.000000:000"}, []time.Duration{time.Second}},
+		{CIMTypeUint32 | CIMTypeArray, []interface{}{int32(1), int32(2)}, []uint32{1, 2}},
+	}
+	for _, test := range tests {
+		got, err := recordValue(test.t, test.v)
+		if err != nil {
+			t.Errorf("%s %#v: %v", test.t, test.v, err)
+			continue
+		}
+		if tv, ok := got.(time.Time); ok {
+			if !tv.Equal(test.want.(time.Time)) {
+				t.Errorf("%s %#v = %v, want %v", test.t, test.v, got, test.want)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %#v = %#v, want %#v", test.t, test.v, got, test.want)
+		}
+	}
+	if _, err := recordValue(CIMTypeBoolean, "yes"); err == nil {
+		t.Error("converted a string to a boolean")
+	}
+}
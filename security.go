@@ -0,0 +1,77 @@
+package wmi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An ImpersonationLevel is the COM impersonation level of a connection,
+// which determines what the server may do with the caller's identity. Its
+// values are those of wbemImpersonationLevelEnum.
+type ImpersonationLevel int
+
+const (
+	// ImpersonationAnonymous hides the caller's identity from the server.
+	ImpersonationAnonymous ImpersonationLevel = 1
+	// ImpersonationIdentify lets the server identify the caller, but not act
+	// on its behalf.
+	ImpersonationIdentify ImpersonationLevel = 2
+	// ImpersonationImpersonate lets the server act with the caller's
+	// identity. Most providers require it.
+	ImpersonationImpersonate ImpersonationLevel = 3
+	// ImpersonationDelegate also lets the server pass the caller's identity
+	// on to other servers.
+	ImpersonationDelegate ImpersonationLevel = 4
+)
+
+// An AuthenticationLevel is the COM authentication level of a connection.
+// Its values are those of wbemAuthenticationLevelEnum.
+type AuthenticationLevel int
+
+const (
+	// AuthenticationDefault uses the default of the security settings of
+	// the machine.
+	AuthenticationDefault AuthenticationLevel = 0
+	// AuthenticationNone doesn't authenticate.
+	AuthenticationNone AuthenticationLevel = 1
+	// AuthenticationConnect authenticates when connecting only.
+	AuthenticationConnect AuthenticationLevel = 2
+	// AuthenticationCall authenticates at the start of every call.
+	AuthenticationCall AuthenticationLevel = 3
+	// AuthenticationPkt authenticates every packet.
+	AuthenticationPkt AuthenticationLevel = 4
+	// AuthenticationPktIntegrity also checks that packets weren't changed.
+	AuthenticationPktIntegrity AuthenticationLevel = 5
+	// AuthenticationPktPrivacy also encrypts packets.
+	AuthenticationPktPrivacy AuthenticationLevel = 6
+)
+
+// checkSecurity checks the security settings of o.
+func (o ConnectOptions) checkSecurity() error {
+	if o.ImpersonationLevel < 0 || o.ImpersonationLevel > ImpersonationDelegate {
+		return fmt.Errorf("wmi: connect options: invalid impersonation level %d", o.ImpersonationLevel)
+	}
+	if o.AuthenticationLevel < 0 || o.AuthenticationLevel > AuthenticationPktPrivacy {
+		return fmt.Errorf("wmi: connect options: invalid authentication level %d", o.AuthenticationLevel)
+	}
+	for _, p := range o.Privileges {
+		if !strings.HasPrefix(p, "Se") || !strings.HasSuffix(p, "Privilege") || !isIdent(p) {
+			return fmt.Errorf("wmi: connect options: invalid privilege %q, want a name such as SeShutdownPrivilege", p)
+		}
+	}
+	return nil
+}
+
+// hasSecurity reports whether o changes the security settings of the
+// service.
+func (o *ConnectOptions) hasSecurity() bool {
+	return o != nil && (o.ImpersonationLevel != 0 || o.AuthenticationLevel != 0 || len(o.Privileges) > 0)
+}
+
+// applySecurity applies the security settings of o, if any, to service.
+func applySecurity(service Service, o *ConnectOptions) error {
+	if !o.hasSecurity() {
+		return nil
+	}
+	return service.SetSecurity(o.ImpersonationLevel, o.AuthenticationLevel, o.Privileges)
+}
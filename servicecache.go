@@ -0,0 +1,146 @@
+package wmi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultIdleTimeout is the IdleTimeout of SWbemServicesOptions when it is
+// zero.
+const defaultIdleTimeout = 5 * time.Minute
+
+// A serviceCache keeps the connections of an SWbemServices for reuse, keyed
+// by their normalized connect arguments. Only the goroutine that owns the
+// locator may use it.
+type serviceCache struct {
+	locator     Locator
+	idleTimeout time.Duration // negative to connect for every query
+	services    map[string]*cachedService
+}
+
+type cachedService struct {
+	service  Service
+	lastUsed time.Time
+}
+
+func newServiceCache(locator Locator, idleTimeout time.Duration) *serviceCache {
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	return &serviceCache{
+		locator:     locator,
+		idleTimeout: idleTimeout,
+		services:    make(map[string]*cachedService),
+	}
+}
+
+// do calls f with a connection for args and opts, reusing a cached one if
+// possible. If a cached connection turns out to be broken, f is retried once
+// with a new one.
+func (c *serviceCache) do(args []interface{}, opts *ConnectOptions, f func(Service) error) error {
+	if c.idleTimeout < 0 {
+		service, err := c.connect(args, opts)
+		if err != nil {
+			return err
+		}
+		defer service.Release()
+		return f(service)
+	}
+
+	key := cacheKey(args, opts)
+	cs, ok := c.services[key]
+	if ok && time.Since(cs.lastUsed) > c.idleTimeout {
+		c.drop(key)
+		ok = false
+	}
+	if ok {
+		err := f(cs.service)
+		if !errors.Is(err, ErrDisconnected) {
+			cs.lastUsed = time.Now()
+			return err
+		}
+		// The connection broke since it was cached, for example because
+		// the remote host restarted.
+		c.drop(key)
+	}
+
+	service, err := c.connect(args, opts)
+	if err != nil {
+		return err
+	}
+	err = f(service)
+	if errors.Is(err, ErrDisconnected) {
+		service.Release()
+		return err
+	}
+	c.services[key] = &cachedService{service: service, lastUsed: time.Now()}
+	return err
+}
+
+func (c *serviceCache) connect(args []interface{}, opts *ConnectOptions) (Service, error) {
+	// service is a SWbemServices
+	service, err := c.locator.ConnectServer(args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySecurity(service, opts); err != nil {
+		service.Release()
+		return nil, err
+	}
+	return service, nil
+}
+
+func (c *serviceCache) drop(key string) {
+	c.services[key].service.Release()
+	delete(c.services, key)
+}
+
+// evictIdle releases the connections that have not been used since
+// idleTimeout before now.
+func (c *serviceCache) evictIdle(now time.Time) {
+	for key, cs := range c.services {
+		if now.Sub(cs.lastUsed) > c.idleTimeout {
+			c.drop(key)
+		}
+	}
+}
+
+// releaseAll releases all cached connections.
+func (c *serviceCache) releaseAll() {
+	for key := range c.services {
+		c.drop(key)
+	}
+}
+
+// cacheKey returns the key of the connection for args and opts. Arguments
+// that select the same connection have the same key: trailing and empty
+// arguments are left out, the local machine is always empty, and server and
+// namespace names are compared case-insensitively.
+func cacheKey(args []interface{}, opts *ConnectOptions) string {
+	norm := make([]interface{}, len(args))
+	for i, arg := range args {
+		if s, ok := arg.(string); ok {
+			switch {
+			case s == "":
+				arg = nil
+			case i == 0 && s == ".":
+				arg = nil
+			case i == 0:
+				arg = strings.ToLower(s)
+			case i == 1:
+				arg = strings.ToLower(strings.Replace(s, "/", `\`, -1))
+			}
+		}
+		norm[i] = arg
+	}
+	for len(norm) > 0 && norm[len(norm)-1] == nil {
+		norm = norm[:len(norm)-1]
+	}
+	key := fmt.Sprintf("%#v", norm)
+	if opts.hasSecurity() {
+		key += fmt.Sprintf(" %d %d %q", opts.ImpersonationLevel, opts.AuthenticationLevel, opts.Privileges)
+	}
+	return key
+}
@@ -1,178 +1,284 @@
-// +build windows
-
 package wmi
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
 	"reflect"
 	"runtime"
 	"sync"
-
-	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
+	"time"
 )
 
 // SWbemServices is used to access wmi. See https://msdn.microsoft.com/en-us/library/aa393719(v=vs.85).aspx
 type SWbemServices struct {
-	//TODO: track namespace. Not sure if we can re connect to a different namespace using the same instance
-	cWMIClient            *Client //This could also be an embedded struct, but then we would need to branch on Client vs SWbemServices in the Query method
-	sWbemLocatorIUnknown  *ole.IUnknown
-	sWbemLocatorIDispatch *ole.IDispatch
-	queries               chan *queryRequest
-	closeError            chan error
-	lQueryorClose         sync.Mutex
+	cWMIClient    *Client         //This could also be an embedded struct, but then we would need to branch on Client vs SWbemServices in the Query method
+	args          []interface{}   // connectServerArgs of queries that have none
+	opts          *ConnectOptions // and their options, if given as such
+	options       SWbemServicesOptions
+	queues        []chan *queryRequest // one shared by all workers, or one per worker
+	closed        bool
+	sending       sync.WaitGroup // queries being sent to the queues
+	workers       sync.WaitGroup
+	lQueryorClose sync.Mutex
 }
 
 type queryRequest struct {
+	ctx      context.Context // nil for Query
 	query    string
 	dst      interface{}
 	args     []interface{}
+	opts     *ConnectOptions
+	queued   time.Time
 	finished chan error
 }
 
-// InitializeSWbemServices will return a new SWbemServices object that can be used to query WMI
+// SWbemServicesOptions are the options of an SWbemServices, given to
+// InitializeSWbemServicesWithOptions.
+type SWbemServicesOptions struct {
+	// ConnectServerArgs are used by queries without connectServerArgs of
+	// their own. They may be a single ConnectOptions.
+	ConnectServerArgs []interface{}
+	// IdleTimeout is how long a connection is kept for reuse after its last
+	// query. Queries with the same connectServerArgs, up to the case of the
+	// server and namespace, share a connection. Zero means five minutes, and
+	// a negative IdleTimeout connects anew for every query.
+	IdleTimeout time.Duration
+	// Workers is the number of goroutines that run queries concurrently,
+	// each on its own COM-initialized OS thread with its own connections, so
+	// that a slow host doesn't hold up queries to others. Zero means 1.
+	Workers int
+	// Affinity sends the queries for the same server, namespace and
+	// credentials to the same worker, so that they share its connection.
+	// Otherwise the next free worker runs a query. Either way, queries
+	// waiting for a worker are run in the order they were made.
+	Affinity bool
+	// Metrics, if set, is called by the worker after each query. It must not
+	// block.
+	Metrics func(QueryMetrics)
+}
+
+// QueryMetrics describe a query run by an SWbemServices.
+type QueryMetrics struct {
+	Query string
+	// Worker is the index of the worker that ran the query.
+	Worker int
+	// QueueTime is how long the query waited for a worker, and RunTime how
+	// long the worker took to run it.
+	QueueTime time.Duration
+	RunTime   time.Duration
+	Err       error
+}
+
+// InitializeSWbemServices will return a new SWbemServices object that can be used to query WMI.
+// Queries without connectServerArgs of their own use connectServerArgs, which
+// may be a single ConnectOptions.
 func InitializeSWbemServices(c *Client, connectServerArgs ...interface{}) (*SWbemServices, error) {
-	//fmt.Println("InitializeSWbemServices: Starting")
-	//TODO: implement connectServerArgs as optional argument for init with connectServer call
+	return InitializeSWbemServicesWithOptions(c, SWbemServicesOptions{ConnectServerArgs: connectServerArgs})
+}
+
+// InitializeSWbemServicesWithOptions is like InitializeSWbemServices, but
+// takes the connectServerArgs of queries without their own along with the
+// other options.
+func InitializeSWbemServicesWithOptions(c *Client, options SWbemServicesOptions) (*SWbemServices, error) {
+	if options.Workers < 0 {
+		return nil, fmt.Errorf("wmi: invalid number of workers %d", options.Workers)
+	}
+	if options.Workers == 0 {
+		options.Workers = 1
+	}
+	args, opts, err := connectArgs(options.ConnectServerArgs)
+	if err != nil {
+		return nil, err
+	}
 	s := new(SWbemServices)
 	s.cWMIClient = c
-	s.queries = make(chan *queryRequest)
-	initError := make(chan error)
-	go s.process(initError)
+	s.args, s.opts = args, opts
+	s.options = options
+	queues := 1
+	if options.Affinity {
+		queues = options.Workers
+	}
+	s.queues = make([]chan *queryRequest, queues)
+	for i := range s.queues {
+		s.queues[i] = make(chan *queryRequest)
+	}
 
-	err, ok := <-initError
-	if ok {
-		return nil, err //Send error to caller
+	initError := make(chan error, options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		s.workers.Add(1)
+		go s.process(i, s.queues[i%queues], initError)
+	}
+	for i := 0; i < options.Workers; i++ {
+		if err := <-initError; err != nil {
+			s.Close()
+			return nil, err //Send error to caller
+		}
 	}
-	//fmt.Println("InitializeSWbemServices: Finished")
 	return s, nil
 }
 
-// Close will clear and release all of the SWbemServices resources
+// Close will clear and release all of the SWbemServices resources. It waits
+// for running queries to finish.
 func (s *SWbemServices) Close() error {
 	s.lQueryorClose.Lock()
-	if s == nil || s.sWbemLocatorIDispatch == nil {
+	if s == nil || s.queues == nil {
 		s.lQueryorClose.Unlock()
 		return fmt.Errorf("SWbemServices is not Initialized")
 	}
-	if s.queries == nil {
+	if s.closed {
 		s.lQueryorClose.Unlock()
 		return fmt.Errorf("SWbemServices has been closed")
 	}
-	//fmt.Println("Close: sending close request")
-	var result error
-	ce := make(chan error)
-	s.closeError = ce //Race condition if multiple callers to close. May need to lock here
-	close(s.queries)  //Tell background to shut things down
+	s.closed = true
 	s.lQueryorClose.Unlock()
-	err, ok := <-ce
-	if ok {
-		result = err
+
+	// Let the queries already made reach the workers, then tell them to
+	// shut things down.
+	s.sending.Wait()
+	for _, q := range s.queues {
+		close(q)
 	}
-	//fmt.Println("Close: finished")
-	return result
+	s.workers.Wait()
+	return nil
 }
 
-func (s *SWbemServices) process(initError chan error) {
-	//fmt.Println("process: starting background thread initialization")
+// process runs the queries of queue on a locked OS thread with a locator of
+// its own, until queue is closed. It reports whether it could get the
+// locator to initError.
+func (s *SWbemServices) process(worker int, queue <-chan *queryRequest, initError chan<- error) {
+	defer s.workers.Done()
 	//All OLE/WMI calls must happen on the same initialized thead, so lock this goroutine
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	err := ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
+	backend, err := s.cWMIClient.backend()
 	if err != nil {
-		oleCode := err.(*ole.OleError).Code()
-		if oleCode != ole.S_OK && oleCode != S_FALSE {
-			initError <- fmt.Errorf("ole.CoInitializeEx error: %v", err)
-			return
-		}
+		initError <- err
+		return
 	}
-	defer ole.CoUninitialize()
-
-	unknown, err := oleutil.CreateObject("WbemScripting.SWbemLocator")
+	locator, err := backend.Locator()
 	if err != nil {
-		initError <- fmt.Errorf("CreateObject SWbemLocator error: %v", err)
-		return
-	} else if unknown == nil {
-		initError <- ErrNilCreateObject
+		initError <- fmt.Errorf("SWbemLocator error: %v", err)
 		return
 	}
-	defer unknown.Release()
-	s.sWbemLocatorIUnknown = unknown
+	cache := newServiceCache(locator, s.options.IdleTimeout)
+	// Release everything before Close returns.
+	defer locator.Release()
+	defer cache.releaseAll()
 
-	dispatch, err := s.sWbemLocatorIUnknown.QueryInterface(ole.IID_IDispatch)
-	if err != nil {
-		initError <- fmt.Errorf("SWbemLocator QueryInterface error: %v", err)
-		return
+	var evict <-chan time.Time
+	if cache.idleTimeout > 0 {
+		t := time.NewTicker(cache.idleTimeout)
+		defer t.Stop()
+		evict = t.C
 	}
-	defer dispatch.Release()
-	s.sWbemLocatorIDispatch = dispatch
 
-	// we can't do the ConnectServer call outside the loop unless we find a way to track and re-init the connectServerArgs
-	//fmt.Println("process: initialized. closing initError")
-	close(initError)
-	//fmt.Println("process: waiting for queries")
-	for q := range s.queries {
-		//fmt.Printf("process: new query: len(query)=%d\n", len(q.query))
-		errQuery := s.queryBackground(q)
-		//fmt.Println("process: s.queryBackground finished")
-		if errQuery != nil {
-			q.finished <- errQuery
+	initError <- nil
+	for {
+		select {
+		case q, ok := <-queue:
+			if !ok {
+				return
+			}
+			start := time.Now()
+			errQuery := s.queryBackground(cache, q)
+			if s.options.Metrics != nil {
+				s.options.Metrics(QueryMetrics{
+					Query:     q.query,
+					Worker:    worker,
+					QueueTime: start.Sub(q.queued),
+					RunTime:   time.Since(start),
+					Err:       errQuery,
+				})
+			}
+			if errQuery != nil {
+				q.finished <- errQuery
+			}
+			close(q.finished)
+		case now := <-evict:
+			cache.evictIdle(now)
 		}
-		close(q.finished)
 	}
-	//fmt.Println("process: queries channel closed")
-	s.queries = nil //set channel to nil so we know it is closed
-	//TODO: I think the Release/Clear calls can panic if things are in a bad state.
-	//TODO: May need to recover from panics and send error to method caller instead.
-	close(s.closeError)
 }
 
 // Query runs the WQL query using a SWbemServices instance and appends the values to dst.
 //
-// dst must have type *[]S or *[]*S, for some struct type S. Fields selected in
-// the query must have the same name in dst. Supported types are all signed and
-// unsigned integers, time.Time, string, bool, or a pointer to one of those.
-// Array types are not supported.
+// dst must have type *[]S or *[]*S, for some struct type S, or any other type
+// Client.Query accepts, and the results are decoded as described there,
+// including slices for array properties and embedded objects.
 //
 // By default, the local machine and default namespace are used. These can be
-// changed using connectServerArgs. See
-// http://msdn.microsoft.com/en-us/library/aa393720.aspx for details.
+// changed using connectServerArgs, or the connectServerArgs given to
+// InitializeSWbemServices, which are used when a query has none. See
+// http://msdn.microsoft.com/en-us/library/aa393720.aspx for details, or pass
+// a single ConnectOptions instead.
 func (s *SWbemServices) Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
+	return s.query(nil, query, dst, connectServerArgs)
+}
+
+// QueryContext is like Query, but gives up when ctx is done and returns
+// ctx.Err(), as Client.QueryContext does. It also gives up waiting for other
+// queries of s to finish.
+func (s *SWbemServices) QueryContext(ctx context.Context, query string, dst interface{}, connectServerArgs ...interface{}) error {
+	return s.query(ctx, query, dst, connectServerArgs)
+}
+
+func (s *SWbemServices) query(ctx context.Context, query string, dst interface{}, connectServerArgs []interface{}) error {
+	args, opts := s.args, s.opts
+	if len(connectServerArgs) > 0 {
+		var err error
+		if args, opts, err = connectArgs(connectServerArgs); err != nil {
+			return err
+		}
+	}
+
 	s.lQueryorClose.Lock()
-	if s == nil || s.sWbemLocatorIDispatch == nil {
+	if s == nil || s.queues == nil {
 		s.lQueryorClose.Unlock()
 		return fmt.Errorf("SWbemServices is not Initialized")
 	}
-	if s.queries == nil {
+	if s.closed {
 		s.lQueryorClose.Unlock()
 		return fmt.Errorf("SWbemServices has been closed")
 	}
+	s.sending.Add(1)
+	s.lQueryorClose.Unlock()
 
-	//fmt.Println("Query: Sending query request")
 	qr := queryRequest{
+		ctx:      ctx,
 		query:    query,
 		dst:      dst,
-		args:     connectServerArgs,
+		args:     args,
+		opts:     opts,
+		queued:   time.Now(),
 		finished: make(chan error),
 	}
-	s.queries <- &qr
-	s.lQueryorClose.Unlock()
+	queue := s.queues[0]
+	if len(s.queues) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(cacheKey(args, opts)))
+		queue = s.queues[h.Sum32()%uint32(len(s.queues))]
+	}
+	if ctx == nil {
+		queue <- &qr
+	} else {
+		select {
+		case queue <- &qr:
+		case <-ctx.Done():
+			s.sending.Done()
+			return ctx.Err()
+		}
+	}
+	s.sending.Done()
 	err, ok := <-qr.finished
 	if ok {
-		//fmt.Println("Query: Finished with error")
 		return err //Send error to caller
 	}
-	//fmt.Println("Query: Finished")
 	return nil
 }
 
-func (s *SWbemServices) queryBackground(q *queryRequest) error {
-	if s == nil || s.sWbemLocatorIDispatch == nil {
-		return fmt.Errorf("SWbemServices is not Initialized")
-	}
-	wmi := s.sWbemLocatorIDispatch //Should just rename in the code, but this will help as we break things apart
-	//fmt.Println("queryBackground: Starting")
+func (s *SWbemServices) queryBackground(cache *serviceCache, q *queryRequest) error {
 
 	dv := reflect.ValueOf(q.dst)
 	if dv.Kind() != reflect.Ptr || dv.IsNil() {
@@ -184,77 +290,10 @@ func (s *SWbemServices) queryBackground(q *queryRequest) error {
 		return ErrInvalidEntityType
 	}
 
-	// service is a SWbemServices
-	serviceRaw, err := oleutil.CallMethod(wmi, "ConnectServer", q.args...)
-	if err != nil {
-		return err
-	}
-	service := serviceRaw.ToIDispatch()
-	defer serviceRaw.Clear()
-
-	// result is a SWBemObjectSet
-	resultRaw, err := oleutil.CallMethod(service, "ExecQuery", q.query)
-	if err != nil {
-		return err
-	}
-	result := resultRaw.ToIDispatch()
-	defer resultRaw.Clear()
-
-	count, err := oleInt64(result, "Count")
-	if err != nil {
-		return err
-	}
-
-	enumProperty, err := result.GetProperty("_NewEnum")
-	if err != nil {
-		return err
-	}
-	defer enumProperty.Clear()
-
-	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
-	if err != nil {
-		return err
-	}
-	if enum == nil {
-		return fmt.Errorf("can't get IEnumVARIANT, enum is nil")
-	}
-	defer enum.Release()
-
-	// Initialize a slice with Count capacity
-	dv.Set(reflect.MakeSlice(dv.Type(), 0, int(count)))
-
-	var errFieldMismatch error
-	for itemRaw, length, err := enum.Next(1); length > 0; itemRaw, length, err = enum.Next(1) {
-		if err != nil {
-			return err
-		}
-
-		err := func() error {
-			// item is a SWbemObject, but really a Win32_Process
-			item := itemRaw.ToIDispatch()
-			defer item.Release()
-
-			ev := reflect.New(elemType)
-			if err = s.cWMIClient.loadEntity(ev.Interface(), item); err != nil {
-				if _, ok := err.(*ErrFieldMismatch); ok {
-					// We continue loading entities even in the face of field mismatch errors.
-					// If we encounter any other error, that other error is returned. Otherwise,
-					// an ErrFieldMismatch is returned.
-					errFieldMismatch = err
-				} else {
-					return err
-				}
-			}
-			if mat != multiArgTypeStructPtr {
-				ev = ev.Elem()
-			}
-			dv.Set(reflect.Append(dv, ev))
-			return nil
-		}()
-		if err != nil {
-			return err
+	return cache.do(q.args, q.opts, func(service Service) error {
+		if q.ctx != nil {
+			return s.cWMIClient.execQueryContext(q.ctx, service, q.query, dv, mat, elemType)
 		}
-	}
-	//fmt.Println("queryBackground: Finished")
-	return errFieldMismatch
+		return s.cWMIClient.execQuery(service, q.query, dv, mat, elemType)
+	})
 }
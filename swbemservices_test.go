@@ -144,6 +144,58 @@ func BenchmarkOldVersion(b *testing.B) {
 	}
 }
 
+//Parallel benchmarks, comparing the throughput of concurrent queries with the above:
+//go test -run=NONE -bench=Parallel -benchtime=120s
+
+//go test -run=NONE -bench=OldVersionParallel -benchtime=120s
+func BenchmarkOldVersionParallel(b *testing.B) {
+	benchmarkParallel(b, func(q string, dst *[]Win32_OperatingSystem) error {
+		return Query(q, dst)
+	})
+}
+
+//go test -run=NONE -bench=LimitedParallel -benchtime=120s
+func BenchmarkLimitedParallel(b *testing.B) {
+	c := &Client{MaxConcurrency: runtime.GOMAXPROCS(0)}
+	benchmarkParallel(b, func(q string, dst *[]Win32_OperatingSystem) error {
+		return c.Query(q, dst)
+	})
+}
+
+//go test -run=NONE -bench=NewVersionParallel -benchtime=120s
+func BenchmarkNewVersionParallel(b *testing.B) {
+	s, err := InitializeSWbemServicesWithOptions(DefaultClient, SWbemServicesOptions{Workers: runtime.GOMAXPROCS(0)})
+	if err != nil {
+		b.Fatalf("InitializeSWbemServices: %s", err)
+	}
+	benchmarkParallel(b, func(q string, dst *[]Win32_OperatingSystem) error {
+		return s.Query(q, dst)
+	})
+	errClose := s.Close()
+	if errClose != nil {
+		b.Fatalf("Close: %s", errClose)
+	}
+}
+
+func benchmarkParallel(b *testing.B, query func(q string, dst *[]Win32_OperatingSystem) error) {
+	b.RunParallel(func(pb *testing.PB) {
+		var dst []Win32_OperatingSystem
+		q := CreateQuery(&dst, "")
+		for pb.Next() {
+			dst = dst[:0]
+			errQuery := query(q, &dst)
+			if errQuery != nil {
+				b.Errorf("Query: %s", errQuery)
+				return
+			}
+			if len(dst) < 1 {
+				b.Error("Query: no results found for Win32_OperatingSystem")
+				return
+			}
+		}
+	})
+}
+
 type MSFT_NetAdapter struct {
 	Name              string
 	InterfaceIndex    int
@@ -0,0 +1,161 @@
+package wmi
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/StackExchange/wmi/cimdatetime"
+)
+
+// Unmarshaler is implemented by types that decode a WMI property value
+// themselves. Query calls UnmarshalWMI on a field whose address implements
+// it, including for NULL values unless the field is a pointer and
+// Client.PtrNil is set.
+//
+// Types that only need the text of a string property can implement
+// encoding.TextUnmarshaler instead, which Query uses for string properties
+// and the elements of string arrays:
+//
+//	type Adapter struct {
+//		IPAddress []net.IP
+//	}
+type Unmarshaler interface {
+	UnmarshalWMI(v Value) error
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// A Value is a property value passed to an Unmarshaler.
+type Value struct {
+	// Name is the name of the property.
+	Name string
+	// Raw is the value as described on Object.GetProperty, such as an int32
+	// for a uint32 property. Embedded objects are only valid for the
+	// duration of the UnmarshalWMI call.
+	Raw interface{}
+}
+
+// IsNull reports whether the value is NULL.
+func (v Value) IsNull() bool {
+	return v.Raw == nil
+}
+
+// Int returns the value of an integer property. 64-bit integers, which WMI
+// returns as strings, are parsed.
+func (v Value) Int() (int64, error) {
+	switch x := v.Raw.(type) {
+	case int8, int16, int32, int64, int:
+		return reflect.ValueOf(x).Int(), nil
+	case uint8, uint16, uint32, uint64:
+		return int64(reflect.ValueOf(x).Uint()), nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	}
+	return 0, v.typeError("integer")
+}
+
+// Uint returns the value of an unsigned integer property. Unlike Int, it
+// interprets the int32 values of uint32 properties as unsigned.
+func (v Value) Uint() (uint64, error) {
+	switch x := v.Raw.(type) {
+	case int32:
+		return uint64(uint32(x)), nil
+	case int8, int16, int64, int:
+		return uint64(reflect.ValueOf(x).Int()), nil
+	case uint8, uint16, uint32, uint64:
+		return reflect.ValueOf(x).Uint(), nil
+	case string:
+		return strconv.ParseUint(x, 10, 64)
+	}
+	return 0, v.typeError("unsigned integer")
+}
+
+// Text returns the value of a string, datetime or reference property.
+func (v Value) Text() (string, error) {
+	if s, ok := v.Raw.(string); ok {
+		return s, nil
+	}
+	return "", v.typeError("string")
+}
+
+// Strings returns the value of a string array property.
+func (v Value) Strings() ([]string, error) {
+	arr, ok := v.Raw.([]interface{})
+	if !ok {
+		return nil, v.typeError("string array")
+	}
+	strs := make([]string, len(arr))
+	for i, e := range arr {
+		s, ok := e.(string)
+		if !ok {
+			return nil, v.typeError("string array")
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// Time returns the value of a datetime property that holds an absolute
+// time.
+func (v Value) Time() (time.Time, error) {
+	s, ok := v.Raw.(string)
+	if !ok {
+		return time.Time{}, v.typeError("datetime")
+	}
+	return cimdatetime.ParseTime(s)
+}
+
+// Duration returns the value of a datetime property that holds an interval.
+func (v Value) Duration() (time.Duration, error) {
+	s, ok := v.Raw.(string)
+	if !ok {
+		return 0, v.typeError("datetime")
+	}
+	return cimdatetime.ParseInterval(s)
+}
+
+// Path returns the value of a reference property.
+func (v Value) Path() (ObjectPath, error) {
+	s, ok := v.Raw.(string)
+	if !ok {
+		return ObjectPath{}, v.typeError("reference")
+	}
+	return ParsePath(s)
+}
+
+func (v Value) typeError(want string) error {
+	return fmt.Errorf("wmi: property %s is not a %s: %T", v.Name, want, v.Raw)
+}
+
+// unmarshal decodes prop into f if the address of f implements Unmarshaler,
+// or encoding.TextUnmarshaler and prop is a string. It reports whether it
+// did.
+func unmarshal(f reflect.Value, name string, prop interface{}) (bool, error) {
+	if !f.CanAddr() {
+		return false, nil
+	}
+	pt := f.Addr().Type()
+	var err error
+	switch {
+	case pt.Implements(unmarshalerType):
+		err = f.Addr().Interface().(Unmarshaler).UnmarshalWMI(Value{Name: name, Raw: prop})
+	case pt.Implements(textUnmarshalerType) && f.Type() != timeType:
+		s, ok := prop.(string)
+		if !ok {
+			return false, nil
+		}
+		err = f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("wmi: cannot unmarshal property %s into %s: %w", name, f.Type(), err)
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package wmi
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type serviceState int
+
+const (
+	stateUnknown serviceState = iota
+	stateStopped
+	stateRunning
+)
+
+func (s *serviceState) UnmarshalWMI(v Value) error {
+	if v.IsNull() {
+		*s = stateUnknown
+		return nil
+	}
+	text, err := v.Text()
+	if err != nil {
+		return err
+	}
+	switch text {
+	case "Stopped":
+		*s = stateStopped
+	case "Running":
+		*s = stateRunning
+	default:
+		return errors.New("unknown state " + text)
+	}
+	return nil
+}
+
+type exitCode uint32
+
+func (c *exitCode) UnmarshalWMI(v Value) error {
+	u, err := v.Uint()
+	*c = exitCode(u)
+	return err
+}
+
+func TestUnmarshaler(t *testing.T) {
+	type adapter struct {
+		State      serviceState
+		LastState  serviceState
+		ExitCode   exitCode
+		IPAddress  []net.IP
+		Gateway    net.IP
+		States     []serviceState
+		NullStates *serviceState
+	}
+	b := &stubBackend{objects: []stubObject{{
+		"State":      "Running",
+		"LastState":  nil,
+		"ExitCode":   int32(-1),
+		"IPAddress":  []interface{}{"10.0.0.2", "fe80::1"},
+		"Gateway":    "10.0.0.1",
+		"States":     []interface{}{"Stopped", "Running"},
+		"NullStates": nil,
+	}}}
+	c := &Client{Backend: b, PtrNil: true}
+	var dst []adapter
+	if err := c.Query("SELECT * FROM adapter", &dst); err != nil {
+		t.Fatal(err)
+	}
+	a := dst[0]
+	if a.State != stateRunning || a.LastState != stateUnknown || a.ExitCode != 4294967295 {
+		t.Errorf("got %+v", a)
+	}
+	if len(a.IPAddress) != 2 || !a.IPAddress[0].Equal(net.ParseIP("10.0.0.2")) || !a.IPAddress[1].Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("IPAddress = %v", a.IPAddress)
+	}
+	if !a.Gateway.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Gateway = %v", a.Gateway)
+	}
+	if len(a.States) != 2 || a.States[0] != stateStopped || a.States[1] != stateRunning {
+		t.Errorf("States = %v", a.States)
+	}
+	if a.NullStates != nil {
+		t.Errorf("NullStates = %v, want nil", *a.NullStates)
+	}
+
+	b.objects[0]["State"] = "Paused"
+	err := c.Query("SELECT * FROM adapter", &dst)
+	if err == nil || !strings.Contains(err.Error(), "unknown state Paused") {
+		t.Errorf("got %v, want unmarshal error", err)
+	}
+	b.objects[0]["State"] = "Running"
+	b.objects[0]["Gateway"] = "not an address"
+	if err := c.Query("SELECT * FROM adapter", &dst); err == nil {
+		t.Error("decoded an invalid IP address")
+	}
+}
@@ -1,5 +1,3 @@
-// +build windows
-
 /*
 Package wmi provides a WQL interface for WMI on Windows.
 
@@ -21,24 +19,40 @@ Example code to print names of running processes:
 		}
 	}
 
+Fields map to the properties of the same name. A wmi struct tag maps a field
+to a differently named property, and "-" skips the field. The noselect option
+keeps a field out of the SELECT list that CreateQuery builds, which is useful
+for system properties such as __CLASS that are returned anyway. The tag of a
+blank field names the class, for types not named after it:
+
+	type Service struct {
+		_           struct{} `wmi:"Win32_Service"`
+		Name        string
+		DisplayName string `wmi:"Caption"`
+		Class       string `wmi:"__CLASS,noselect"`
+		cache       string // unexported fields are skipped
+		Notes       string `wmi:"-"`
+	}
 */
 package wmi
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/go-ole/go-ole"
-	"github.com/go-ole/go-ole/oleutil"
+	"github.com/StackExchange/wmi/cimdatetime"
+	"github.com/StackExchange/wmi/internal/fields"
+	"github.com/StackExchange/wmi/wql"
 )
 
 var l = log.New(os.Stdout, "", log.LstdFlags)
@@ -48,7 +62,6 @@ var (
 	// ErrNilCreateObject is the error returned if CreateObject returns nil even
 	// if the error was nil.
 	ErrNilCreateObject = errors.New("wmi: create object returned nil")
-	lock               sync.Mutex
 )
 
 // S_FALSE is returned by CoInitializeEx if it was already called on this thread.
@@ -62,14 +75,26 @@ func QueryNamespace(query string, dst interface{}, namespace string) error {
 // Query runs the WQL query and appends the values to dst.
 //
 // dst must have type *[]S or *[]*S, for some struct type S. Fields selected in
-// the query must have the same name in dst. Supported types are all signed and
-// unsigned integers, time.Time, string, bool, or a pointer to one of those.
-// Array types are not supported.
+// the query must have the same name in dst, or the name given by the field's
+// wmi tag. Supported types are all signed and unsigned integers, time.Time,
+// time.Duration for datetime intervals, string, bool, or a pointer to one of
+// those, and slices of strings and integers for array properties. Embedded
+// objects, such as the TargetInstance of an event, are decoded into struct
+// fields (or pointers or slices of them) of a matching struct type. The
+// fields of anonymous struct fields are treated as fields of the outer
+// struct.
+//
+// Fields whose types implement Unmarshaler, or encoding.TextUnmarshaler for
+// string properties, decode their values themselves. Reference properties
+// decode into ObjectPath fields this way.
+//
+// dst may also have type *[]Record or *[]map[string]interface{}, which hold
+// every property of the results with the Go type described on Record.
 //
 // By default, the local machine and default namespace are used. These can be
 // changed using connectServerArgs. See
 // https://docs.microsoft.com/en-us/windows/desktop/WmiSdk/swbemlocator-connectserver
-// for details.
+// for details, or pass a single ConnectOptions instead.
 //
 // Query is a wrapper around DefaultClient.Query.
 func Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
@@ -79,6 +104,46 @@ func Query(query string, dst interface{}, connectServerArgs ...interface{}) erro
 	return DefaultClient.SWbemServicesClient.Query(query, dst, connectServerArgs...)
 }
 
+// QueryContext is like Query, but gives up when ctx is done. It is a wrapper
+// around DefaultClient.QueryContext.
+func QueryContext(ctx context.Context, query string, dst interface{}, connectServerArgs ...interface{}) error {
+	if DefaultClient.SWbemServicesClient == nil {
+		return DefaultClient.QueryContext(ctx, query, dst, connectServerArgs...)
+	}
+	return DefaultClient.SWbemServicesClient.QueryContext(ctx, query, dst, connectServerArgs...)
+}
+
+// QueryArgs runs the WQL query with its placeholders replaced by args and
+// appends the values to dst. See wql.Bind for the placeholder syntax:
+//
+//	err := wmi.QueryArgs("SELECT * FROM Win32_Service WHERE Name = ?", &dst, name)
+//
+// QueryArgs is a wrapper around Query, so it uses DefaultClient on the local
+// machine and default namespace.
+func QueryArgs(query string, dst interface{}, args ...interface{}) error {
+	query, err := wql.Bind(query, args...)
+	if err != nil {
+		return err
+	}
+	return Query(query, dst)
+}
+
+// Run runs the query built by q and stores the results in the destination
+// that was passed to wql.Select:
+//
+//	var dst []Win32_Service
+//	q := wql.Select(&dst).Where(wql.Eq("State", "Running")).And(wql.Like("Name", "sql%"))
+//	err := wmi.Run(q)
+//
+// Run is a wrapper around Query, so it uses DefaultClient.
+func Run(q *wql.Builder, connectServerArgs ...interface{}) error {
+	stmt, err := q.Statement()
+	if err != nil {
+		return err
+	}
+	return Query(stmt.String(), q.Dst(), connectServerArgs...)
+}
+
 // CallMethod calls a method named methodName on an instance of the class named
 // className, with the given params.
 //
@@ -94,15 +159,15 @@ type Client struct {
 	// NonePtrZero specifies if nil values for fields which aren't pointers
 	// should be returned as the field types zero value.
 	//
-	// Setting this to true allows stucts without pointer fields to be used
-	// without the risk failure should a nil value returned from WMI.
+	// Deprecated: a NULL property always leaves a field which isn't a
+	// pointer at its zero value, so setting this has no effect.
 	NonePtrZero bool
 
 	// PtrNil specifies if nil values for pointer fields should be returned
 	// as nil.
 	//
 	// Setting this to true will set pointer fields to nil where WMI
-	// returned nil, otherwise the types zero value will be returned.
+	// returned NULL, otherwise they point to the types zero value.
 	PtrNil bool
 
 	// AllowMissingFields specifies that struct fields not present in the
@@ -116,98 +181,61 @@ type Client struct {
 	// initialized and then reused across multiple queries. If it is null
 	// then the method will initialize a new temporary client each time.
 	SWbemServicesClient *SWbemServices
+
+	// Backend is the WMI implementation used by the client. If nil,
+	// DefaultBackend is used.
+	Backend Backend
+
+	// MaxConcurrency limits the number of calls, such as queries, that the
+	// client runs at once; the others wait for their turn in order. Zero
+	// means no limit. Every call runs on its own locked OS thread with its
+	// own COM initialization, so calls don't need to be serialized, but a
+	// limit keeps many goroutines from flooding WMI or a remote host.
+	// Subscriptions and SWbemServicesClient are not limited. MaxConcurrency
+	// must not be changed once the client is in use. Copies of the client
+	// made after its first call share its limit; copies made before that
+	// each get a limit of their own.
+	MaxConcurrency int
+
+	limiter chan struct{} // created on first use, guarded by limiterMu
 }
 
 // DefaultClient is the default Client and is used by Query, QueryNamespace, and CallMethod.
 var DefaultClient = &Client{}
 
-// coinitService coinitializes WMI service. If no error is returned, a cleanup function
-// is returned which must be executed (usually deferred) to clean up allocated resources.
-func (c *Client) coinitService(connectServerArgs ...interface{}) (*ole.IDispatch, func(), error) {
-	var unknown *ole.IUnknown
-	var wmi *ole.IDispatch
-	var serviceRaw *ole.VARIANT
-
-	// be sure teardown happens in the reverse
-	// order from that which they were created
-	deferFn := func() {
-		if serviceRaw != nil {
-			serviceRaw.Clear()
-		}
-		if wmi != nil {
-			wmi.Release()
-		}
-		if unknown != nil {
-			unknown.Release()
-		}
-		ole.CoUninitialize()
-	}
-
-	// if we error'ed here, clean up immediately
-	var err error
-	defer func() {
-		if err != nil {
-			deferFn()
-		}
-	}()
-
-	err = ole.CoInitializeEx(0, ole.COINIT_MULTITHREADED)
-	if err != nil {
-		oleCode := err.(*ole.OleError).Code()
-		if oleCode != ole.S_OK && oleCode != S_FALSE {
-			return nil, nil, err
-		}
-	}
-
-	unknown, err = oleutil.CreateObject("WbemScripting.SWbemLocator")
-	if err != nil {
-		return nil, nil, err
-	} else if unknown == nil {
-		return nil, nil, ErrNilCreateObject
-	}
-
-	wmi, err = unknown.QueryInterface(ole.IID_IDispatch)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	// service is a SWbemServices
-	serviceRaw, err = oleutil.CallMethod(wmi, "ConnectServer", connectServerArgs...)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	return serviceRaw.ToIDispatch(), deferFn, nil
-}
-
 // CallMethod calls a WMI method named methodName on an instance
 // of the class named className. It passes in the arguments given
-// in params. Use connectServerArgs to customize the machine and
+// in params. className may also be the object path of an instance,
+// such as Win32_Service.Name="Spooler", to call an instance method
+// such as StopService on it. Use connectServerArgs to customize the machine and
 // namespace; by default, the local machine and default namespace
 // are used. See
 // https://docs.microsoft.com/en-us/windows/desktop/WmiSdk/swbemlocator-connectserver
 // for details.
 func (c *Client) CallMethod(connectServerArgs []interface{}, className, methodName string, params []interface{}) (int32, error) {
-	service, cleanup, err := c.coinitService(connectServerArgs...)
+	defer c.limit()()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	service, cleanup, err := c.connectService(connectServerArgs...)
 	if err != nil {
 		return 0, fmt.Errorf("coinit: %v", err)
 	}
 	defer cleanup()
 
 	// Get class
-	classRaw, err := oleutil.CallMethod(service, "Get", className)
+	class, err := service.Get(className)
 	if err != nil {
 		return 0, fmt.Errorf("CallMethod Get class %s: %v", className, err)
 	}
-	class := classRaw.ToIDispatch()
-	defer classRaw.Clear()
+	defer class.Release()
 
 	// Run method
-	resultRaw, err := oleutil.CallMethod(class, methodName, params...)
+	resultRaw, err := class.CallMethod(methodName, params...)
 	if err != nil {
 		return 0, fmt.Errorf("CallMethod %s.%s: %v", className, methodName, err)
 	}
-	resultInt, ok := resultRaw.Value().(int32)
+	resultInt, ok := resultRaw.(int32)
 	if !ok {
 		return 0, fmt.Errorf("return value was not an int32: %v (%T)", resultRaw, resultRaw)
 	}
@@ -218,14 +246,26 @@ func (c *Client) CallMethod(connectServerArgs []interface{}, className, methodNa
 // Query runs the WQL query and appends the values to dst.
 //
 // dst must have type *[]S or *[]*S, for some struct type S. Fields selected in
-// the query must have the same name in dst. Supported types are all signed and
-// unsigned integers, time.Time, string, bool, or a pointer to one of those.
-// Array types are not supported.
+// the query must have the same name in dst, or the name given by the field's
+// wmi tag. Supported types are all signed and unsigned integers, time.Time,
+// time.Duration for datetime intervals, string, bool, or a pointer to one of
+// those, and slices of strings and integers for array properties. Embedded
+// objects, such as the TargetInstance of an event, are decoded into struct
+// fields (or pointers or slices of them) of a matching struct type. The
+// fields of anonymous struct fields are treated as fields of the outer
+// struct.
+//
+// Fields whose types implement Unmarshaler, or encoding.TextUnmarshaler for
+// string properties, decode their values themselves. Reference properties
+// decode into ObjectPath fields this way.
+//
+// dst may also have type *[]Record or *[]map[string]interface{}, which hold
+// every property of the results with the Go type described on Record.
 //
 // By default, the local machine and default namespace are used. These can be
 // changed using connectServerArgs. See
 // https://docs.microsoft.com/en-us/windows/desktop/WmiSdk/swbemlocator-connectserver
-// for details.
+// for details, or pass a single ConnectOptions instead.
 func (c *Client) Query(query string, dst interface{}, connectServerArgs ...interface{}) error {
 	dv := reflect.ValueOf(dst)
 	if dv.Kind() != reflect.Ptr || dv.IsNil() {
@@ -237,83 +277,186 @@ func (c *Client) Query(query string, dst interface{}, connectServerArgs ...inter
 		return ErrInvalidEntityType
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
+	defer c.limit()()
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
-	service, cleanup, err := c.coinitService(connectServerArgs...)
+	service, cleanup, err := c.connectService(connectServerArgs...)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
 
-	// result is a SWBemObjectSet
-	resultRaw, err := oleutil.CallMethod(service, "ExecQuery", query)
+	return c.execQuery(service, query, dv, mat, elemType)
+}
+
+// QueryContext is like Query, but gives up when ctx is done and returns
+// ctx.Err(), so that a provider that doesn't respond can't block it forever.
+// The query runs semisynchronously, with wbemFlagReturnImmediately, and its
+// results are fetched with a timeout so that ctx is checked in between.
+// When QueryContext gives up, dst may hold some of the results.
+//
+// ctx doesn't cover connecting: ConnectServer waits for a server that doesn't
+// respond until RPC gives up, unless connectServerArgs set the
+// ConnectFlagUseMaxWait security flag, which bounds the wait to two minutes.
+func (c *Client) QueryContext(ctx context.Context, query string, dst interface{}, connectServerArgs ...interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return ErrInvalidEntityType
+	}
+	dv = dv.Elem()
+	mat, elemType := checkMultiArg(dv)
+	if mat == multiArgTypeInvalid {
+		return ErrInvalidEntityType
+	}
+
+	release, err := c.limitContext(ctx)
 	if err != nil {
 		return err
 	}
-	result := resultRaw.ToIDispatch()
-	defer resultRaw.Clear()
+	defer release()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 
-	count, err := oleInt64(result, "Count")
+	service, cleanup, err := c.connectService(connectServerArgs...)
 	if err != nil {
 		return err
 	}
+	defer cleanup()
 
-	enumProperty, err := result.GetProperty("_NewEnum")
+	return c.execQueryContext(ctx, service, query, dv, mat, elemType)
+}
+
+// pollInterval is how long QueryContext and Subscribe wait for a result
+// before they check their context again.
+const pollInterval = 250 * time.Millisecond
+
+// QueryArgs runs the WQL query with its placeholders replaced by args and
+// appends the values to dst, on the local machine and default namespace.
+// Strings, integers, bools, time.Time values (as CIM DATETIME) and object
+// paths are quoted and escaped, so user input can't change the query. See
+// wql.Bind for the placeholder syntax and Query for the supported dst types.
+func (c *Client) QueryArgs(query string, dst interface{}, args ...interface{}) error {
+	query, err := wql.Bind(query, args...)
 	if err != nil {
 		return err
 	}
-	defer enumProperty.Clear()
+	return c.Query(query, dst)
+}
 
-	enum, err := enumProperty.ToIUnknown().IEnumVARIANT(ole.IID_IEnumVariant)
+// Run runs the query built by q and stores the results in the destination
+// that was passed to wql.Select. See Query for the supported destination
+// types and connectServerArgs.
+func (c *Client) Run(q *wql.Builder, connectServerArgs ...interface{}) error {
+	stmt, err := q.Statement()
 	if err != nil {
 		return err
 	}
-	if enum == nil {
-		return fmt.Errorf("can't get IEnumVARIANT, enum is nil")
+	return c.Query(stmt.String(), q.Dst(), connectServerArgs...)
+}
+
+// execQuery runs query on service and appends the results to dv, a slice
+// value whose element type and category were reported by checkMultiArg.
+func (c *Client) execQuery(service Service, query string, dv reflect.Value, mat multiArgType, elemType reflect.Type) error {
+	result, err := service.ExecQuery(query)
+	if err != nil {
+		return err
+	}
+	defer result.Release()
+
+	count, err := result.Count()
+	if err != nil {
+		return err
 	}
-	defer enum.Release()
 
 	// Initialize a slice with Count capacity
-	dv.Set(reflect.MakeSlice(dv.Type(), 0, int(count)))
+	dv.Set(reflect.MakeSlice(dv.Type(), 0, count))
+	return c.loadResults(dv, mat, elemType, result.Next)
+}
+
+// execQueryContext is like execQuery, but runs query semisynchronously and
+// abandons the results when ctx is done.
+func (c *Client) execQueryContext(ctx context.Context, service Service, query string, dv reflect.Value, mat multiArgType, elemType reflect.Type) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	result, err := service.ExecQuerySemisync(query)
+	if err != nil {
+		return err
+	}
+	defer result.Release()
 
+	dv.Set(reflect.MakeSlice(dv.Type(), 0, 0))
+	return c.loadResults(dv, mat, elemType, func() (Object, error) {
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			item, err := result.NextTimeout(pollInterval)
+			if err != ErrTimedOut {
+				return item, err
+			}
+		}
+	})
+}
+
+// loadResults appends the objects returned by next to dv until it returns
+// io.EOF.
+func (c *Client) loadResults(dv reflect.Value, mat multiArgType, elemType reflect.Type, next func() (Object, error)) error {
 	var errFieldMismatch error
-	for itemRaw, length, err := enum.Next(1); length > 0; itemRaw, length, err = enum.Next(1) {
+	for {
+		item, err := next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return err
 		}
 
-		err := func() error {
-			// item is a SWbemObject, but really a Win32_Process
-			item := itemRaw.ToIDispatch()
-			defer item.Release()
-
-			ev := reflect.New(elemType)
-			if err = c.loadEntity(ev.Interface(), item); err != nil {
-				if _, ok := err.(*ErrFieldMismatch); ok {
-					// We continue loading entities even in the face of field mismatch errors.
-					// If we encounter any other error, that other error is returned. Otherwise,
-					// an ErrFieldMismatch is returned.
-					errFieldMismatch = err
-				} else {
-					return err
-				}
-			}
-			if mat != multiArgTypeStructPtr {
-				ev = ev.Elem()
-			}
-			dv.Set(reflect.Append(dv, ev))
-			return nil
-		}()
+		// item is a SWbemObject, but really a Win32_Process
+		ev, err := c.loadElem(item, mat, elemType)
+		item.Release()
 		if err != nil {
-			return err
+			if _, ok := err.(*ErrFieldMismatch); !ok {
+				return err
+			}
+			// We continue loading entities even in the face of field mismatch errors.
+			// If we encounter any other error, that other error is returned. Otherwise,
+			// an ErrFieldMismatch is returned.
+			errFieldMismatch = err
 		}
+		dv.Set(reflect.Append(dv, ev))
 	}
 	return errFieldMismatch
 }
 
+// loadElem loads item into a new value of the element type and category
+// reported by checkMultiArg. If it returns an *ErrFieldMismatch, the value
+// is valid nonetheless.
+func (c *Client) loadElem(item Object, mat multiArgType, elemType reflect.Type) (reflect.Value, error) {
+	switch mat {
+	case multiArgTypeRecord, multiArgTypeMap:
+		r, err := loadRecord(item)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if mat == multiArgTypeMap {
+			return reflect.ValueOf(r.Map()), nil
+		}
+		return reflect.ValueOf(r), nil
+	}
+
+	ev := reflect.New(elemType)
+	err := c.loadEntity(ev.Interface(), item)
+	if _, ok := err.(*ErrFieldMismatch); err != nil && !ok {
+		return reflect.Value{}, err
+	}
+	if mat != multiArgTypeStructPtr {
+		ev = ev.Elem()
+	}
+	return ev, err
+}
+
 // ErrFieldMismatch is returned when a field is to be loaded into a different
 // type than the one it was stored from, or when a field is missing or
 // unexported in the destination struct.
@@ -329,13 +472,17 @@ func (e *ErrFieldMismatch) Error() string {
 		e.FieldName, e.StructType, e.Reason)
 }
 
-var timeType = reflect.TypeOf(time.Time{})
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	objectPathType = reflect.TypeOf(ObjectPath{})
+)
 
 // loadEntity loads a SWbemObject into a struct pointer.
-func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismatch error) {
+func (c *Client) loadEntity(dst interface{}, src Object) (errFieldMismatch error) {
 	v := reflect.ValueOf(dst).Elem()
-	for i := 0; i < v.NumField(); i++ {
-		f := v.Field(i)
+	for _, field := range fields.Of(v.Type()).Fields {
+		f := field.Value(v)
 		of := f
 		isPtr := f.Kind() == reflect.Ptr
 		if isPtr {
@@ -343,10 +490,7 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 			f.Set(ptr)
 			f = f.Elem()
 		}
-		n := v.Type().Field(i).Name
-		if n[0] < 'A' || n[0] > 'Z' {
-			continue
-		}
+		n := field.GoName
 		if !f.CanSet() {
 			return &ErrFieldMismatch{
 				StructType: of.Type(),
@@ -354,7 +498,7 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 				Reason:     "CanSet() is false",
 			}
 		}
-		prop, err := oleutil.GetProperty(src, n)
+		prop, err := src.GetProperty(field.Name)
 		if err != nil {
 			if !c.AllowMissingFields {
 				errFieldMismatch = &ErrFieldMismatch{
@@ -365,13 +509,24 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 			}
 			continue
 		}
-		defer prop.Clear()
+		if prop == nil { // NULL
+			if isPtr && c.PtrNil {
+				of.Set(reflect.Zero(of.Type()))
+			} else if _, err := unmarshal(f, field.Name, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		defer releaseObjects(prop)
 
-		if prop.VT == 0x1 { //VT_NULL
+		if ok, err := unmarshal(f, field.Name, prop); ok {
+			if err != nil {
+				return err
+			}
 			continue
 		}
 
-		switch val := prop.Value().(type) {
+		switch val := prop.(type) {
 		case int8, int16, int32, int64, int:
 			v := reflect.ValueOf(val).Int()
 			switch f.Kind() {
@@ -401,6 +556,14 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 				}
 			}
 		case string:
+			if f.Type() == durationType {
+				d, err := cimdatetime.ParseInterval(val)
+				if err != nil {
+					return err
+				}
+				f.SetInt(int64(d))
+				break
+			}
 			switch f.Kind() {
 			case reflect.String:
 				f.SetString(val)
@@ -419,20 +582,20 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 			case reflect.Struct:
 				switch f.Type() {
 				case timeType:
-					if len(val) == 25 {
-						mins, err := strconv.Atoi(val[22:])
-						if err != nil {
-							return err
-						}
-						val = val[:22] + fmt.Sprintf("%02d%02d", mins/60, mins%60)
-					}
-					t, err := time.Parse("20060102150405.000000-0700", val)
+					t, err := cimdatetime.ParseTime(val)
 					if err != nil {
 						return err
 					}
 					f.Set(reflect.ValueOf(t))
 				}
 			}
+		case Object:
+			if err := c.loadObject(f, val, n); err != nil {
+				if _, ok := err.(*ErrFieldMismatch); !ok {
+					return err
+				}
+				errFieldMismatch = err
+			}
 		case bool:
 			switch f.Kind() {
 			case reflect.Bool:
@@ -455,42 +618,68 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 					Reason:     "not a Float32",
 				}
 			}
-		default:
-			if f.Kind() == reflect.Slice {
+		case []interface{}:
+			switch f.Kind() {
+			case reflect.Slice:
+				if et := reflect.PtrTo(f.Type().Elem()); et.Implements(unmarshalerType) || et.Implements(textUnmarshalerType) && et.Elem() != timeType {
+					fArr := reflect.MakeSlice(f.Type(), len(val), len(val))
+					for i, v := range val {
+						ok, err := unmarshal(fArr.Index(i), field.Name, v)
+						if err != nil {
+							return err
+						}
+						if !ok {
+							return &ErrFieldMismatch{
+								StructType: of.Type(),
+								FieldName:  n,
+								Reason:     fmt.Sprintf("cannot unmarshal slice element type (%T)", v),
+							}
+						}
+					}
+					f.Set(fArr)
+					break
+				}
 				switch f.Type().Elem().Kind() {
 				case reflect.String:
-					safeArray := prop.ToArray()
-					if safeArray != nil {
-						arr := safeArray.ToValueArray()
-						fArr := reflect.MakeSlice(f.Type(), len(arr), len(arr))
-						for i, v := range arr {
-							s := fArr.Index(i)
-							s.SetString(v.(string))
-						}
-						f.Set(fArr)
+					fArr := reflect.MakeSlice(f.Type(), len(val), len(val))
+					for i, v := range val {
+						s := fArr.Index(i)
+						s.SetString(v.(string))
 					}
+					f.Set(fArr)
 				case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
-					safeArray := prop.ToArray()
-					if safeArray != nil {
-						arr := safeArray.ToValueArray()
-						fArr := reflect.MakeSlice(f.Type(), len(arr), len(arr))
-						for i, v := range arr {
-							s := fArr.Index(i)
-							s.SetUint(reflect.ValueOf(v).Uint())
-						}
-						f.Set(fArr)
+					fArr := reflect.MakeSlice(f.Type(), len(val), len(val))
+					for i, v := range val {
+						s := fArr.Index(i)
+						s.SetUint(reflect.ValueOf(v).Uint())
 					}
+					f.Set(fArr)
 				case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
-					safeArray := prop.ToArray()
-					if safeArray != nil {
-						arr := safeArray.ToValueArray()
-						fArr := reflect.MakeSlice(f.Type(), len(arr), len(arr))
-						for i, v := range arr {
-							s := fArr.Index(i)
-							s.SetInt(reflect.ValueOf(v).Int())
+					fArr := reflect.MakeSlice(f.Type(), len(val), len(val))
+					for i, v := range val {
+						s := fArr.Index(i)
+						s.SetInt(reflect.ValueOf(v).Int())
+					}
+					f.Set(fArr)
+				case reflect.Struct, reflect.Ptr:
+					fArr := reflect.MakeSlice(f.Type(), len(val), len(val))
+					for i, v := range val {
+						o, ok := v.(Object)
+						if !ok {
+							return &ErrFieldMismatch{
+								StructType: of.Type(),
+								FieldName:  n,
+								Reason:     fmt.Sprintf("unsupported slice element type (%T)", v),
+							}
+						}
+						if err := c.loadObject(fArr.Index(i), o, n); err != nil {
+							if _, ok := err.(*ErrFieldMismatch); !ok {
+								return err
+							}
+							errFieldMismatch = err
 						}
-						f.Set(fArr)
 					}
+					f.Set(fArr)
 				default:
 					return &ErrFieldMismatch{
 						StructType: of.Type(),
@@ -498,34 +687,72 @@ func (c *Client) loadEntity(dst interface{}, src *ole.IDispatch) (errFieldMismat
 						Reason:     fmt.Sprintf("unsupported slice type (%T)", val),
 					}
 				}
-			} else {
-				typeof := reflect.TypeOf(val)
-				if typeof == nil && (isPtr || c.NonePtrZero) {
-					if (isPtr && c.PtrNil) || (!isPtr && c.NonePtrZero) {
-						of.Set(reflect.Zero(of.Type()))
-					}
-					break
-				}
+			default:
 				return &ErrFieldMismatch{
 					StructType: of.Type(),
 					FieldName:  n,
 					Reason:     fmt.Sprintf("unsupported type (%T)", val),
 				}
 			}
+		default:
+			return &ErrFieldMismatch{
+				StructType: of.Type(),
+				FieldName:  n,
+				Reason:     fmt.Sprintf("unsupported type (%T)", val),
+			}
 		}
 	}
 	return errFieldMismatch
 }
 
+// loadObject loads the embedded object o into f, which must be a struct or a
+// pointer to a struct. name is the name of the field, for errors.
+func (c *Client) loadObject(f reflect.Value, o Object, name string) error {
+	if f.Kind() == reflect.Ptr {
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+	if f.Kind() != reflect.Struct || f.Type() == timeType {
+		return &ErrFieldMismatch{
+			StructType: f.Type(),
+			FieldName:  name,
+			Reason:     "not a struct",
+		}
+	}
+	return c.loadEntity(f.Addr().Interface(), o)
+}
+
+// releaseObjects releases the embedded objects of a property value.
+func releaseObjects(prop interface{}) {
+	switch v := prop.(type) {
+	case Object:
+		v.Release()
+	case []interface{}:
+		for _, e := range v {
+			if o, ok := e.(Object); ok {
+				o.Release()
+			}
+		}
+	}
+}
+
 type multiArgType int
 
 const (
 	multiArgTypeInvalid multiArgType = iota
 	multiArgTypeStruct
 	multiArgTypeStructPtr
+	multiArgTypeRecord
+	multiArgTypeMap
 )
 
-// checkMultiArg checks that v has type []S, []*S for some struct type S.
+var (
+	recordType = reflect.TypeOf(Record{})
+	mapType    = reflect.TypeOf(map[string]interface{}{})
+)
+
+// checkMultiArg checks that v has type []S, []*S for some struct type S, or
+// type []Record or []map[string]interface{}.
 //
 // It returns what category the slice's elements are, and the reflect.Type
 // that represents S.
@@ -533,7 +760,18 @@ func checkMultiArg(v reflect.Value) (m multiArgType, elemType reflect.Type) {
 	if v.Kind() != reflect.Slice {
 		return multiArgTypeInvalid, nil
 	}
-	elemType = v.Type().Elem()
+	return checkElemType(v.Type().Elem())
+}
+
+// checkElemType returns the category of the element type of a slice or
+// channel of query results, and the struct type for structs.
+func checkElemType(elemType reflect.Type) (multiArgType, reflect.Type) {
+	switch elemType {
+	case recordType:
+		return multiArgTypeRecord, elemType
+	case mapType:
+		return multiArgTypeMap, elemType
+	}
 	switch elemType.Kind() {
 	case reflect.Struct:
 		return multiArgTypeStruct, elemType
@@ -546,44 +784,30 @@ func checkMultiArg(v reflect.Value) (m multiArgType, elemType reflect.Type) {
 	return multiArgTypeInvalid, nil
 }
 
-func oleInt64(item *ole.IDispatch, prop string) (int64, error) {
-	v, err := oleutil.GetProperty(item, prop)
-	if err != nil {
-		return 0, err
-	}
-	defer v.Clear()
-
-	i := int64(v.Val)
-	return i, nil
-}
-
 // CreateQuery returns a WQL query string that queries all columns of src. where
 // is an optional string that is appended to the query, to be used with WHERE
 // clauses. In such a case, the "WHERE" string should appear at the beginning.
-// The wmi class is obtained by the name of the type. You can pass a optional
-// class throught the variadic class parameter which is useful for anonymous
-// structs.
+// The wmi class is obtained by the name of the type, or the wmi tag of a blank
+// (_) field if it has one. You can pass a optional class throught the
+// variadic class parameter which is useful for anonymous structs. Fields
+// tagged wmi:"-" or with the noselect option are not queried.
+//
+// The where string is used verbatim. To build conditions from values without
+// having to quote them, use the wql.Select builder and Run instead.
 func CreateQuery(src interface{}, where string, class ...string) string {
 	var b bytes.Buffer
 	b.WriteString("SELECT ")
-	s := reflect.Indirect(reflect.ValueOf(src))
-	t := s.Type()
-	if s.Kind() == reflect.Slice {
-		t = t.Elem()
-	}
-	if t.Kind() != reflect.Struct {
+	t, ok := fields.StructType(src)
+	if !ok {
 		return ""
 	}
-	var fields []string
-	for i := 0; i < t.NumField(); i++ {
-		fields = append(fields, t.Field(i).Name)
-	}
-	b.WriteString(strings.Join(fields, ", "))
+	s := fields.Of(t)
+	b.WriteString(strings.Join(s.Selected(), ", "))
 	b.WriteString(" FROM ")
 	if len(class) > 0 {
 		b.WriteString(class[0])
 	} else {
-		b.WriteString(t.Name())
+		b.WriteString(s.Class)
 	}
 	b.WriteString(" " + where)
 	return b.String()
@@ -0,0 +1,157 @@
+package wmitest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/StackExchange/wmi"
+	"github.com/StackExchange/wmi/wql"
+)
+
+// An instanceRef identifies an instance in the repository.
+type instanceRef struct {
+	class *class
+	index int
+}
+
+// instance returns the instance at path. r.mu must be held.
+func (s *service) instance(path string) (instanceRef, bool) {
+	cl, relPath, ok := s.lookup(path)
+	if !ok || strings.IndexAny(relPath, ".=") < 0 {
+		return instanceRef{}, false
+	}
+	for _, c := range s.subclasses(cl) {
+		if i := c.find(relPath); i >= 0 {
+			return instanceRef{c, i}, true
+		}
+	}
+	return instanceRef{}, false
+}
+
+// derives reports whether cl is the class called name or one of its
+// subclasses.
+func (cl *class) derives(name string) bool {
+	for c := cl; c != nil; c = c.superclass {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// refProps returns the reference properties of cl in alphabetical order.
+func (cl *class) refProps() []Property {
+	var refs []Property
+	for _, p := range cl.props {
+		if p.Type == wmi.CIMTypeReference {
+			refs = append(refs, p)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs
+}
+
+// links calls f for every reference of an association instance to target,
+// whose property is allowed by role, with the association instance and the
+// property. r.mu must be held.
+func (s *service) links(target instanceRef, role string, f func(assoc instanceRef, p Property)) {
+	var names []string
+	for k := range s.ns.classes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		c := s.ns.classes[k]
+		refs := c.refProps()
+		if len(refs) == 0 {
+			continue
+		}
+		for i, values := range c.instances {
+			for _, p := range refs {
+				if role != "" && !strings.EqualFold(p.Name, role) {
+					continue
+				}
+				path, _ := values[strings.ToLower(p.Name)].(string)
+				if ref, ok := s.instance(path); ok && ref == target {
+					f(instanceRef{c, i}, p)
+				}
+			}
+		}
+	}
+}
+
+// assocResults returns the instances, or with classDefsOnly their classes,
+// as an object set.
+func (s *service) assocResults(refs []instanceRef, classDefsOnly, keysOnly bool) wmi.ObjectSet {
+	var selected map[string]bool
+	if keysOnly {
+		selected = map[string]bool{}
+	}
+	set := &objectSet{}
+	seen := make(map[interface{}]bool)
+	for _, ref := range refs {
+		var key interface{} = ref
+		if classDefsOnly {
+			key = ref.class
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		o := &object{r: s.r, server: s.r.server(), class: ref.class, selected: selected}
+		if !classDefsOnly {
+			o.values = ref.class.instances[ref.index]
+		}
+		set.objects = append(set.objects, o)
+	}
+	return set
+}
+
+// associators evaluates an ASSOCIATORS OF query. r.mu must be held.
+func (s *service) associators(stmt *wql.AssociatorsStatement) (wmi.ObjectSet, error) {
+	if stmt.RequiredAssocQualifier != "" || stmt.RequiredQualifier != "" || stmt.SchemaOnly {
+		return nil, fmt.Errorf("wmitest: unsupported query %q", stmt)
+	}
+	target, ok := s.instance(stmt.Object)
+	if !ok {
+		return nil, fmt.Errorf("wmitest: not found: %s", stmt.Object)
+	}
+	var results []instanceRef
+	s.links(target, stmt.Role, func(assoc instanceRef, p Property) {
+		if stmt.AssocClass != "" && !assoc.class.derives(stmt.AssocClass) {
+			return
+		}
+		values := assoc.class.instances[assoc.index]
+		for _, q := range assoc.class.refProps() {
+			if q.Name == p.Name || stmt.ResultRole != "" && !strings.EqualFold(q.Name, stmt.ResultRole) {
+				continue
+			}
+			path, _ := values[strings.ToLower(q.Name)].(string)
+			ref, ok := s.instance(path)
+			if !ok || stmt.ResultClass != "" && !ref.class.derives(stmt.ResultClass) {
+				continue
+			}
+			results = append(results, ref)
+		}
+	})
+	return s.assocResults(results, stmt.ClassDefsOnly, stmt.KeysOnly), nil
+}
+
+// references evaluates a REFERENCES OF query. r.mu must be held.
+func (s *service) references(stmt *wql.ReferencesStatement) (wmi.ObjectSet, error) {
+	if stmt.RequiredQualifier != "" || stmt.SchemaOnly {
+		return nil, fmt.Errorf("wmitest: unsupported query %q", stmt)
+	}
+	target, ok := s.instance(stmt.Object)
+	if !ok {
+		return nil, fmt.Errorf("wmitest: not found: %s", stmt.Object)
+	}
+	var results []instanceRef
+	s.links(target, stmt.Role, func(assoc instanceRef, p Property) {
+		if stmt.ResultClass == "" || assoc.class.derives(stmt.ResultClass) {
+			results = append(results, assoc)
+		}
+	})
+	return s.assocResults(results, stmt.ClassDefsOnly, stmt.KeysOnly), nil
+}
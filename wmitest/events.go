@@ -0,0 +1,215 @@
+package wmitest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/StackExchange/wmi/wql"
+)
+
+// addEventClasses adds the system event classes to ns: __Event, the
+// intrinsic __InstanceOperationEvent and its subclasses, and
+// __ExtrinsicEvent, the superclass of classes raised with AddEvent.
+func (ns *namespace) addEventClasses() {
+	event := ns.addSystemClass("__Event", nil, Property{Name: "TIME_CREATED", Type: wmi.CIMTypeUint64})
+	op := ns.addSystemClass("__InstanceOperationEvent", event, Property{Name: "TargetInstance", Type: wmi.CIMTypeObject})
+	ns.addSystemClass("__InstanceCreationEvent", op)
+	ns.addSystemClass("__InstanceDeletionEvent", op)
+	ns.addSystemClass("__InstanceModificationEvent", op, Property{Name: "PreviousInstance", Type: wmi.CIMTypeObject})
+	ns.addSystemClass("__ExtrinsicEvent", event)
+}
+
+func (ns *namespace) addSystemClass(name string, super *class, props ...Property) *class {
+	cl := &class{
+		Class:      Class{Namespace: ns.name, Name: name, Properties: props},
+		namespace:  ns,
+		superclass: super,
+		props:      make(map[string]Property),
+	}
+	if super != nil {
+		cl.Superclass = super.Name
+		for pk, p := range super.props {
+			cl.props[pk] = p
+		}
+	}
+	for _, p := range props {
+		cl.props[strings.ToLower(p.Name)] = p
+	}
+	ns.classes[strings.ToLower(name)] = cl
+	return cl
+}
+
+// AddEvent raises an event of the named class, which must derive from
+// __Event: usually a class whose Superclass is __ExtrinsicEvent, but
+// intrinsic events may be raised as well. The class name may be prefixed
+// with a namespace as for AddInstance. TIME_CREATED is set to the current
+// time unless inst sets it.
+//
+// Intrinsic events are also raised when instances are added, put or
+// deleted: __InstanceCreationEvent by AddInstance, Client.Create and
+// Client.Put, __InstanceModificationEvent when Client.Put updates an
+// instance, and __InstanceDeletionEvent by Client.Delete.
+func (r *Repository) AddEvent(className string, inst Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ns, name := DefaultNamespace, className
+	if i := strings.LastIndex(className, ":"); i >= 0 {
+		ns, name = className[:i], className[i+1:]
+	}
+	cl, err := r.class(ns, name)
+	if err != nil {
+		return err
+	}
+	if !cl.derives("__Event") {
+		return fmt.Errorf("wmitest: %s is not an event class", cl.Name)
+	}
+	values, err := normalizeInstance(cl, inst)
+	if err != nil {
+		return err
+	}
+	if values["time_created"] == nil {
+		values["time_created"] = timeCreated()
+	}
+	r.raise(cl, values)
+	return nil
+}
+
+// timeCreated returns the current time as the TIME_CREATED of an event, in
+// 100-nanosecond intervals since January 1, 1601 UTC.
+func timeCreated() uint64 {
+	const epoch = 116444736000000000 // January 1, 1970
+	return uint64(time.Now().UnixNano()/100 + epoch)
+}
+
+// instanceEvent raises the intrinsic event of the named class for an
+// instance of cl. previous is nil unless the instance was modified. r.mu
+// must be held.
+func (r *Repository) instanceEvent(name string, cl *class, target, previous map[string]interface{}) {
+	values := map[string]interface{}{
+		"targetinstance": &embedded{class: cl, values: target},
+		"time_created":   timeCreated(),
+	}
+	if previous != nil {
+		values["previousinstance"] = &embedded{class: cl, values: previous}
+	}
+	r.raise(cl.namespace.classes[strings.ToLower(name)], values)
+}
+
+// raise queues an event of class cl for the subscriptions whose queries it
+// matches. r.mu must be held.
+func (r *Repository) raise(cl *class, values map[string]interface{}) {
+	for sub := range r.subs {
+		if sub.ns != cl.namespace || !cl.derives(sub.class.Name) {
+			continue
+		}
+		o := &object{r: r, server: r.server(), class: cl, values: values, pathless: true}
+		if sub.where != nil {
+			// Events that the condition can't be evaluated for don't
+			// match, as in WMI.
+			if ok, err := eval(sub.where, o); err != nil || !ok {
+				continue
+			}
+		}
+		o.selected = sub.selected
+		sub.events = append(sub.events, o)
+		select {
+		case sub.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// A subscription is an event query of an eventSource.
+type subscription struct {
+	ns       *namespace
+	class    *class
+	where    wql.Expr
+	selected map[string]bool
+	events   []*object     // queued events, guarded by r.mu
+	ready    chan struct{} // signaled when events are queued
+}
+
+// ExecNotificationQuery subscribes to the events of a WQL event query, such
+// as SELECT * FROM __InstanceCreationEvent WITHIN 1 WHERE TargetInstance ISA
+// 'Win32_Process'. WITHIN is accepted and ignored, since events are raised
+// right away; GROUP WITHIN is not supported.
+func (s *service) ExecNotificationQuery(query string) (wmi.EventSource, error) {
+	stmt, err := wql.Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("wmitest: invalid query %q: %v", query, err)
+	}
+	sel, ok := stmt.(*wql.SelectStatement)
+	if !ok || sel.GroupWithin != nil {
+		return nil, fmt.Errorf("wmitest: unsupported query %q", query)
+	}
+
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+
+	cl, ok := s.ns.classes[strings.ToLower(sel.From.Name)]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: invalid class %s", sel.From.Name)
+	}
+	if !cl.derives("__Event") {
+		return nil, fmt.Errorf("wmitest: invalid query %q: %s is not an event class", query, cl.Name)
+	}
+	sub := &subscription{ns: s.ns, class: cl, where: sel.Where, ready: make(chan struct{}, 1)}
+	if sel.Fields != nil {
+		sub.selected = make(map[string]bool, len(sel.Fields))
+		for _, f := range sel.Fields {
+			if _, ok := cl.props[strings.ToLower(f.Name)]; !ok && !isSystemProperty(f.Name) {
+				return nil, fmt.Errorf("wmitest: invalid query %q: class %s has no property %s", query, cl.Name, f.Name)
+			}
+			sub.selected[strings.ToLower(f.Name)] = true
+		}
+	}
+	s.r.subs[sub] = true
+	return &eventSource{r: s.r, sub: sub}, nil
+}
+
+type eventSource struct {
+	r   *Repository
+	sub *subscription
+}
+
+// NextEvent returns the next queued event, waiting up to timeout for one
+// if none is. A negative timeout waits indefinitely.
+func (e *eventSource) NextEvent(timeout time.Duration) (wmi.Object, error) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		e.r.mu.Lock()
+		if !e.r.subs[e.sub] {
+			e.r.mu.Unlock()
+			return nil, errors.New("wmitest: event source released")
+		}
+		if len(e.sub.events) > 0 {
+			o := e.sub.events[0]
+			e.sub.events = e.sub.events[1:]
+			e.r.mu.Unlock()
+			return o, nil
+		}
+		e.r.mu.Unlock()
+
+		select {
+		case <-e.sub.ready:
+		case <-expired:
+			return nil, wmi.ErrTimedOut
+		}
+	}
+}
+
+// Release cancels the subscription.
+func (e *eventSource) Release() {
+	e.r.mu.Lock()
+	defer e.r.mu.Unlock()
+	delete(e.r.subs, e.sub)
+}
@@ -0,0 +1,642 @@
+package wmitest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/wmi"
+)
+
+// A Fixture holds recorded query results. It is stored as JSON.
+type Fixture struct {
+	Queries []*RecordedQuery `json:"queries"`
+}
+
+// A RecordedQuery is a query together with the objects it returned.
+type RecordedQuery struct {
+	// ConnectServerArgs are the server and namespace the query was run
+	// against, the first two connect arguments. The others, such as the
+	// user, password and authority, are not recorded, so that credentials
+	// don't end up in fixtures, and are ignored when replaying.
+	ConnectServerArgs []interface{} `json:"connectServerArgs"`
+	Query             string        `json:"query"`
+	// Error is the error returned by the query, if any.
+	Error   string           `json:"error,omitempty"`
+	Objects []RecordedObject `json:"objects"`
+}
+
+// A RecordedObject holds the property values of an object by name. Only the
+// properties that were read while recording are present.
+type RecordedObject map[string]RecordedValue
+
+// A RecordedValue is a property value together with its VARIANT type, for
+// example VT_I4, VT_BSTR, VT_NULL or VT_ARRAY|VT_BSTR. The value of an
+// embedded object, of type VT_DISPATCH, is a RecordedObject.
+type RecordedValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+	// CIMType is the CIM type of a property that was listed by
+	// wmi.Object.Properties, such as for a query into a *[]wmi.Record.
+	CIMType wmi.CIMType `json:"cimtype,omitempty"`
+}
+
+// ReadFixture reads a JSON fixture from r.
+func ReadFixture(r io.Reader) (*Fixture, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	f := new(Fixture)
+	if err := dec.Decode(f); err != nil {
+		return nil, fmt.Errorf("wmitest: reading fixture: %v", err)
+	}
+	return f, nil
+}
+
+// LoadFixture reads a JSON fixture from the named file.
+func LoadFixture(name string) (*Fixture, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFixture(file)
+}
+
+// WriteTo writes f to w as indented JSON.
+func (f *Fixture) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// Save writes f to the named file.
+func (f *Fixture) Save(name string) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// variantTypes maps the Go types of property values to VARIANT type names.
+var variantTypes = []struct {
+	name string
+	zero interface{}
+}{
+	{"VT_I1", int8(0)},
+	{"VT_UI1", uint8(0)},
+	{"VT_I2", int16(0)},
+	{"VT_UI2", uint16(0)},
+	{"VT_I4", int32(0)},
+	{"VT_UI4", uint32(0)},
+	{"VT_I8", int64(0)},
+	{"VT_UI8", uint64(0)},
+	{"VT_INT", int(0)},
+	{"VT_UINT", uint(0)},
+	{"VT_R4", float32(0)},
+	{"VT_R8", float64(0)},
+	{"VT_BSTR", ""},
+	{"VT_BOOL", false},
+	{"VT_DATE", time.Time{}},
+}
+
+// variantType returns the VARIANT type name of v.
+func variantType(v interface{}) (string, error) {
+	if v == nil {
+		return "VT_NULL", nil
+	}
+	if _, ok := v.(wmi.Object); ok {
+		return "VT_DISPATCH", nil
+	}
+	if arr, ok := v.([]interface{}); ok {
+		elem := "VT_VARIANT"
+		for i, e := range arr {
+			t, err := variantType(e)
+			if err != nil {
+				return "", err
+			}
+			if i == 0 {
+				elem = t
+			} else if t != elem {
+				elem = "VT_VARIANT"
+				break
+			}
+		}
+		return "VT_ARRAY|" + elem, nil
+	}
+	for _, t := range variantTypes {
+		if reflect.TypeOf(t.zero) == reflect.TypeOf(v) {
+			return t.name, nil
+		}
+	}
+	return "", fmt.Errorf("wmitest: can't record value of type %T", v)
+}
+
+// recordValue converts a property value to a RecordedValue.
+func recordValue(v interface{}) (RecordedValue, error) {
+	t, err := variantType(v)
+	if err != nil {
+		return RecordedValue{}, err
+	}
+	if tv, ok := v.(time.Time); ok {
+		return RecordedValue{Type: t, Value: tv.Format(time.RFC3339Nano)}, nil
+	}
+	return RecordedValue{Type: t, Value: v}, nil
+}
+
+// value converts v back to a property value.
+func (v RecordedValue) value() (interface{}, error) {
+	if strings.HasPrefix(v.Type, "VT_ARRAY|") {
+		arr, ok := v.Value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("wmitest: %s value %v is not an array", v.Type, v.Value)
+		}
+		elem := strings.TrimPrefix(v.Type, "VT_ARRAY|")
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			if elem == "VT_VARIANT" {
+				// Mixed arrays lose their element types; keep the JSON value.
+				out[i] = e
+				continue
+			}
+			ev, err := RecordedValue{Type: elem, Value: e}.value()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = ev
+		}
+		return out, nil
+	}
+	if v.Type == "VT_NULL" {
+		return nil, nil
+	}
+	if v.Type == "VT_DISPATCH" {
+		o, err := recordedObject(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return replayObject(o), nil
+	}
+
+	switch x := v.Value.(type) {
+	case json.Number:
+		return parseNumber(v.Type, x)
+	case string:
+		switch v.Type {
+		case "VT_BSTR":
+			return x, nil
+		case "VT_DATE":
+			return time.Parse(time.RFC3339Nano, x)
+		}
+	case bool:
+		if v.Type == "VT_BOOL" {
+			return x, nil
+		}
+	default:
+		// Values that weren't decoded from JSON, such as those of a Fixture
+		// built in memory, are used as is.
+		if t, err := variantType(x); err == nil && t == v.Type {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("wmitest: invalid %s value %v", v.Type, v.Value)
+}
+
+// recordedObject converts the value of a VT_DISPATCH RecordedValue, which is a
+// RecordedObject unless it was read from JSON, to a RecordedObject.
+func recordedObject(v interface{}) (RecordedObject, error) {
+	switch x := v.(type) {
+	case RecordedObject:
+		return x, nil
+	case map[string]interface{}:
+		o := make(RecordedObject, len(x))
+		for name, pv := range x {
+			m, ok := pv.(map[string]interface{})
+			t, _ := m["type"].(string)
+			if !ok || t == "" {
+				return nil, fmt.Errorf("wmitest: invalid value of embedded property %s", name)
+			}
+			rv := RecordedValue{Type: t, Value: m["value"]}
+			if n, ok := m["cimtype"].(json.Number); ok {
+				ct, err := n.Int64()
+				if err != nil {
+					return nil, fmt.Errorf("wmitest: invalid CIM type of embedded property %s", name)
+				}
+				rv.CIMType = wmi.CIMType(ct)
+			}
+			o[name] = rv
+		}
+		return o, nil
+	}
+	return nil, fmt.Errorf("wmitest: invalid VT_DISPATCH value %v", v)
+}
+
+// copyValue returns a deep copy of v.
+func copyValue(v RecordedValue) RecordedValue {
+	switch x := v.Value.(type) {
+	case RecordedObject:
+		v.Value = copyObject(x)
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, e := range x {
+			if o, ok := e.(RecordedObject); ok {
+				e = copyObject(o)
+			}
+			arr[i] = e
+		}
+		v.Value = arr
+	}
+	return v
+}
+
+func copyObject(o RecordedObject) RecordedObject {
+	c := make(RecordedObject, len(o))
+	for k, v := range o {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+// parseNumber converts a JSON number to the Go type of VARIANT type t.
+func parseNumber(t string, n json.Number) (interface{}, error) {
+	for _, vt := range variantTypes {
+		if vt.name != t {
+			continue
+		}
+		switch vt.zero.(type) {
+		case string, bool, time.Time:
+			return nil, fmt.Errorf("wmitest: invalid %s value %s", t, n)
+		}
+		v := reflect.New(reflect.TypeOf(vt.zero))
+		if err := json.Unmarshal([]byte(n), v.Interface()); err != nil {
+			return nil, fmt.Errorf("wmitest: invalid %s value %s: %v", t, n, err)
+		}
+		return v.Elem().Interface(), nil
+	}
+	return nil, fmt.Errorf("wmitest: unknown VARIANT type %s", t)
+}
+
+// A Recorder is a wmi.Backend that passes queries on to another Backend and
+// records them, together with the property values read from their results,
+// in a Fixture. Use it to capture real results on Windows:
+//
+//	rec := wmitest.NewRecorder(wmi.DefaultBackend)
+//	c := &wmi.Client{Backend: rec}
+//	// ... run queries with c ...
+//	err := rec.Fixture().Save("testdata/processes.json")
+//
+// Only queries are recorded; other calls are passed on unchanged.
+type Recorder struct {
+	backend wmi.Backend
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecorder returns a Recorder that records the queries run on b.
+func NewRecorder(b wmi.Backend) *Recorder {
+	return &Recorder{backend: b}
+}
+
+// Fixture returns a copy of the queries recorded so far.
+func (r *Recorder) Fixture() *Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := &Fixture{}
+	for _, q := range r.fixture.Queries {
+		c := *q
+		c.Objects = make([]RecordedObject, len(q.Objects))
+		for i, o := range q.Objects {
+			c.Objects[i] = copyObject(o)
+		}
+		f.Queries = append(f.Queries, &c)
+	}
+	return f
+}
+
+// Locator implements wmi.Backend.
+func (r *Recorder) Locator() (wmi.Locator, error) {
+	if r.backend == nil {
+		return nil, wmi.ErrNoBackend
+	}
+	l, err := r.backend.Locator()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingLocator{Locator: l, r: r}, nil
+}
+
+type recordingLocator struct {
+	wmi.Locator
+	r *Recorder
+}
+
+func (l *recordingLocator) ConnectServer(connectServerArgs ...interface{}) (wmi.Service, error) {
+	s, err := l.Locator.ConnectServer(connectServerArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingService{Service: s, r: l.r, args: connectServerArgs}, nil
+}
+
+type recordingService struct {
+	wmi.Service
+	r    *Recorder
+	args []interface{}
+}
+
+func (s *recordingService) ExecQuery(query string) (wmi.ObjectSet, error) {
+	return s.record(query, s.Service.ExecQuery)
+}
+
+func (s *recordingService) ExecQuerySemisync(query string) (wmi.ObjectSet, error) {
+	return s.record(query, s.Service.ExecQuerySemisync)
+}
+
+// record runs the query with exec and records its results as they are
+// read.
+func (s *recordingService) record(query string, exec func(string) (wmi.ObjectSet, error)) (wmi.ObjectSet, error) {
+	rq := &RecordedQuery{ConnectServerArgs: recordedArgs(s.args), Query: query, Objects: []RecordedObject{}}
+	set, err := exec(query)
+	if err != nil {
+		rq.Error = err.Error()
+	}
+	s.r.mu.Lock()
+	s.r.fixture.Queries = append(s.r.fixture.Queries, rq)
+	s.r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingObjectSet{ObjectSet: set, r: s.r, q: rq}, nil
+}
+
+type recordingObjectSet struct {
+	wmi.ObjectSet
+	r *Recorder
+	q *RecordedQuery
+}
+
+func (s *recordingObjectSet) Next() (wmi.Object, error) {
+	return s.recordNext(s.ObjectSet.Next())
+}
+
+func (s *recordingObjectSet) NextTimeout(timeout time.Duration) (wmi.Object, error) {
+	return s.recordNext(s.ObjectSet.NextTimeout(timeout))
+}
+
+func (s *recordingObjectSet) recordNext(o wmi.Object, err error) (wmi.Object, error) {
+	if err != nil {
+		return nil, err
+	}
+	ro := RecordedObject{}
+	s.r.mu.Lock()
+	s.q.Objects = append(s.q.Objects, ro)
+	s.r.mu.Unlock()
+	return &recordingObject{Object: o, r: s.r, values: ro}, nil
+}
+
+type recordingObject struct {
+	wmi.Object
+	r      *Recorder
+	values RecordedObject
+}
+
+func (o *recordingObject) GetProperty(name string) (interface{}, error) {
+	v, err := o.Object.GetProperty(name)
+	if err != nil {
+		return nil, err
+	}
+	rv, v, err := o.r.record(v)
+	if err != nil {
+		return nil, err
+	}
+	o.r.mu.Lock()
+	rv.CIMType = o.values[name].CIMType
+	o.values[name] = rv
+	o.r.mu.Unlock()
+	return v, nil
+}
+
+func (o *recordingObject) Properties() ([]wmi.Property, error) {
+	props, err := o.Object.Properties()
+	if err != nil {
+		return nil, err
+	}
+	o.r.mu.Lock()
+	for _, p := range props {
+		rv := o.values[p.Name]
+		rv.CIMType = p.Type
+		o.values[p.Name] = rv
+	}
+	o.r.mu.Unlock()
+	return props, nil
+}
+
+// record converts a property value to a RecordedValue. Embedded objects are
+// wrapped so that the properties read from them are recorded as well; the
+// returned value replaces v.
+func (r *Recorder) record(v interface{}) (RecordedValue, interface{}, error) {
+	switch x := v.(type) {
+	case wmi.Object:
+		ro := RecordedObject{}
+		return RecordedValue{Type: "VT_DISPATCH", Value: ro}, &recordingObject{Object: x, r: r, values: ro}, nil
+	case []interface{}:
+		t, err := variantType(x)
+		if err != nil || t != "VT_ARRAY|VT_DISPATCH" {
+			break
+		}
+		values := make([]interface{}, len(x))
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			ro := RecordedObject{}
+			values[i] = ro
+			out[i] = &recordingObject{Object: e.(wmi.Object), r: r, values: ro}
+		}
+		return RecordedValue{Type: t, Value: values}, out, nil
+	}
+	rv, err := recordValue(v)
+	return rv, v, err
+}
+
+// A Replayer is a wmi.Backend that serves the results of a Fixture. Queries
+// are matched by their text, server and namespace. If a query was recorded
+// several times, its results are served in order, and the last one is
+// repeated once they are exhausted.
+type Replayer struct {
+	mu      sync.Mutex
+	queries map[string][]*RecordedQuery
+}
+
+// NewReplayer returns a Replayer serving the queries recorded in f.
+func NewReplayer(f *Fixture) *Replayer {
+	r := &Replayer{queries: make(map[string][]*RecordedQuery)}
+	for _, q := range f.Queries {
+		k := replayKey(q.ConnectServerArgs, q.Query)
+		r.queries[k] = append(r.queries[k], q)
+	}
+	return r
+}
+
+// recordedArgs returns the connect arguments that are recorded: the server
+// and namespace, without trailing nil arguments.
+func recordedArgs(connectServerArgs []interface{}) []interface{} {
+	if len(connectServerArgs) > 2 {
+		connectServerArgs = connectServerArgs[:2]
+	}
+	// Trailing nil arguments are equivalent to leaving them out.
+	for len(connectServerArgs) > 0 && connectServerArgs[len(connectServerArgs)-1] == nil {
+		connectServerArgs = connectServerArgs[:len(connectServerArgs)-1]
+	}
+	return connectServerArgs
+}
+
+// replayKey returns the key a query is looked up by.
+func replayKey(connectServerArgs []interface{}, query string) string {
+	connectServerArgs = recordedArgs(connectServerArgs)
+	if connectServerArgs == nil {
+		connectServerArgs = []interface{}{}
+	}
+	b, err := json.Marshal(connectServerArgs)
+	if err != nil {
+		b = []byte(fmt.Sprint(connectServerArgs))
+	}
+	// Numbers read from a fixture are json.Number and marshal like the
+	// original values, so keys of recorded and live arguments match.
+	return string(b) + "\x00" + query
+}
+
+// Locator implements wmi.Backend.
+func (r *Replayer) Locator() (wmi.Locator, error) {
+	return replayLocator{r}, nil
+}
+
+type replayLocator struct {
+	r *Replayer
+}
+
+func (l replayLocator) ConnectServer(connectServerArgs ...interface{}) (wmi.Service, error) {
+	return &replayService{r: l.r, args: connectServerArgs}, nil
+}
+
+func (l replayLocator) Release() {}
+
+type replayService struct {
+	r    *Replayer
+	args []interface{}
+}
+
+func (s *replayService) ExecQuery(query string) (wmi.ObjectSet, error) {
+	s.r.mu.Lock()
+	k := replayKey(s.args, query)
+	qs := s.r.queries[k]
+	if len(qs) == 0 {
+		s.r.mu.Unlock()
+		return nil, fmt.Errorf("wmitest: no recorded result for query %q", query)
+	}
+	q := qs[0]
+	if len(qs) > 1 {
+		s.r.queries[k] = qs[1:]
+	}
+	s.r.mu.Unlock()
+
+	if q.Error != "" {
+		return nil, errors.New(q.Error)
+	}
+	set := &objectSet{}
+	for _, o := range q.Objects {
+		set.objects = append(set.objects, replayObject(o))
+	}
+	return set, nil
+}
+
+func (s *replayService) ExecQuerySemisync(query string) (wmi.ObjectSet, error) {
+	return s.ExecQuery(query)
+}
+
+func (s *replayService) Get(path string) (wmi.Object, error) {
+	return nil, fmt.Errorf("wmitest: Get %s: not supported when replaying", path)
+}
+
+func (s *replayService) Delete(path string) error {
+	return fmt.Errorf("wmitest: Delete %s: not supported when replaying", path)
+}
+
+func (s *replayService) ExecNotificationQuery(query string) (wmi.EventSource, error) {
+	return nil, fmt.Errorf("wmitest: event query %q: not supported when replaying", query)
+}
+
+func (s *replayService) SetSecurity(impersonation wmi.ImpersonationLevel, authentication wmi.AuthenticationLevel, privileges []string) error {
+	return nil
+}
+
+func (s *replayService) Release() {}
+
+type replayObject RecordedObject
+
+func (o replayObject) GetProperty(name string) (interface{}, error) {
+	v, ok := o[name]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: property %s was not recorded", name)
+	}
+	return v.value()
+}
+
+// Properties lists the recorded properties with a CIM type, in alphabetical
+// order like WMI itself.
+func (o replayObject) Properties() ([]wmi.Property, error) {
+	var props []wmi.Property
+	for name, v := range o {
+		if v.CIMType != 0 {
+			props = append(props, wmi.Property{Name: name, Type: v.CIMType})
+		}
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return strings.ToLower(props[i].Name) < strings.ToLower(props[j].Name)
+	})
+	return props, nil
+}
+
+func (o replayObject) CallMethod(name string, params ...interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("wmitest: method %s: not supported when replaying", name)
+}
+
+func (o replayObject) SetProperty(name string, value interface{}) error {
+	return errors.New("wmitest: SetProperty: not supported when replaying")
+}
+
+func (o replayObject) SpawnInstance() (wmi.Object, error) {
+	return nil, errors.New("wmitest: SpawnInstance: not supported when replaying")
+}
+
+func (o replayObject) RelPath() (string, error) {
+	return "", errors.New("wmitest: RelPath: not supported when replaying")
+}
+
+func (o replayObject) Put(flags int) (string, error) {
+	return "", errors.New("wmitest: Put: not supported when replaying")
+}
+
+func (o replayObject) InParameters(method string) (wmi.Object, error) {
+	return nil, fmt.Errorf("wmitest: method %s: not supported when replaying", method)
+}
+
+func (o replayObject) ExecMethod(method string, in wmi.Object) (wmi.Object, error) {
+	return nil, fmt.Errorf("wmitest: method %s: not supported when replaying", method)
+}
+
+func (o replayObject) Release() {}
@@ -0,0 +1,227 @@
+package wmitest_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/StackExchange/wmi/wmitest"
+)
+
+func TestReplayFixture(t *testing.T) {
+	f, err := wmitest.LoadFixture("testdata/win32_operatingsystem.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type Win32_OperatingSystem struct {
+		Caption            string
+		FreePhysicalMemory uint64
+		LastBootUpTime     time.Time
+		MUILanguages       *[]string
+		NumberOfProcesses  uint32
+		PAEEnabled         *bool
+	}
+	c := &wmi.Client{Backend: wmitest.NewReplayer(f), PtrNil: true}
+	var dst []Win32_OperatingSystem
+	if err := c.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 {
+		t.Fatalf("got %d results, want 1", len(dst))
+	}
+	os := dst[0]
+	if os.Caption != "Microsoft Windows Server 2019 Datacenter" || os.FreePhysicalMemory != 5874516 || os.NumberOfProcesses != 97 {
+		t.Errorf("bad scalar fields: %+v", os)
+	}
+	if want := time.Date(2021, 9, 13, 13, 45, 39, 500000000, time.UTC); !os.LastBootUpTime.Equal(want) {
+		t.Errorf("LastBootUpTime = %v, want %v", os.LastBootUpTime, want)
+	}
+	if os.MUILanguages == nil || !equal(*os.MUILanguages, []string{"en-US"}) {
+		t.Errorf("MUILanguages = %v", os.MUILanguages)
+	}
+	if os.PAEEnabled != nil {
+		t.Errorf("PAEEnabled = %v, want nil", *os.PAEEnabled)
+	}
+
+	if err := c.Query("SELECT * FROM Win32_OperatingSystem", &dst); err == nil || !strings.Contains(err.Error(), "no recorded result") {
+		t.Errorf("got %v, want error for query that wasn't recorded", err)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	rec := wmitest.NewRecorder(newRepository(t))
+	c := &wmi.Client{Backend: rec}
+
+	var live []Win32_Process
+	q := wmi.CreateQuery(&live, "WHERE ProcessId < 1000")
+	if err := c.Query(q, &live); err != nil {
+		t.Fatal(err)
+	}
+	var adapters []struct {
+		Name        string
+		IPAddresses []string
+	}
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &adapters, nil, `root\StandardCimv2`); err != nil {
+		t.Fatal(err)
+	}
+	var none []Win32_Process
+	if err := c.Query("SELECT * FROM Win32_Nothing", &none); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.Fixture().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := wmitest.ReadFixture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Queries) != 3 {
+		t.Fatalf("recorded %d queries, want 3", len(f.Queries))
+	}
+	if v := f.Queries[0].Objects[0]["VirtualSize"]; v.Type != "VT_BSTR" || v.Value != "4398046511104" {
+		t.Errorf("recorded VirtualSize as %+v, want VT_BSTR string", v)
+	}
+	if v := f.Queries[0].Objects[0]["CommandLine"]; v.Type != "VT_NULL" {
+		t.Errorf("recorded CommandLine as %+v, want VT_NULL", v)
+	}
+
+	c = &wmi.Client{Backend: wmitest.NewReplayer(f)}
+	var replayed []Win32_Process
+	if err := c.Query(q, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(live) {
+		t.Fatalf("replayed %d processes, want %d", len(replayed), len(live))
+	}
+	for i := range live {
+		l, r := live[i], replayed[i]
+		if l.Name != r.Name || l.ProcessId != r.ProcessId || l.VirtualSize != r.VirtualSize || !l.CreationDate.Equal(r.CreationDate) ||
+			(l.CommandLine == nil) != (r.CommandLine == nil) || l.CommandLine != nil && *l.CommandLine != *r.CommandLine {
+			t.Errorf("replayed %+v, want %+v", r, l)
+		}
+	}
+	adapters = nil
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &adapters, nil, `root\StandardCimv2`); err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 1 || !equal(adapters[0].IPAddresses, []string{"10.0.0.2", "fe80::1"}) {
+		t.Errorf("replayed %+v", adapters)
+	}
+	if err := c.Query("SELECT * FROM Win32_Nothing", &none); err == nil {
+		t.Error("expected recorded error")
+	}
+}
+
+func TestRecordEmbeddedObjects(t *testing.T) {
+	repo := newRepository(t)
+	err := repo.AddClass(wmitest.Class{
+		Name:       "Win32_ProcessStartEvent",
+		Properties: []wmitest.Property{{Name: "TargetInstance", Type: wmi.CIMTypeObject}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.AddInstance("Win32_ProcessStartEvent", wmitest.Instance{
+		"TargetInstance": wmitest.Instance{"__CLASS": "Win32_Process", "Name": "notepad.exe", "ProcessId": 42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type event struct {
+		TargetInstance struct {
+			Name      string
+			ProcessId uint32
+		}
+	}
+	rec := wmitest.NewRecorder(repo)
+	c := &wmi.Client{Backend: rec}
+	var live []event
+	q := "SELECT * FROM Win32_ProcessStartEvent"
+	if err := c.Query(q, &live); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.Fixture().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"VT_DISPATCH"`) {
+		t.Errorf("fixture has no VT_DISPATCH value:\n%s", buf.String())
+	}
+	f, err := wmitest.ReadFixture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = &wmi.Client{Backend: wmitest.NewReplayer(f)}
+	var replayed []event
+	if err := c.Query(q, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 1 || replayed[0] != live[0] || live[0].TargetInstance.ProcessId != 42 {
+		t.Errorf("replayed %+v, want %+v", replayed, live)
+	}
+}
+
+func TestRecordRecords(t *testing.T) {
+	rec := wmitest.NewRecorder(newRepository(t))
+	c := &wmi.Client{Backend: rec}
+	q := "SELECT * FROM Win32_Service WHERE Name = 'Spooler'"
+	var live []wmi.Record
+	if err := c.Query(q, &live); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.Fixture().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f, err := wmitest.ReadFixture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = &wmi.Client{Backend: wmitest.NewReplayer(f)}
+	var replayed []wmi.Record
+	if err := c.Query(q, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, live) {
+		t.Errorf("replayed %+v, want %+v", replayed, live)
+	}
+}
+
+func TestRecordRedactsCredentials(t *testing.T) {
+	rec := wmitest.NewRecorder(newRepository(t))
+	c := &wmi.Client{Backend: rec}
+	q := "SELECT * FROM Win32_Service WHERE Name = 'Spooler'"
+	var live []wmi.Record
+	if err := c.Query(q, &live, nil, `root\cimv2`, `CORP\admin`, "hunter2", nil, "NTLMDomain:CORP"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.Fixture().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"admin", "hunter2", "NTLMDomain"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("fixture contains %q:\n%s", secret, buf.String())
+		}
+	}
+	f, err := wmitest.ReadFixture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = &wmi.Client{Backend: wmitest.NewReplayer(f)}
+	var replayed []wmi.Record
+	if err := c.Query(q, &replayed, nil, `root\cimv2`, "other", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, live) {
+		t.Errorf("replayed %+v, want %+v", replayed, live)
+	}
+}
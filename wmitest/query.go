@@ -0,0 +1,376 @@
+package wmitest
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/StackExchange/wmi/wql"
+)
+
+// query is a data query of the form SELECT props FROM class [WHERE cond].
+type query struct {
+	props []string // nil for SELECT *
+	class string
+	where wql.Expr
+}
+
+// parseQuery parses a WQL data query. Event queries are not supported, and
+// ASSOCIATORS OF and REFERENCES OF queries are evaluated separately.
+func parseQuery(s string) (*query, error) {
+	stmt, err := wql.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("wmitest: invalid query %q: %v", s, err)
+	}
+	sel, ok := stmt.(*wql.SelectStatement)
+	if !ok || sel.Within != nil || sel.GroupWithin != nil {
+		return nil, fmt.Errorf("wmitest: unsupported query %q", s)
+	}
+	q := &query{class: sel.From.Name, where: sel.Where}
+	if sel.Fields != nil {
+		q.props = make([]string, len(sel.Fields))
+		for i, f := range sel.Fields {
+			q.props[i] = f.Name
+		}
+	}
+	return q, nil
+}
+
+// eval evaluates the condition x for the object o.
+func eval(x wql.Expr, o *object) (bool, error) {
+	switch x := x.(type) {
+	case *wql.ParenExpr:
+		return eval(x.X, o)
+	case *wql.UnaryExpr:
+		v, err := eval(x.X, o)
+		return !v, err
+	case *wql.IsNullExpr:
+		v, err := operand(x.X, o)
+		return (v == nil) != x.Not, err
+	case *wql.BinaryExpr:
+		switch x.Op {
+		case wql.AND:
+			v, err := eval(x.X, o)
+			if err != nil || !v {
+				return false, err
+			}
+			return eval(x.Y, o)
+		case wql.OR:
+			v, err := eval(x.X, o)
+			if err != nil || v {
+				return v, err
+			}
+			return eval(x.Y, o)
+		case wql.LIKE:
+			v, err := operand(x.X, o)
+			if err != nil {
+				return false, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return false, nil
+			}
+			pattern := x.Y.(*wql.BasicLit).Value
+			return like(strings.ToLower(s), strings.ToLower(pattern)) != x.Not, nil
+		case wql.ISA:
+			return isa(x, o)
+		}
+		return evalComparison(x, o)
+	}
+	return false, fmt.Errorf("unsupported condition %s", x)
+}
+
+// operand returns the normalized value of a property or constant.
+func operand(x wql.Expr, o *object) (interface{}, error) {
+	switch x := x.(type) {
+	case *wql.Ident:
+		_, v, err := o.property(x.Name)
+		return v, err
+	case *wql.BasicLit:
+		return constant(x)
+	}
+	return nil, fmt.Errorf("unsupported operand %s", x)
+}
+
+// isa evaluates x ISA 'class', which matches if x is an instance of the
+// class or of a subclass. x is __this for the object itself, or an object
+// property.
+func isa(x *wql.BinaryExpr, o *object) (bool, error) {
+	id, ok := x.X.(*wql.Ident)
+	if !ok {
+		return false, fmt.Errorf("ISA requires a property: %s", x)
+	}
+	cl := o.class
+	if !strings.EqualFold(id.Name, "__this") {
+		_, v, err := o.property(id.Name)
+		if err != nil {
+			return false, err
+		}
+		if v == nil {
+			return false, nil
+		}
+		e, ok := v.(*embedded)
+		if !ok {
+			return false, fmt.Errorf("ISA requires an object property: %s", x)
+		}
+		cl = e.class
+	}
+	name := x.Y.(*wql.BasicLit).Value
+	for c := cl; c != nil; c = c.superclass {
+		if strings.EqualFold(c.Name, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// flipped maps comparison operators to their equivalent with swapped operands.
+var flipped = map[wql.Token]wql.Token{
+	wql.EQL: wql.EQL,
+	wql.NEQ: wql.NEQ,
+	wql.LSS: wql.GTR,
+	wql.LEQ: wql.GEQ,
+	wql.GTR: wql.LSS,
+	wql.GEQ: wql.LEQ,
+}
+
+// evalComparison evaluates a comparison of a property with a constant, in
+// either order.
+func evalComparison(x *wql.BinaryExpr, o *object) (bool, error) {
+	prop, lit, op := x.X, x.Y, x.Op
+	if _, ok := prop.(*wql.BasicLit); ok {
+		prop, lit, op = lit, prop, flipped[op]
+	}
+	id, ok := prop.(*wql.Ident)
+	if !ok {
+		return false, fmt.Errorf("comparison of two constants: %s", x)
+	}
+	c, ok := lit.(*wql.BasicLit)
+	if !ok {
+		return false, fmt.Errorf("comparison of two properties: %s", x)
+	}
+	t, v, err := o.property(id.Name)
+	if err != nil {
+		return false, err
+	}
+	val, err := constant(c)
+	if err != nil {
+		return false, err
+	}
+	if val == nil {
+		// WQL allows "= NULL" and "<> NULL" as synonyms of IS [NOT] NULL.
+		switch op {
+		case wql.EQL:
+			return v == nil, nil
+		case wql.NEQ:
+			return v != nil, nil
+		}
+		return false, nil
+	}
+	if v == nil || t.IsArray() {
+		return false, nil
+	}
+	cmp, ok := compare(v, val)
+	if !ok {
+		return false, nil
+	}
+	switch op {
+	case wql.EQL:
+		return cmp == 0, nil
+	case wql.NEQ:
+		return cmp != 0, nil
+	case wql.LSS:
+		return cmp < 0, nil
+	case wql.LEQ:
+		return cmp <= 0, nil
+	case wql.GTR:
+		return cmp > 0, nil
+	case wql.GEQ:
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", op)
+}
+
+// constant returns the value of a literal as a string, int64, uint64,
+// float64, bool or nil.
+func constant(x *wql.BasicLit) (interface{}, error) {
+	switch x.Kind {
+	case wql.STRING:
+		return x.Value, nil
+	case wql.TRUE:
+		return true, nil
+	case wql.FALSE:
+		return false, nil
+	case wql.NULL:
+		return nil, nil
+	}
+	if i, err := x.Int(); err == nil {
+		return i, nil
+	}
+	if u, err := x.Uint(); err == nil {
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(x.Value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s", x.Value)
+	}
+	return f, nil
+}
+
+// compare compares the normalized property value v with the constant c. It
+// reports false if the two can't be compared.
+func compare(v, c interface{}) (int, bool) {
+	switch v := v.(type) {
+	case string:
+		var s string
+		switch c := c.(type) {
+		case string:
+			s = c
+		default:
+			s = fmt.Sprint(c)
+		}
+		return strings.Compare(strings.ToLower(v), strings.ToLower(s)), true
+	case bool:
+		var b bool
+		switch c := c.(type) {
+		case bool:
+			b = c
+		case int64:
+			b = c != 0
+		case uint64:
+			b = c != 0
+		default:
+			return 0, false
+		}
+		switch {
+		case v == b:
+			return 0, true
+		case b:
+			return -1, true
+		}
+		return 1, true
+	case float64:
+		f, ok := toFloat(c)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case v < f:
+			return -1, true
+		case v > f:
+			return 1, true
+		}
+		return 0, true
+	case int64, uint64:
+		if f, ok := c.(float64); ok {
+			fv, _ := toFloat(v)
+			return compare(fv, f)
+		}
+		x, ok := toBig(v)
+		y, ok2 := toBig(c)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	}
+	return 0, false
+}
+
+func toBig(v interface{}) (*big.Int, bool) {
+	switch v := v.(type) {
+	case int64:
+		return big.NewInt(v), true
+	case uint64:
+		return new(big.Int).SetUint64(v), true
+	case string:
+		return new(big.Int).SetString(v, 10)
+	}
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// like reports whether s matches the WQL LIKE pattern, in which % matches any
+// string, _ any character and [...] any character of a set or range, negated
+// by a leading ^.
+func like(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '%':
+			for pattern != "" && pattern[0] == '%' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if like(s[i:], pattern) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if s == "" {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			s, pattern = s[n:], pattern[1:]
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 || s == "" {
+				return false
+			}
+			set := pattern[1 : end+1]
+			r, n := utf8.DecodeRuneInString(s)
+			if !inSet(r, set) {
+				return false
+			}
+			s, pattern = s[n:], pattern[end+2:]
+		default:
+			pr, pn := utf8.DecodeRuneInString(pattern)
+			r, n := utf8.DecodeRuneInString(s)
+			if s == "" || r != pr {
+				return false
+			}
+			s, pattern = s[n:], pattern[pn:]
+		}
+	}
+	return s == ""
+}
+
+// inSet reports whether r is in the character set of a LIKE [...] pattern.
+func inSet(r rune, set string) bool {
+	negate := strings.HasPrefix(set, "^")
+	if negate {
+		set = set[1:]
+	}
+	runes := []rune(set)
+	for i := 0; i < len(runes); i++ {
+		if i+2 < len(runes) && runes[i+1] == '-' {
+			if runes[i] <= r && r <= runes[i+2] {
+				return !negate
+			}
+			i += 2
+			continue
+		}
+		if runes[i] == r {
+			return !negate
+		}
+	}
+	return negate
+}
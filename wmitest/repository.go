@@ -0,0 +1,1192 @@
+/*
+Package wmitest provides an in-memory WMI repository for testing code that
+uses package wmi, on any platform.
+
+A Repository is a wmi.Backend. Tests register class definitions and instances
+with it, and set it as the Backend of the client under test:
+
+	repo := wmitest.NewRepository()
+	repo.AddClass(wmitest.Class{
+		Name: "Win32_Process",
+		Properties: []wmitest.Property{
+			{Name: "Handle", Type: wmi.CIMTypeString, Key: true},
+			{Name: "Name", Type: wmi.CIMTypeString},
+			{Name: "ProcessId", Type: wmi.CIMTypeUint32},
+		},
+	})
+	repo.AddInstance("Win32_Process", wmitest.Instance{
+		"Handle":    "672",
+		"Name":      "lsass.exe",
+		"ProcessId": 672,
+	})
+
+	c := &wmi.Client{Backend: repo}
+
+Queries are evaluated against the registered instances, including ASSOCIATORS
+OF and REFERENCES OF queries, which follow the reference properties of
+association classes. Property values are returned with the same VARIANT
+types the WMI scripting API uses, so results go through exactly the same
+decoding as on Windows. Instances written with Client.Put or Client.Create
+are stored in the repository, and Client.Delete removes them, so tests can
+check them with further queries.
+
+Event queries, as run by Client.Subscribe, receive the intrinsic
+__InstanceCreationEvent, __InstanceModificationEvent and
+__InstanceDeletionEvent events of these changes, and extrinsic events raised
+with AddEvent.
+
+Alternatively, a Recorder captures the results of real queries on Windows in
+a Fixture, which a Replayer serves on any platform.
+*/
+package wmitest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/StackExchange/wmi/cimdatetime"
+	"github.com/StackExchange/wmi/wql"
+)
+
+// DefaultNamespace is the namespace used when none is given to ConnectServer
+// or in a class name.
+const DefaultNamespace = `root\cimv2`
+
+// A Class is a WMI class definition.
+type Class struct {
+	// Namespace is the namespace of the class. If empty, DefaultNamespace
+	// is used.
+	Namespace string
+	// Name is the name of the class, for example Win32_Process.
+	Name string
+	// Superclass is the name of the class this class derives from, if any.
+	// It must already have been added to the same namespace. A class
+	// inherits the properties and methods of its superclass.
+	Superclass string
+	// Properties are the properties of the class.
+	Properties []Property
+	// Methods are the static methods of the class, by name.
+	Methods map[string]Method
+	// InstanceMethods are the methods of the class that are called on an
+	// instance, by name.
+	InstanceMethods map[string]InstanceMethod
+	// Parameters declare the parameters of methods, by name, for calls
+	// through wmi.Client.CallMethodStruct. A method without declared
+	// parameters takes none and only returns its result.
+	Parameters map[string]Parameters
+}
+
+// A Property is a property of a class.
+type Property struct {
+	Name string
+	Type wmi.CIMType
+	// Key specifies whether the property is part of the key of the class.
+	Key bool
+}
+
+// A Method implements a WMI method of a class. It is called with the
+// parameters given to wmi.Object.CallMethod and returns the method's result.
+//
+// When called through wmi.Client.CallMethodStruct, a method receives the
+// values of its declared in-parameters in order, nil for those that are
+// NULL, with integers as int64 or uint64, reals as float64, datetimes as
+// DATETIME strings and arrays as []interface{}. Its result is the
+// ReturnValue out-parameter, unless it is an Instance, which holds the
+// values of all out-parameters.
+type Method func(params ...interface{}) (interface{}, error)
+
+// An InstanceMethod implements a WMI method that is called on an instance,
+// such as Win32_Service.StopService. It is called like a Method, with the
+// property values of the instance in addition. Calling it on the class is an
+// error.
+type InstanceMethod func(inst Instance, params ...interface{}) (interface{}, error)
+
+// Parameters are the in- and out-parameters of a method. The ReturnValue
+// out-parameter is a uint32 unless it is declared.
+type Parameters struct {
+	In  []Property
+	Out []Property
+}
+
+// An Instance holds the property values of an instance by property name.
+// Properties that are not set, or set to nil, are NULL.
+//
+// Values must match the CIM type of their property: any Go integer type
+// for integer and char16 properties, float32 or float64 for real
+// properties, bool, string for string and reference properties, a
+// DATETIME string, time.Time or, for intervals, time.Duration for datetime
+// properties, and an Instance for object properties. Array properties take
+// a slice of such values.
+//
+// The Instance of an embedded object names its class with the __CLASS key.
+// The class must be in the same namespace as the class of the property.
+type Instance map[string]interface{}
+
+// A Repository is an in-memory WMI repository. It implements wmi.Backend.
+// It is safe for concurrent use.
+type Repository struct {
+	// Server is the server name reported in the __SERVER and __PATH
+	// system properties. If empty, "localhost" is used.
+	Server string
+
+	mu         sync.Mutex
+	namespaces map[string]*namespace
+	subs       map[*subscription]bool
+}
+
+type namespace struct {
+	name    string
+	classes map[string]*class
+}
+
+type class struct {
+	Class
+	namespace  *namespace
+	superclass *class
+	props      map[string]Property
+	methods    map[string]method
+	in, out    map[string]*class // parameters of the methods
+	instances  []map[string]interface{}
+}
+
+// NewRepository returns an empty repository. DefaultNamespace always exists.
+func NewRepository() *Repository {
+	r := &Repository{
+		namespaces: make(map[string]*namespace),
+		subs:       make(map[*subscription]bool),
+	}
+	r.namespace(DefaultNamespace, true)
+	return r
+}
+
+// namespace returns the namespace called name, creating it if create is set.
+// It returns nil if the namespace doesn't exist. r.mu must be held.
+func (r *Repository) namespace(name string, create bool) *namespace {
+	if name == "" {
+		name = DefaultNamespace
+	}
+	k := strings.ToLower(name)
+	ns, ok := r.namespaces[k]
+	if !ok && create {
+		ns = &namespace{name: name, classes: make(map[string]*class)}
+		ns.addEventClasses()
+		r.namespaces[k] = ns
+	}
+	return ns
+}
+
+// AddClass adds a class definition to the repository, creating its namespace
+// if necessary.
+func (r *Repository) AddClass(c Class) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.Name == "" {
+		return errors.New("wmitest: class has no name")
+	}
+	ns := r.namespace(c.Namespace, true)
+	k := strings.ToLower(c.Name)
+	if _, ok := ns.classes[k]; ok {
+		return fmt.Errorf("wmitest: class %s already exists in %s", c.Name, ns.name)
+	}
+	cl := &class{
+		Class:     c,
+		namespace: ns,
+		props:     make(map[string]Property),
+		methods:   make(map[string]method),
+		in:        make(map[string]*class),
+		out:       make(map[string]*class),
+	}
+	if c.Superclass != "" {
+		super, ok := ns.classes[strings.ToLower(c.Superclass)]
+		if !ok {
+			return fmt.Errorf("wmitest: superclass %s of %s not found in %s", c.Superclass, c.Name, ns.name)
+		}
+		cl.superclass = super
+		for pk, p := range super.props {
+			cl.props[pk] = p
+		}
+		for mk, m := range super.methods {
+			cl.methods[mk] = m
+			cl.in[mk], cl.out[mk] = super.in[mk], super.out[mk]
+		}
+	}
+	for _, p := range c.Properties {
+		if p.Name == "" {
+			return fmt.Errorf("wmitest: class %s has a property without a name", c.Name)
+		}
+		cl.props[strings.ToLower(p.Name)] = p
+	}
+	methods := make(map[string]method, len(c.Methods)+len(c.InstanceMethods))
+	for name, m := range c.Methods {
+		methods[name] = staticMethod(m)
+	}
+	for name, m := range c.InstanceMethods {
+		if _, ok := methods[name]; ok {
+			return fmt.Errorf("wmitest: method %s of class %s is both static and an instance method", name, c.Name)
+		}
+		methods[name] = instanceMethod(name, m)
+	}
+	for name := range c.Parameters {
+		if _, ok := methods[name]; !ok {
+			return fmt.Errorf("wmitest: class %s declares parameters of unknown method %s", c.Name, name)
+		}
+	}
+	for name, m := range methods {
+		mk := strings.ToLower(name)
+		cl.methods[mk] = m
+		params := c.Parameters[name]
+		var in *class
+		if len(params.In) > 0 {
+			in = parametersClass(ns, params.In)
+		}
+		cl.in[mk] = in
+		out := params.Out
+		if !hasProperty(out, "ReturnValue") {
+			out = append(out[:len(out):len(out)], Property{Name: "ReturnValue", Type: wmi.CIMTypeUint32})
+		}
+		cl.out[mk] = parametersClass(ns, out)
+	}
+	ns.classes[k] = cl
+	return nil
+}
+
+// method is the implementation of a Method or InstanceMethod.
+type method func(o *object, params []interface{}) (interface{}, error)
+
+func staticMethod(m Method) method {
+	return func(o *object, params []interface{}) (interface{}, error) {
+		return m(params...)
+	}
+}
+
+func instanceMethod(name string, m InstanceMethod) method {
+	return func(o *object, params []interface{}) (interface{}, error) {
+		if o.isClass() {
+			return nil, fmt.Errorf("wmitest: method %s.%s must be called on an instance", o.class.Name, name)
+		}
+		return m(instanceOf(o.class, o.values), params...)
+	}
+}
+
+// instanceOf returns the normalized values of an instance of cl as an
+// Instance, with embedded objects as Instances as well.
+func instanceOf(cl *class, values map[string]interface{}) Instance {
+	inst := make(Instance, len(values))
+	for k, v := range values {
+		inst[cl.props[k].Name] = instanceValue(v)
+	}
+	return inst
+}
+
+func instanceValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case *embedded:
+		inst := instanceOf(x.class, x.values)
+		inst["__CLASS"] = x.class.Name
+		return inst
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, e := range x {
+			arr[i] = instanceValue(e)
+		}
+		return arr
+	}
+	return v
+}
+
+// parametersClass returns the __PARAMETERS class of the given parameters.
+func parametersClass(ns *namespace, params []Property) *class {
+	cl := &class{
+		Class:     Class{Name: "__PARAMETERS", Properties: params},
+		namespace: ns,
+		props:     make(map[string]Property),
+	}
+	for _, p := range params {
+		cl.props[strings.ToLower(p.Name)] = p
+	}
+	return cl
+}
+
+func hasProperty(props []Property, name string) bool {
+	for _, p := range props {
+		if strings.EqualFold(p.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddInstance adds an instance of the named class. The class name may be
+// prefixed with a namespace and a colon, as in root\StandardCimv2:MSFT_NetAdapter;
+// otherwise DefaultNamespace is used.
+func (r *Repository) AddInstance(className string, inst Instance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ns, name := DefaultNamespace, className
+	if i := strings.LastIndex(className, ":"); i >= 0 {
+		ns, name = className[:i], className[i+1:]
+	}
+	cl, err := r.class(ns, name)
+	if err != nil {
+		return err
+	}
+	values, err := normalizeInstance(cl, inst)
+	if err != nil {
+		return err
+	}
+	cl.instances = append(cl.instances, values)
+	r.instanceEvent("__InstanceCreationEvent", cl, values, nil)
+	return nil
+}
+
+// normalizeInstance checks the values of inst against the properties of cl
+// and returns them normalized, keyed by lower-case property name. r.mu must
+// be held.
+func normalizeInstance(cl *class, inst Instance) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(inst))
+	for pname, v := range inst {
+		if strings.EqualFold(pname, "__CLASS") {
+			continue
+		}
+		p, ok := cl.props[strings.ToLower(pname)]
+		if !ok {
+			return nil, fmt.Errorf("wmitest: class %s has no property %s", cl.Name, pname)
+		}
+		nv, err := normalize(cl.namespace, p.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("wmitest: property %s.%s: %v", cl.Name, p.Name, err)
+		}
+		values[strings.ToLower(p.Name)] = nv
+	}
+	return values, nil
+}
+
+// class returns the class called name in namespace ns. r.mu must be held.
+func (r *Repository) class(ns, name string) (*class, error) {
+	n := r.namespace(ns, false)
+	if n == nil {
+		return nil, fmt.Errorf("wmitest: invalid namespace %s", ns)
+	}
+	cl, ok := n.classes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: invalid class %s", name)
+	}
+	return cl, nil
+}
+
+// server returns the server name of r.
+func (r *Repository) server() string {
+	if r.Server == "" {
+		return "localhost"
+	}
+	return r.Server
+}
+
+// Locator implements wmi.Backend.
+func (r *Repository) Locator() (wmi.Locator, error) {
+	return locator{r}, nil
+}
+
+type locator struct {
+	r *Repository
+}
+
+// ConnectServer connects to the namespace given by the second argument. The
+// server and all other arguments are ignored.
+func (l locator) ConnectServer(connectServerArgs ...interface{}) (wmi.Service, error) {
+	ns := DefaultNamespace
+	if len(connectServerArgs) > 1 && connectServerArgs[1] != nil {
+		s, ok := connectServerArgs[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("wmitest: namespace has type %T, want string", connectServerArgs[1])
+		}
+		if s != "" {
+			ns = s
+		}
+	}
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+	n := l.r.namespace(ns, false)
+	if n == nil {
+		return nil, fmt.Errorf("wmitest: invalid namespace %s", ns)
+	}
+	return &service{r: l.r, ns: n}, nil
+}
+
+func (l locator) Release() {}
+
+type service struct {
+	r  *Repository
+	ns *namespace
+}
+
+func (s *service) ExecQuery(query string) (wmi.ObjectSet, error) {
+	switch stmt, _ := wql.Parse(query); stmt := stmt.(type) {
+	case *wql.AssociatorsStatement:
+		s.r.mu.Lock()
+		defer s.r.mu.Unlock()
+		return s.associators(stmt)
+	case *wql.ReferencesStatement:
+		s.r.mu.Lock()
+		defer s.r.mu.Unlock()
+		return s.references(stmt)
+	}
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+
+	cl, ok := s.ns.classes[strings.ToLower(q.class)]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: invalid class %s", q.class)
+	}
+	for _, p := range q.props {
+		if _, ok := cl.props[strings.ToLower(p)]; !ok && !isSystemProperty(p) {
+			return nil, fmt.Errorf("wmitest: invalid query %q: class %s has no property %s", query, cl.Name, p)
+		}
+	}
+	var selected map[string]bool
+	if q.props != nil {
+		selected = make(map[string]bool, len(q.props))
+		for _, p := range q.props {
+			selected[strings.ToLower(p)] = true
+		}
+	}
+
+	set := &objectSet{}
+	for _, c := range s.subclasses(cl) {
+		for _, values := range c.instances {
+			o := &object{r: s.r, server: s.r.server(), class: c, values: values}
+			if q.where != nil {
+				ok, err := eval(q.where, o)
+				if err != nil {
+					return nil, fmt.Errorf("wmitest: invalid query %q: %v", query, err)
+				}
+				if !ok {
+					continue
+				}
+			}
+			// The WHERE clause sees all properties, the result only the
+			// selected ones.
+			o.selected = selected
+			set.objects = append(set.objects, o)
+		}
+	}
+	return set, nil
+}
+
+// ExecQuerySemisync runs the query like ExecQuery, since the repository has
+// all results at hand.
+func (s *service) ExecQuerySemisync(query string) (wmi.ObjectSet, error) {
+	return s.ExecQuery(query)
+}
+
+// subclasses returns cl and all classes deriving from it, in a stable order.
+// s.r.mu must be held.
+func (s *service) subclasses(cl *class) []*class {
+	var names []string
+	for k := range s.ns.classes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	classes := []*class{cl}
+	for _, k := range names {
+		c := s.ns.classes[k]
+		if c == cl {
+			continue
+		}
+		for super := c.superclass; super != nil; super = super.superclass {
+			if super == cl {
+				classes = append(classes, c)
+				break
+			}
+		}
+	}
+	return classes
+}
+
+// Get returns the class with the given name, or the instance with the given
+// path, such as Win32_Service.Name="Spooler". Paths may be prefixed with a
+// server and namespace, as __PATH is.
+func (s *service) Get(path string) (wmi.Object, error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+
+	cl, relPath, ok := s.lookup(path)
+	if !ok {
+		return nil, notFoundError(path)
+	}
+	if strings.IndexAny(relPath, ".=") < 0 {
+		return &object{r: s.r, server: s.r.server(), class: cl}, nil
+	}
+	for _, c := range s.subclasses(cl) {
+		if i := c.find(relPath); i >= 0 {
+			return &object{r: s.r, server: s.r.server(), class: c, values: copyValues(c.instances[i]), owned: true}, nil
+		}
+	}
+	return nil, notFoundError(path)
+}
+
+// Delete deletes the instance with the given path, as accepted by Get.
+// Classes can't be deleted.
+func (s *service) Delete(path string) error {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+
+	cl, relPath, ok := s.lookup(path)
+	if !ok {
+		return notFoundError(path)
+	}
+	if strings.IndexAny(relPath, ".=") < 0 {
+		return fmt.Errorf("wmitest: cannot delete class %s", cl.Name)
+	}
+	for _, c := range s.subclasses(cl) {
+		if i := c.find(relPath); i >= 0 {
+			values := c.instances[i]
+			// Copy the instances, which running queries may still use.
+			instances := make([]map[string]interface{}, 0, len(c.instances)-1)
+			instances = append(instances, c.instances[:i]...)
+			c.instances = append(instances, c.instances[i+1:]...)
+			s.r.instanceEvent("__InstanceDeletionEvent", c, values, nil)
+			return nil
+		}
+	}
+	return notFoundError(path)
+}
+
+// A notFoundError is the error of Get and Delete for an object that doesn't
+// exist. It matches wmi.ErrNotFound.
+type notFoundError string
+
+func (e notFoundError) Error() string        { return "wmitest: not found: " + string(e) }
+func (e notFoundError) Is(target error) bool { return target == wmi.ErrNotFound }
+
+// lookup returns the class named by path and the path relative to the
+// namespace, with its keys in the order of __RELPATH. The path must be in
+// the namespace of s. r.mu must be held.
+func (s *service) lookup(path string) (*class, string, bool) {
+	p, err := wmi.ParsePath(path)
+	if err != nil {
+		return nil, "", false
+	}
+	ns := strings.Replace(p.Namespace, "/", `\`, -1)
+	if ns != "" && !strings.EqualFold(ns, s.ns.name) {
+		return nil, "", false
+	}
+	cl, ok := s.ns.classes[strings.ToLower(p.Class)]
+	if !ok {
+		return nil, "", false
+	}
+	if len(p.Keys) == 1 && p.Keys[0].Name == "" {
+		// Class="value" names the only key of the class.
+		keys := cl.keys()
+		if len(keys) != 1 {
+			return nil, "", false
+		}
+		p.Keys[0].Name = keys[0].Name
+	}
+	for i, k := range p.Keys {
+		if prop, ok := cl.props[strings.ToLower(k.Name)]; ok {
+			p.Keys[i].Name = prop.Name
+		}
+	}
+	sort.Slice(p.Keys, func(i, j int) bool { return p.Keys[i].Name < p.Keys[j].Name })
+	return cl, p.RelPath(), true
+}
+
+// find returns the index of the instance of cl with the given relative
+// path, or -1. Class names and string keys are compared case-insensitively.
+// r.mu must be held.
+func (cl *class) find(relPath string) int {
+	for i, values := range cl.instances {
+		o := &object{class: cl, values: values}
+		if strings.EqualFold(o.relPath(), relPath) {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
+
+// SetSecurity accepts and ignores the security settings, which the
+// repository doesn't check.
+func (s *service) SetSecurity(impersonation wmi.ImpersonationLevel, authentication wmi.AuthenticationLevel, privileges []string) error {
+	return nil
+}
+
+func (s *service) Release() {}
+
+type objectSet struct {
+	objects []wmi.Object
+	next    int
+}
+
+func (s *objectSet) Count() (int, error) {
+	return len(s.objects), nil
+}
+
+func (s *objectSet) Next() (wmi.Object, error) {
+	if s.next >= len(s.objects) {
+		return nil, io.EOF
+	}
+	o := s.objects[s.next]
+	s.next++
+	return o, nil
+}
+
+func (s *objectSet) NextTimeout(timeout time.Duration) (wmi.Object, error) {
+	return s.Next()
+}
+
+func (s *objectSet) Release() {}
+
+// object is a class (values is nil) or an instance.
+type object struct {
+	r        *Repository
+	server   string
+	class    *class
+	values   map[string]interface{}
+	selected map[string]bool
+	embedded bool // an embedded object, which has no path
+	pathless bool // method parameters and events, which have no path either
+	owned    bool // values is a copy that SetProperty may change
+}
+
+func (o *object) isClass() bool {
+	return o.values == nil
+}
+
+func (o *object) GetProperty(name string) (interface{}, error) {
+	t, v, err := o.property(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.variant(t, v), nil
+}
+
+// Properties lists the properties of the class in alphabetical order, like
+// WMI. The objects of a query only have the selected and key properties.
+func (o *object) Properties() ([]wmi.Property, error) {
+	var props []wmi.Property
+	for k, p := range o.class.props {
+		if o.selected != nil && !o.selected[k] && !p.Key {
+			continue
+		}
+		props = append(props, wmi.Property{Name: p.Name, Type: p.Type})
+	}
+	sort.Slice(props, func(i, j int) bool {
+		return strings.ToLower(props[i].Name) < strings.ToLower(props[j].Name)
+	})
+	return props, nil
+}
+
+// property returns the CIM type and the normalized value of the named
+// property.
+func (o *object) property(name string) (wmi.CIMType, interface{}, error) {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		// A property of an embedded object, as in TargetInstance.Name.
+		_, v, err := o.property(name[:i])
+		if err != nil || v == nil {
+			return 0, nil, err
+		}
+		e, ok := v.(*embedded)
+		if !ok {
+			return 0, nil, fmt.Errorf("wmitest: %s.%s is not an object", o.class.Name, name[:i])
+		}
+		return e.object(o).property(name[i+1:])
+	}
+	if isSystemProperty(name) {
+		v, err := o.systemProperty(name)
+		return wmi.CIMTypeString, v, err
+	}
+	k := strings.ToLower(name)
+	p, ok := o.class.props[k]
+	if !ok {
+		return 0, nil, fmt.Errorf("wmitest: not found: %s.%s", o.class.Name, name)
+	}
+	if o.selected != nil && !o.selected[k] && !p.Key {
+		return p.Type, nil, nil
+	}
+	return p.Type, o.values[k], nil
+}
+
+func isSystemProperty(name string) bool {
+	return strings.HasPrefix(name, "__")
+}
+
+func (o *object) systemProperty(name string) (interface{}, error) {
+	switch k := strings.ToUpper(name); k {
+	case "__CLASS":
+		return o.class.Name, nil
+	case "__SUPERCLASS":
+		if o.class.superclass == nil {
+			return nil, nil
+		}
+		return o.class.superclass.Name, nil
+	case "__NAMESPACE", "__SERVER", "__RELPATH", "__PATH":
+		if o.embedded || o.pathless {
+			// Embedded objects don't live in a namespace.
+			return nil, nil
+		}
+		switch k {
+		case "__NAMESPACE":
+			return o.class.namespace.name, nil
+		case "__SERVER":
+			return o.server, nil
+		case "__RELPATH":
+			return o.relPath(), nil
+		}
+		return o.path(), nil
+	}
+	return nil, fmt.Errorf("wmitest: not found: %s.%s", o.class.Name, name)
+}
+
+// path returns the full object path of o.
+func (o *object) path() string {
+	return `\\` + o.server + `\` + o.class.namespace.name + ":" + o.relPath()
+}
+
+// keys returns the key properties of cl in alphabetical order.
+func (cl *class) keys() []Property {
+	var keys []Property
+	for _, p := range cl.props {
+		if p.Key {
+			keys = append(keys, p)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// relPath returns the relative object path of o.
+func (o *object) relPath() string {
+	if o.isClass() {
+		return o.class.Name
+	}
+	keys := o.class.keys()
+	if len(keys) == 0 {
+		return o.class.Name + "=@"
+	}
+	var b strings.Builder
+	b.WriteString(o.class.Name)
+	for i, p := range keys {
+		if i == 0 {
+			b.WriteByte('.')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(p.Name)
+		b.WriteByte('=')
+		switch v := o.values[strings.ToLower(p.Name)].(type) {
+		case string:
+			b.WriteString(quotePathValue(v))
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	return b.String()
+}
+
+func (o *object) CallMethod(name string, params ...interface{}) (interface{}, error) {
+	m, ok := o.class.methods[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: class %s has no method %s", o.class.Name, name)
+	}
+	return m(o, params)
+}
+
+func (o *object) InParameters(method string) (wmi.Object, error) {
+	mk := strings.ToLower(method)
+	if _, ok := o.class.methods[mk]; !ok {
+		return nil, fmt.Errorf("wmitest: class %s has no method %s", o.class.Name, method)
+	}
+	in := o.class.in[mk]
+	if in == nil {
+		return nil, nil
+	}
+	return &object{r: o.r, server: o.server, class: in, values: make(map[string]interface{}), pathless: true, owned: true}, nil
+}
+
+// ExecMethod calls the method with the values of in, as documented on
+// Method, and returns its out-parameters.
+func (o *object) ExecMethod(method string, in wmi.Object) (wmi.Object, error) {
+	mk := strings.ToLower(method)
+	m, ok := o.class.methods[mk]
+	if !ok {
+		return nil, fmt.Errorf("wmitest: class %s has no method %s", o.class.Name, method)
+	}
+	var args []interface{}
+	if inClass := o.class.in[mk]; inClass != nil {
+		var values map[string]interface{}
+		if in != nil {
+			inObj, ok := in.(*object)
+			if !ok || inObj.class != inClass {
+				return nil, fmt.Errorf("wmitest: invalid in-parameters for %s.%s", o.class.Name, method)
+			}
+			values = inObj.values
+		}
+		for _, p := range inClass.Properties {
+			args = append(args, values[strings.ToLower(p.Name)])
+		}
+	}
+	result, err := m(o, args)
+	if err != nil {
+		return nil, err
+	}
+
+	out := o.class.out[mk]
+	inst, ok := result.(Instance)
+	if !ok {
+		if x, isMap := result.(map[string]interface{}); isMap {
+			inst = x
+		} else {
+			inst = Instance{"ReturnValue": result}
+		}
+	}
+	o.r.mu.Lock()
+	values, err := normalizeInstance(out, inst)
+	o.r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &object{r: o.r, server: o.server, class: out, values: values, pathless: true}, nil
+}
+
+func (o *object) SetProperty(name string, value interface{}) error {
+	if o.isClass() || o.embedded {
+		return fmt.Errorf("wmitest: cannot set %s.%s: not an instance", o.class.Name, name)
+	}
+	if isSystemProperty(name) {
+		return fmt.Errorf("wmitest: cannot set %s.%s: read-only system property", o.class.Name, name)
+	}
+	k := strings.ToLower(name)
+	p, ok := o.class.props[k]
+	if !ok {
+		return fmt.Errorf("wmitest: not found: %s.%s", o.class.Name, name)
+	}
+	v, err := fromVariant(p.Type, value)
+	if err == nil {
+		o.r.mu.Lock()
+		v, err = normalize(o.class.namespace, p.Type, v)
+		o.r.mu.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("wmitest: property %s.%s: %v", o.class.Name, p.Name, err)
+	}
+	if !o.owned {
+		o.values = copyValues(o.values)
+		o.owned = true
+	}
+	o.values[k] = v
+	return nil
+}
+
+// fromVariant undoes the conversions of variant for a value of type t, so
+// that normalize accepts it.
+func fromVariant(t wmi.CIMType, v interface{}) (interface{}, error) {
+	if arr, ok := v.([]interface{}); ok && t.IsArray() {
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			ev, err := fromVariant(t.Elem(), e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = ev
+		}
+		return out, nil
+	}
+	switch t {
+	case wmi.CIMTypeUint16, wmi.CIMTypeChar16:
+		switch x := v.(type) {
+		case int16:
+			return uint16(x), nil
+		case int32:
+			if x < 0 {
+				return nil, fmt.Errorf("value %d overflows %s", x, t)
+			}
+		}
+	case wmi.CIMTypeUint32:
+		if x, ok := v.(int32); ok {
+			return uint32(x), nil
+		}
+	case wmi.CIMTypeSint64:
+		if x, ok := v.(string); ok {
+			return strconv.ParseInt(x, 10, 64)
+		}
+	case wmi.CIMTypeUint64:
+		if x, ok := v.(string); ok {
+			return strconv.ParseUint(x, 10, 64)
+		}
+	case wmi.CIMTypeObject:
+		return nil, errors.New("embedded objects can't be set")
+	}
+	return v, nil
+}
+
+func (o *object) SpawnInstance() (wmi.Object, error) {
+	if !o.isClass() {
+		return nil, fmt.Errorf("wmitest: %s is not a class", o.relPath())
+	}
+	return &object{r: o.r, server: o.server, class: o.class, values: make(map[string]interface{}), owned: true}, nil
+}
+
+func (o *object) RelPath() (string, error) {
+	if !o.isClass() && len(o.missingKeys()) > 0 {
+		return "", nil
+	}
+	return o.relPath(), nil
+}
+
+// missingKeys returns the names of the key properties of o that are NULL.
+func (o *object) missingKeys() []string {
+	var missing []string
+	for k, p := range o.class.props {
+		if p.Key && o.values[k] == nil {
+			missing = append(missing, p.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Put stores the instance in the repository. Flags are those of
+// wbemChangeFlagEnum: 0 creates or updates, 1 only updates and 2 only
+// creates.
+func (o *object) Put(flags int) (string, error) {
+	if o.isClass() || o.embedded || o.pathless {
+		return "", fmt.Errorf("wmitest: cannot put %s: not an instance", o.class.Name)
+	}
+	if missing := o.missingKeys(); len(missing) > 0 {
+		return "", fmt.Errorf("wmitest: cannot put %s: key property %s is NULL", o.class.Name, missing[0])
+	}
+	o.r.mu.Lock()
+	defer o.r.mu.Unlock()
+
+	i := o.class.find(o.relPath())
+	switch {
+	case i >= 0 && flags&2 != 0:
+		return "", fmt.Errorf("wmitest: %s already exists", o.relPath())
+	case i < 0 && flags&1 != 0:
+		return "", fmt.Errorf("wmitest: not found: %s", o.relPath())
+	case i >= 0:
+		previous := o.class.instances[i]
+		o.class.instances[i] = copyValues(o.values)
+		o.r.instanceEvent("__InstanceModificationEvent", o.class, o.class.instances[i], previous)
+	default:
+		values := copyValues(o.values)
+		o.class.instances = append(o.class.instances, values)
+		o.r.instanceEvent("__InstanceCreationEvent", o.class, values, nil)
+	}
+	return o.path(), nil
+}
+
+func (o *object) Release() {}
+
+// normalize checks that v is a valid value for a property of type t and
+// converts it to the representation used for instance values: int64 for
+// signed integers, uint64 for unsigned integers and char16, float64 for
+// reals, bool, string, *embedded for objects, and []interface{} for arrays.
+// Embedded objects are looked up in ns. r.mu must be held.
+func normalize(ns *namespace, t wmi.CIMType, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if t.IsArray() {
+		if rv.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("value of type %T is not an array", v)
+		}
+		arr := make([]interface{}, rv.Len())
+		for i := range arr {
+			e, err := normalize(ns, t.Elem(), rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = e
+		}
+		return arr, nil
+	}
+
+	switch t {
+	case wmi.CIMTypeSint8, wmi.CIMTypeSint16, wmi.CIMTypeSint32, wmi.CIMTypeSint64:
+		bits := cimTypeBits(t)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := rv.Int()
+			if i < -1<<(bits-1) || i > 1<<(bits-1)-1 {
+				return nil, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			return i, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u := rv.Uint()
+			if u > 1<<(bits-1)-1 {
+				return nil, fmt.Errorf("value %d overflows %s", u, t)
+			}
+			return int64(u), nil
+		}
+	case wmi.CIMTypeUint8, wmi.CIMTypeUint16, wmi.CIMTypeUint32, wmi.CIMTypeUint64, wmi.CIMTypeChar16:
+		bits := cimTypeBits(t)
+		var u uint64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := rv.Int()
+			if i < 0 {
+				return nil, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			u = uint64(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u = rv.Uint()
+		default:
+			return nil, fmt.Errorf("value of type %T is not a %s", v, t)
+		}
+		if bits < 64 && u > 1<<bits-1 {
+			return nil, fmt.Errorf("value %d overflows %s", u, t)
+		}
+		return u, nil
+	case wmi.CIMTypeReal32, wmi.CIMTypeReal64:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		}
+	case wmi.CIMTypeBoolean:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case wmi.CIMTypeString, wmi.CIMTypeReference:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case wmi.CIMTypeDatetime:
+		switch x := v.(type) {
+		case time.Time:
+			return cimdatetime.FormatTime(x), nil
+		case time.Duration:
+			return cimdatetime.FormatInterval(x)
+		}
+		if rv.Kind() == reflect.String {
+			if _, err := cimdatetime.Parse(rv.String()); err != nil {
+				return nil, err
+			}
+			return rv.String(), nil
+		}
+	case wmi.CIMTypeObject:
+		var inst Instance
+		switch x := v.(type) {
+		case Instance:
+			inst = x
+		case map[string]interface{}:
+			inst = x
+		default:
+			return nil, fmt.Errorf("value of type %T is not an Instance", v)
+		}
+		var name string
+		for k, cv := range inst {
+			if strings.EqualFold(k, "__CLASS") {
+				name, _ = cv.(string)
+			}
+		}
+		if name == "" {
+			return nil, errors.New("embedded object has no __CLASS")
+		}
+		cl, ok := ns.classes[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("class %s of embedded object not found in %s", name, ns.name)
+		}
+		values, err := normalizeInstance(cl, inst)
+		if err != nil {
+			return nil, err
+		}
+		return &embedded{class: cl, values: values}, nil
+	default:
+		return nil, fmt.Errorf("unsupported CIM type %s", t)
+	}
+	return nil, fmt.Errorf("value of type %T is not a %s", v, t)
+}
+
+// cimTypeBits returns the size in bits of an integer CIM type.
+func cimTypeBits(t wmi.CIMType) uint {
+	switch t {
+	case wmi.CIMTypeSint8, wmi.CIMTypeUint8:
+		return 8
+	case wmi.CIMTypeSint16, wmi.CIMTypeUint16, wmi.CIMTypeChar16:
+		return 16
+	case wmi.CIMTypeSint32, wmi.CIMTypeUint32:
+		return 32
+	}
+	return 64
+}
+
+// embedded is the normalized value of an embedded object.
+type embedded struct {
+	class  *class
+	values map[string]interface{}
+}
+
+// object returns the embedded object as a property of parent.
+func (e *embedded) object(parent *object) *object {
+	return &object{r: parent.r, server: parent.server, class: e.class, values: e.values, embedded: true}
+}
+
+// variant converts a normalized value of type t to the Go value go-ole
+// returns for the VARIANT the WMI scripting API uses for that type.
+func (o *object) variant(t wmi.CIMType, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if t.IsArray() {
+		arr := v.([]interface{})
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			out[i] = o.variant(t.Elem(), e)
+		}
+		return out
+	}
+	switch t {
+	case wmi.CIMTypeSint8, wmi.CIMTypeSint16:
+		return int16(v.(int64)) // VT_I2
+	case wmi.CIMTypeChar16:
+		return int16(v.(uint64)) // VT_I2
+	case wmi.CIMTypeUint8:
+		return uint8(v.(uint64)) // VT_UI1
+	case wmi.CIMTypeUint16, wmi.CIMTypeUint32:
+		return int32(v.(uint64)) // VT_I4
+	case wmi.CIMTypeSint32:
+		return int32(v.(int64)) // VT_I4
+	case wmi.CIMTypeSint64:
+		return strconv.FormatInt(v.(int64), 10) // VT_BSTR
+	case wmi.CIMTypeUint64:
+		return strconv.FormatUint(v.(uint64), 10) // VT_BSTR
+	case wmi.CIMTypeReal32:
+		return float32(v.(float64)) // VT_R4
+	case wmi.CIMTypeObject:
+		return v.(*embedded).object(o) // VT_DISPATCH
+	}
+	return v
+}
+
+// quotePathValue quotes a string key value of an object path.
+func quotePathValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,1270 @@
+package wmitest_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StackExchange/wmi"
+	"github.com/StackExchange/wmi/wmitest"
+	"github.com/StackExchange/wmi/wql"
+)
+
+type Win32_Process struct {
+	Name           string
+	ProcessId      uint32
+	VirtualSize    uint64
+	Priority       int32
+	CreationDate   time.Time
+	CommandLine    *string
+	ExecutablePath *string
+}
+
+type Win32_Service struct {
+	Name      string
+	State     string
+	StartMode string
+	Started   bool
+}
+
+func newRepository(t *testing.T) *wmitest.Repository {
+	repo := wmitest.NewRepository()
+	classes := []wmitest.Class{
+		{
+			Name: "Win32_Process",
+			Properties: []wmitest.Property{
+				{Name: "Handle", Type: wmi.CIMTypeString, Key: true},
+				{Name: "Name", Type: wmi.CIMTypeString},
+				{Name: "ProcessId", Type: wmi.CIMTypeUint32},
+				{Name: "VirtualSize", Type: wmi.CIMTypeUint64},
+				{Name: "Priority", Type: wmi.CIMTypeUint32},
+				{Name: "CreationDate", Type: wmi.CIMTypeDatetime},
+				{Name: "CommandLine", Type: wmi.CIMTypeString},
+				{Name: "ExecutablePath", Type: wmi.CIMTypeString},
+			},
+		},
+		{
+			Name: "Win32_BaseService",
+			Properties: []wmitest.Property{
+				{Name: "Name", Type: wmi.CIMTypeString, Key: true},
+				{Name: "State", Type: wmi.CIMTypeString},
+				{Name: "StartMode", Type: wmi.CIMTypeString},
+				{Name: "Started", Type: wmi.CIMTypeBoolean},
+			},
+		},
+		{
+			Name:       "Win32_Service",
+			Superclass: "Win32_BaseService",
+		},
+		{
+			Namespace: `root\StandardCimv2`,
+			Name:      "MSFT_NetAdapter",
+			Properties: []wmitest.Property{
+				{Name: "Name", Type: wmi.CIMTypeString},
+				{Name: "InterfaceIndex", Type: wmi.CIMTypeUint32, Key: true},
+				{Name: "IPAddresses", Type: wmi.CIMTypeString | wmi.CIMTypeArray},
+			},
+		},
+	}
+	for _, c := range classes {
+		if err := repo.AddClass(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Date(2021, 9, 17, 8, 30, 0, 123456000, time.FixedZone("", 2*60*60))
+	instances := []struct {
+		class string
+		inst  wmitest.Instance
+	}{
+		{"Win32_Process", wmitest.Instance{"Handle": "4", "Name": "System", "ProcessId": 4, "VirtualSize": uint64(4 << 40), "Priority": 8, "CreationDate": created}},
+		{"Win32_Process", wmitest.Instance{"Handle": "672", "Name": "lsass.exe", "ProcessId": 672, "Priority": 9, "CommandLine": `C:\Windows\system32\lsass.exe`}},
+		{"Win32_Process", wmitest.Instance{"Handle": "1044", "Name": "svchost.exe", "ProcessId": 1044, "Priority": 8, "CommandLine": nil}},
+		{"Win32_Service", wmitest.Instance{"Name": "Spooler", "State": "Running", "StartMode": "Auto", "Started": true}},
+		{"Win32_Service", wmitest.Instance{"Name": "sqlwriter", "State": "Stopped", "StartMode": "Manual", "Started": false}},
+		{"Win32_Service", wmitest.Instance{"Name": "SQLAgent", "State": "Running", "StartMode": "Manual", "Started": true}},
+		{`root\StandardCimv2:MSFT_NetAdapter`, wmitest.Instance{"Name": "Ethernet", "InterfaceIndex": 3, "IPAddresses": []string{"10.0.0.2", "fe80::1"}}},
+	}
+	for _, i := range instances {
+		if err := repo.AddInstance(i.class, i.inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestClientQuery(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []Win32_Process
+	if err := c.Query(wmi.CreateQuery(&dst, ""), &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 3 {
+		t.Fatalf("got %d processes, want 3", len(dst))
+	}
+	p := dst[0]
+	if p.Name != "System" || p.ProcessId != 4 || p.VirtualSize != 4<<40 || p.Priority != 8 {
+		t.Errorf("bad process: %+v", p)
+	}
+	if want := time.Date(2021, 9, 17, 6, 30, 0, 123456000, time.UTC); !p.CreationDate.Equal(want) {
+		t.Errorf("CreationDate = %v, want %v", p.CreationDate, want)
+	}
+	if p.CommandLine == nil || *p.CommandLine != "" {
+		t.Errorf("NULL CommandLine = %v, want pointer to empty string", p.CommandLine)
+	}
+	if l := dst[1].CommandLine; l == nil || *l != `C:\Windows\system32\lsass.exe` {
+		t.Errorf("CommandLine = %v", l)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	tests := []struct {
+		where string
+		want  []string
+	}{
+		{"", []string{"System", "lsass.exe", "svchost.exe"}},
+		{"WHERE Name = 'LSASS.EXE'", []string{"lsass.exe"}},
+		{`WHERE Name = "svchost.exe"`, []string{"svchost.exe"}},
+		{"WHERE ProcessId > 100", []string{"lsass.exe", "svchost.exe"}},
+		{"WHERE 100 < ProcessId AND Priority = 8", []string{"svchost.exe"}},
+		{"WHERE ProcessId = 4 OR Name LIKE 'svc%'", []string{"System", "svchost.exe"}},
+		{"WHERE NOT (Name LIKE '%.exe')", []string{"System"}},
+		{"WHERE Name NOT LIKE '[l-s]____.exe'", []string{"System", "svchost.exe"}},
+		{"WHERE CommandLine IS NULL", []string{"System", "svchost.exe"}},
+		{"WHERE CommandLine IS NOT NULL", []string{"lsass.exe"}},
+		{"WHERE CommandLine = 'C:\\\\Windows\\\\system32\\\\lsass.exe'", []string{"lsass.exe"}},
+		{"WHERE VirtualSize >= 4398046511104", []string{"System"}},
+		{"WHERE Handle <> '4'", []string{"lsass.exe", "svchost.exe"}},
+		{"WHERE CreationDate < '20220101000000.000000+000'", []string{"System"}},
+	}
+	for _, test := range tests {
+		var dst []Win32_Process
+		if err := c.Query(wmi.CreateQuery(&dst, test.where), &dst); err != nil {
+			t.Errorf("%s: %v", test.where, err)
+			continue
+		}
+		var got []string
+		for _, p := range dst {
+			got = append(got, p.Name)
+		}
+		if !equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.where, got, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []Win32_Service
+	q := wql.Select(&dst).From("Win32_BaseService").Where(wql.Eq("State", "Running")).And(wql.Like("Name", "sql%"))
+	if err := c.Run(q); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0].Name != "SQLAgent" {
+		t.Errorf("got %+v", dst)
+	}
+
+	// Values are quoted, so they can't change the query.
+	q = wql.Select(&dst).Where(wql.Eq("Name", "x' OR Name LIKE '%"))
+	if err := c.Run(q); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 0 {
+		t.Errorf("got %+v, want no services", dst)
+	}
+
+	q = wql.Select(&dst).Where(wql.Eq("Name", struct{}{}))
+	if err := c.Run(q); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+}
+
+func TestQueryArgs(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []Win32_Process
+	q := wmi.CreateQuery(&dst, "WHERE Name = ? OR ProcessId = @pid")
+	if err := c.QueryArgs(q, &dst, "lsass.exe", wql.Named("pid", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 || dst[0].Name != "System" || dst[1].Name != "lsass.exe" {
+		t.Errorf("got %+v", dst)
+	}
+
+	q = wmi.CreateQuery(&dst, "WHERE Name = ?")
+	if err := c.QueryArgs(q, &dst, "x' OR Name LIKE '%"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 0 {
+		t.Errorf("got %+v, want no processes", dst)
+	}
+
+	if err := c.QueryArgs(q, &dst); err == nil {
+		t.Error("expected error for missing argument")
+	}
+}
+
+func TestInvalidQuery(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	for _, q := range []string{
+		"SELECT Name FROM Win32_Nothing",
+		"SELECT Nothing FROM Win32_Process",
+		"SELECT Name FROM Win32_Process WHERE Nothing = 1",
+		"SELECT Name FROM Win32_Process WHERE Name = 'unterminated",
+		"SELECT Name Win32_Process",
+		"SELECT Name FROM Win32_Process WHERE Name = Handle",
+		"SELECT Name FROM Win32_Process WHERE Name ISA 'Win32_Process'",
+		"SELECT * FROM __InstanceCreationEvent WITHIN 5 WHERE TargetInstance ISA 'Win32_Process'",
+		`ASSOCIATORS OF {Win32_Process.Handle="4"} WHERE SchemaOnly`,
+		`REFERENCES OF {Win32_Process.Handle="nothing"}`,
+	} {
+		var dst []Win32_Process
+		if err := c.Query(q, &dst); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestFieldMismatch(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	type s struct {
+		Name      string
+		ProcessId uint32
+		Blah      uint32
+	}
+	var dst []s
+	err := c.Query("SELECT Name, ProcessId FROM Win32_Process", &dst)
+	if err == nil || err.Error() != `wmi: cannot load field "Blah" into a "uint32": no such struct field` {
+		t.Errorf("got %v, want field mismatch", err)
+	}
+}
+
+func TestSelectedProperties(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []Win32_Process
+	if err := c.Query("SELECT Name FROM Win32_Process WHERE ProcessId = 4", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0].Name != "System" || dst[0].ProcessId != 0 {
+		t.Errorf("got %+v, want only Name to be set", dst)
+	}
+}
+
+func TestSubclasses(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []Win32_Service
+	if err := c.Query("SELECT * FROM Win32_BaseService WHERE Started = TRUE", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 || dst[0].Name != "Spooler" || dst[1].Name != "SQLAgent" {
+		t.Errorf("got %+v", dst)
+	}
+}
+
+func TestSystemProperties(t *testing.T) {
+	repo := newRepository(t)
+	repo.Server = "HOST"
+	c := &wmi.Client{Backend: repo}
+	tests := []struct {
+		where string
+		want  []string
+	}{
+		{`WHERE __RELPATH = 'Win32_Service.Name="Spooler"'`, []string{"Spooler"}},
+		{`WHERE __PATH = '\\\\HOST\\root\\cimv2:Win32_Service.Name="sqlwriter"'`, []string{"sqlwriter"}},
+		{`WHERE __CLASS = 'Win32_Service' AND __SUPERCLASS = 'Win32_BaseService' AND Name LIKE 'SQLA%'`, []string{"SQLAgent"}},
+		{`WHERE __this ISA 'Win32_BaseService' AND Name = 'Spooler'`, []string{"Spooler"}},
+		{`WHERE NOT __this ISA 'Win32_Service'`, nil},
+	}
+	for _, test := range tests {
+		var dst []Win32_Service
+		if err := c.Query("SELECT * FROM Win32_BaseService "+test.where, &dst); err != nil {
+			t.Errorf("%s: %v", test.where, err)
+			continue
+		}
+		var got []string
+		for _, s := range dst {
+			got = append(got, s.Name)
+		}
+		if !equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.where, got, test.want)
+		}
+	}
+}
+
+func TestEmbeddedObjects(t *testing.T) {
+	repo := newRepository(t)
+	err := repo.AddClass(wmitest.Class{
+		Name: "Win32_ProcessStartEvent",
+		Properties: []wmitest.Property{
+			{Name: "TargetInstance", Type: wmi.CIMTypeObject},
+			{Name: "Services", Type: wmi.CIMTypeObject | wmi.CIMTypeArray},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inst := range []wmitest.Instance{
+		{
+			"TargetInstance": wmitest.Instance{"__CLASS": "Win32_Process", "Name": "notepad.exe", "ProcessId": 42},
+			"Services": []wmitest.Instance{
+				{"__CLASS": "Win32_Service", "Name": "Spooler", "State": "Running"},
+			},
+		},
+		{"TargetInstance": wmitest.Instance{"__CLASS": "Win32_Service", "Name": "W32Time"}},
+	} {
+		if err := repo.AddInstance("Win32_ProcessStartEvent", inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.AddInstance("Win32_ProcessStartEvent", wmitest.Instance{"TargetInstance": wmitest.Instance{"__CLASS": "Win32_Nothing"}}); err == nil {
+		t.Error("AddInstance accepted an object of an unknown class")
+	}
+
+	c := &wmi.Client{Backend: repo}
+	var dst []struct {
+		TargetInstance struct {
+			Class string `wmi:"__CLASS"`
+			Path  string `wmi:"__PATH"`
+			Win32_Process
+		}
+		Services []*Win32_Service
+	}
+	if err := c.Query("SELECT * FROM Win32_ProcessStartEvent WHERE TargetInstance ISA 'Win32_Process' AND TargetInstance.Name = 'notepad.exe'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 {
+		t.Fatalf("got %d events, want 1", len(dst))
+	}
+	ti := dst[0].TargetInstance
+	if ti.Class != "Win32_Process" || ti.Path != "" || ti.Name != "notepad.exe" || ti.ProcessId != 42 {
+		t.Errorf("TargetInstance = %+v", ti)
+	}
+	if s := dst[0].Services; len(s) != 1 || s[0].Name != "Spooler" || s[0].State != "Running" {
+		t.Errorf("Services = %+v", s)
+	}
+
+	var services []struct{ TargetInstance Win32_Service }
+	if err := c.Query("SELECT TargetInstance FROM Win32_ProcessStartEvent WHERE TargetInstance ISA 'Win32_BaseService'", &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].TargetInstance.Name != "W32Time" {
+		t.Errorf("got %+v", services)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var records []wmi.Record
+	if err := c.Query("SELECT Name, Priority FROM Win32_Process WHERE ProcessId = 4", &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	r := records[0]
+	if got, want := r.Names(), []string{"Handle", "Name", "Priority"}; !equal(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+	if f, _ := r.Field("Priority"); f.Type != wmi.CIMTypeUint32 || f.Value != uint32(8) {
+		t.Errorf("Priority = %+v", f)
+	}
+
+	var maps []map[string]interface{}
+	if err := c.Query("SELECT * FROM Win32_Process WHERE Name = 'lsass.exe'", &maps); err != nil {
+		t.Fatal(err)
+	}
+	if len(maps) != 1 {
+		t.Fatalf("got %d maps, want 1", len(maps))
+	}
+	m := maps[0]
+	if m["ProcessId"] != uint32(672) || m["CommandLine"] != `C:\Windows\system32\lsass.exe` || m["VirtualSize"] != nil {
+		t.Errorf("got %v", m)
+	}
+	if _, ok := m["CreationDate"]; !ok || len(m) != 8 {
+		t.Errorf("got %d properties, want all 8", len(m))
+	}
+
+	var adapters []wmi.Record
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &adapters, nil, `root\StandardCimv2`); err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 1 || !equal(adapters[0].Get("IPAddresses").([]string), []string{"10.0.0.2", "fe80::1"}) {
+		t.Errorf("got %+v", adapters)
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	repo := wmitest.NewRepository()
+	err := repo.AddClass(wmitest.Class{
+		Name: "Win32_ScheduledJob",
+		Properties: []wmitest.Property{
+			{Name: "JobId", Type: wmi.CIMTypeUint32, Key: true},
+			{Name: "StartTime", Type: wmi.CIMTypeDatetime},
+			{Name: "ElapsedTime", Type: wmi.CIMTypeDatetime},
+			{Name: "Timeout", Type: wmi.CIMTypeDatetime},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.AddInstance("Win32_ScheduledJob", wmitest.Instance{
+		"JobId":       1,
+		"StartTime":   "********123000.000000+000",
+		"ElapsedTime": 36*time.Hour + 1500*time.Microsecond,
+		"Timeout":     "00000000000030.000000:000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddInstance("Win32_ScheduledJob", wmitest.Instance{"JobId": 2, "Timeout": "30s"}); err == nil {
+		t.Error("AddInstance accepted an invalid DATETIME")
+	}
+
+	c := &wmi.Client{Backend: repo}
+	var dst []struct {
+		StartTime   string
+		ElapsedTime time.Duration
+		Timeout     *time.Duration
+	}
+	if err := c.Query("SELECT * FROM Win32_ScheduledJob", &dst); err != nil {
+		t.Fatal(err)
+	}
+	j := dst[0]
+	if j.StartTime != "********123000.000000+000" || j.ElapsedTime != 36*time.Hour+1500*time.Microsecond || j.Timeout == nil || *j.Timeout != 30*time.Second {
+		t.Errorf("got %+v", j)
+	}
+
+	var records []wmi.Record
+	if err := c.Query("SELECT * FROM Win32_ScheduledJob", &records); err != nil {
+		t.Fatal(err)
+	}
+	if d := records[0].Get("ElapsedTime"); d != 36*time.Hour+1500*time.Microsecond {
+		t.Errorf("ElapsedTime = %#v", d)
+	}
+
+	var bad []struct{ StartTime time.Duration }
+	if err := c.Query("SELECT StartTime FROM Win32_ScheduledJob", &bad); err == nil {
+		t.Error("decoded an absolute time into a time.Duration")
+	}
+}
+
+func TestPut(t *testing.T) {
+	repo := newRepository(t)
+	c := &wmi.Client{Backend: repo}
+	var dst []Win32_Service
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	spooler := dst[0]
+	spooler.StartMode = "Disabled"
+	if err := c.Put(&spooler, wmi.PutOptions{Mode: wmi.UpdateOnly}); err != nil {
+		t.Fatal(err)
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0] != spooler {
+		t.Errorf("got %+v, want %+v", dst, spooler)
+	}
+
+	// Only the fields of the struct are written.
+	type startMode struct {
+		_         struct{} `wmi:"Win32_Service"`
+		Name      string
+		StartMode string
+	}
+	if err := c.Put(startMode{Name: "sqlwriter", StartMode: "Auto"}, wmi.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'sqlwriter'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Win32_Service{Name: "sqlwriter", State: "Stopped", StartMode: "Auto"}); len(dst) != 1 || dst[0] != want {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+
+	// Fields that a narrow query left out are not written back, unless
+	// ZeroFields asks for them.
+	narrow := &wmi.Client{Backend: repo, AllowMissingFields: true}
+	dst = nil
+	if err := narrow.Query("SELECT Name, StartMode FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	dst[0].StartMode = "Manual"
+	if err := c.Put(dst[0], wmi.PutOptions{Mode: wmi.UpdateOnly}); err != nil {
+		t.Fatal(err)
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Win32_Service{Name: "Spooler", State: "Running", StartMode: "Manual", Started: true}); len(dst) != 1 || dst[0] != want {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+	if err := c.Put(Win32_Service{Name: "Spooler", StartMode: "Manual"}, wmi.PutOptions{Mode: wmi.UpdateOnly, ZeroFields: true}); err != nil {
+		t.Fatal(err)
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Win32_Service{Name: "Spooler", StartMode: "Manual"}); len(dst) != 1 || dst[0] != want {
+		t.Errorf("ZeroFields: got %+v, want %+v", dst, want)
+	}
+
+	svc := Win32_Service{Name: "W32Time", State: "Running", StartMode: "Manual", Started: true}
+	if err := c.Put(svc, wmi.PutOptions{Mode: wmi.UpdateOnly}); err == nil {
+		t.Error("UpdateOnly created an instance")
+	}
+	if err := c.Put(svc, wmi.PutOptions{Mode: wmi.CreateOnly}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(svc, wmi.PutOptions{Mode: wmi.CreateOnly}); err == nil {
+		t.Error("CreateOnly overwrote an instance")
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'W32Time'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0] != svc {
+		t.Errorf("got %+v, want %+v", dst, svc)
+	}
+
+	proc := Win32_Process{Name: "x", ProcessId: 1}
+	if err := c.Put(proc, wmi.PutOptions{Mode: wmi.CreateOnly}); err == nil {
+		t.Error("created an instance without its key")
+	}
+	type badPriority struct {
+		Handle   string
+		Priority int64
+	}
+	err := c.Put(badPriority{Handle: "9", Priority: -1}, wmi.PutOptions{Class: "Win32_Process"})
+	if err == nil || !strings.Contains(err.Error(), "overflows uint32") {
+		t.Errorf("got %v, want overflow error", err)
+	}
+	if err := c.Put(struct{ Nothing string }{}, wmi.PutOptions{Class: "Win32_Process"}); err == nil {
+		t.Error("wrote an unknown property")
+	}
+	if err := c.Put(3, wmi.PutOptions{}); err != wmi.ErrInvalidEntityType {
+		t.Errorf("got %v, want ErrInvalidEntityType", err)
+	}
+}
+
+// deniedBackend is a Repository whose instances can't be read with Get.
+type deniedBackend struct{ *wmitest.Repository }
+
+func (b deniedBackend) Locator() (wmi.Locator, error) {
+	l, err := b.Repository.Locator()
+	return deniedLocator{l}, err
+}
+
+type deniedLocator struct{ wmi.Locator }
+
+func (l deniedLocator) ConnectServer(connectServerArgs ...interface{}) (wmi.Service, error) {
+	s, err := l.Locator.ConnectServer(connectServerArgs...)
+	return deniedService{s}, err
+}
+
+type deniedService struct{ wmi.Service }
+
+func (s deniedService) Get(path string) (wmi.Object, error) {
+	if strings.ContainsAny(path, ".=") {
+		return nil, errors.New("access denied")
+	}
+	return s.Service.Get(path)
+}
+
+func TestPutGetFails(t *testing.T) {
+	repo := newRepository(t)
+	c := &wmi.Client{Backend: deniedBackend{repo}}
+	err := c.Put(Win32_Service{Name: "Spooler", StartMode: "Disabled"}, wmi.PutOptions{})
+	if err == nil || err.Error() != "access denied" {
+		t.Errorf("got %v, want access denied", err)
+	}
+	var dst []Win32_Service
+	if err := (&wmi.Client{Backend: repo}).Query("SELECT * FROM Win32_Service WHERE Name = 'Spooler'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Win32_Service{Name: "Spooler", State: "Running", StartMode: "Auto", Started: true}); len(dst) != 1 || dst[0] != want {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+func TestCreateDelete(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	svc := Win32_Service{Name: "W32Time", State: "Stopped", StartMode: "Manual"}
+	path, err := c.Create("", svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `\\localhost\root\cimv2:Win32_Service.Name="W32Time"`; !strings.EqualFold(path, want) {
+		t.Errorf("got path %q, want %q", path, want)
+	}
+	if _, err := c.Create("Win32_Service", svc); err == nil {
+		t.Error("Create overwrote an instance")
+	}
+	var dst []Win32_Service
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'W32Time'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0] != svc {
+		t.Errorf("got %+v, want %+v", dst, svc)
+	}
+
+	if err := c.Delete(path); err != nil {
+		t.Fatal(err)
+	}
+	dst = nil
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'W32Time'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 0 {
+		t.Errorf("got %+v after Delete", dst)
+	}
+	if err := c.Delete(path); err == nil {
+		t.Error("deleted a missing instance")
+	}
+	if err := c.Delete(`Win32_Service.Name="Spooler"`); err != nil {
+		t.Error(err)
+	}
+	if err := c.Delete("Win32_Service"); err == nil {
+		t.Error("deleted a class")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	c := &wmi.Client{Backend: newRepository(t)}
+	var dst []struct {
+		Name           string
+		InterfaceIndex uint32
+		IPAddresses    []string
+	}
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst, nil, `ROOT\StandardCimv2`); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 1 || dst[0].Name != "Ethernet" || dst[0].InterfaceIndex != 3 || !equal(dst[0].IPAddresses, []string{"10.0.0.2", "fe80::1"}) {
+		t.Errorf("got %+v", dst)
+	}
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst); err == nil {
+		t.Error("expected error for class in other namespace")
+	}
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst, nil, `broken\nothing`); err == nil {
+		t.Error("expected error for invalid namespace")
+	}
+
+	opts := wmi.ConnectOptions{Namespace: `root\StandardCimv2`}
+	dst = nil
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst, opts); err != nil || len(dst) != 1 {
+		t.Errorf("ConnectOptions: got %+v, %v", dst, err)
+	}
+	sw, err := wmi.InitializeSWbemServices(c, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	dst = nil
+	if err := sw.Query("SELECT * FROM MSFT_NetAdapter", &dst); err != nil || len(dst) != 1 {
+		t.Errorf("SWbemServices with ConnectOptions: got %+v, %v", dst, err)
+	}
+	if err := c.Query("SELECT * FROM MSFT_NetAdapter", &dst, wmi.ConnectOptions{Password: "secret"}); err == nil {
+		t.Error("expected error for invalid ConnectOptions")
+	}
+}
+
+func TestDefaultClient(t *testing.T) {
+	defer func(b wmi.Backend) { wmi.DefaultClient.Backend = b }(wmi.DefaultClient.Backend)
+	wmi.DefaultClient.Backend = newRepository(t)
+
+	var dst []Win32_Service
+	if err := wmi.Query("SELECT * FROM Win32_Service WHERE State = 'Running'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 {
+		t.Errorf("got %+v", dst)
+	}
+}
+
+func TestSWbemServices(t *testing.T) {
+	s, err := wmi.InitializeSWbemServices(&wmi.Client{Backend: newRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var dst []*Win32_Service
+	if err := s.Query("SELECT * FROM Win32_Service WHERE StartMode = 'Manual' AND Name LIKE 'SQL%'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst) != 2 || dst[0].Name != "sqlwriter" || dst[1].Name != "SQLAgent" {
+		t.Errorf("got %+v", dst)
+	}
+}
+
+func TestCallMethod(t *testing.T) {
+	repo := wmitest.NewRepository()
+	var got []interface{}
+	err := repo.AddClass(wmitest.Class{
+		Name: "Win32_Process",
+		Methods: map[string]wmitest.Method{
+			"Create": func(params ...interface{}) (interface{}, error) {
+				got = params
+				return int32(0), nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &wmi.Client{Backend: repo}
+	ret, err := c.CallMethod(nil, "Win32_Process", "Create", []interface{}{"notepad.exe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != 0 || len(got) != 1 || got[0] != "notepad.exe" {
+		t.Errorf("got %d, params %v", ret, got)
+	}
+	if _, err := c.CallMethod(nil, "Win32_Process", "Terminate", nil); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func TestCallMethodStruct(t *testing.T) {
+	repo := wmitest.NewRepository()
+	var got []interface{}
+	err := repo.AddClass(wmitest.Class{
+		Name: "Win32_Process",
+		Methods: map[string]wmitest.Method{
+			"Create": func(params ...interface{}) (interface{}, error) {
+				got = params
+				return wmitest.Instance{"ProcessId": 4242, "ReturnValue": 0}, nil
+			},
+			"GetAvailableVirtualSize": func(params ...interface{}) (interface{}, error) {
+				return 2, nil
+			},
+		},
+		Parameters: map[string]wmitest.Parameters{
+			"Create": {
+				In: []wmitest.Property{
+					{Name: "CommandLine", Type: wmi.CIMTypeString},
+					{Name: "CurrentDirectory", Type: wmi.CIMTypeString},
+				},
+				Out: []wmitest.Property{
+					{Name: "ProcessId", Type: wmi.CIMTypeUint32},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &wmi.Client{Backend: repo}
+
+	in := struct{ CommandLine string }{"notepad.exe"}
+	var out struct {
+		ProcessId   uint32
+		ReturnValue uint32
+	}
+	if err := c.CallMethodStruct("Win32_Process", "Create", &in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "notepad.exe" || got[1] != nil {
+		t.Errorf("got params %v", got)
+	}
+	if out.ProcessId != 4242 || out.ReturnValue != 0 {
+		t.Errorf("got %+v", out)
+	}
+
+	var ret struct{ ReturnValue uint32 }
+	if err := c.CallMethodStruct("Win32_Process", "GetAvailableVirtualSize", nil, &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret.ReturnValue != 2 {
+		t.Errorf("got %+v", ret)
+	}
+	type noParams struct{ Unused string }
+	if err := c.CallMethodStruct("Win32_Process", "GetAvailableVirtualSize", (*noParams)(nil), &ret); err != nil {
+		t.Errorf("nil pointer in-parameters: %v", err)
+	}
+	if err := c.CallMethodStruct("Win32_Process", "Create", struct{ Nothing string }{}, nil); err == nil {
+		t.Error("expected error for unknown parameter")
+	}
+	if err := c.CallMethodStruct("Win32_Process", "Create", in, &struct{ Handle string }{}); err == nil {
+		t.Error("expected error for unknown out-parameter")
+	}
+	if err := c.CallMethodStruct("Win32_Process", "Terminate", nil, nil); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func TestInstanceMethods(t *testing.T) {
+	repo := wmitest.NewRepository()
+	var stopped []string
+	err := repo.AddClass(wmitest.Class{
+		Name: "Win32_Service",
+		Properties: []wmitest.Property{
+			{Name: "Name", Type: wmi.CIMTypeString, Key: true},
+			{Name: "State", Type: wmi.CIMTypeString},
+		},
+		InstanceMethods: map[string]wmitest.InstanceMethod{
+			"StopService": func(inst wmitest.Instance, params ...interface{}) (interface{}, error) {
+				if inst["State"] != "Running" {
+					return int32(5), nil // Service Cannot Accept Control
+				}
+				stopped = append(stopped, inst["Name"].(string))
+				return int32(0), nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, inst := range []wmitest.Instance{
+		{"Name": "Spooler", "State": "Running"},
+		{"Name": "W32Time", "State": "Running"},
+		{"Name": "sqlwriter", "State": "Stopped"},
+	} {
+		if err := repo.AddInstance("Win32_Service", inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &wmi.Client{Backend: repo}
+
+	var out struct{ ReturnValue uint32 }
+	if err := c.CallMethodStruct(`Win32_Service.Name="Spooler"`, "StopService", nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	type Win32_Service struct {
+		Name  string
+		State string
+	}
+	var dst []Win32_Service
+	if err := c.Query("SELECT * FROM Win32_Service WHERE Name = 'W32Time' OR Name = 'sqlwriter'", &dst); err != nil {
+		t.Fatal(err)
+	}
+	for i := range dst {
+		if err := c.CallMethodStruct(&dst[i], "StopService", nil, &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.ReturnValue != 5 {
+		t.Errorf("got ReturnValue %d for stopped service, want 5", out.ReturnValue)
+	}
+	var withPath []struct {
+		Path string `wmi:"__PATH"`
+	}
+	if err := c.Query("SELECT __PATH FROM Win32_Service WHERE Name = 'W32Time'", &withPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CallMethodStruct(withPath[0], "StopService", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := c.CallMethod(nil, `Win32_Service.Name="Spooler"`, "StopService", nil)
+	if err != nil || ret != 0 {
+		t.Errorf("CallMethod on a path: got %d, %v", ret, err)
+	}
+	if want := []string{"Spooler", "W32Time", "W32Time", "Spooler"}; !equal(stopped, want) {
+		t.Errorf("stopped %v, want %v", stopped, want)
+	}
+
+	if err := c.CallMethodStruct("Win32_Service", "StopService", nil, nil); err == nil {
+		t.Error("expected error for instance method called on the class")
+	}
+	if err := c.CallMethodStruct(Win32_Service{State: "Running"}, "StopService", nil, nil); err == nil {
+		t.Error("expected error for instance without its key")
+	}
+	if err := c.CallMethodStruct(`Win32_Service.Name="Nothing"`, "StopService", nil, nil); err == nil {
+		t.Error("expected error for missing instance")
+	}
+}
+
+// newDiskRepository returns a repository with a disk drive, its partition
+// and its logical disk, and the associations between them.
+func newDiskRepository(t *testing.T) *wmitest.Repository {
+	repo := wmitest.NewRepository()
+	classes := []wmitest.Class{
+		{
+			Name: "Win32_DiskDrive",
+			Properties: []wmitest.Property{
+				{Name: "DeviceID", Type: wmi.CIMTypeString, Key: true},
+				{Name: "Model", Type: wmi.CIMTypeString},
+			},
+		},
+		{
+			Name: "Win32_DiskPartition",
+			Properties: []wmitest.Property{
+				{Name: "DeviceID", Type: wmi.CIMTypeString, Key: true},
+				{Name: "Size", Type: wmi.CIMTypeUint64},
+			},
+		},
+		{
+			Name: "Win32_LogicalDisk",
+			Properties: []wmitest.Property{
+				{Name: "DeviceID", Type: wmi.CIMTypeString, Key: true},
+				{Name: "FileSystem", Type: wmi.CIMTypeString},
+			},
+		},
+		{
+			Name: "Win32_LogicalDiskToPartition",
+			Properties: []wmitest.Property{
+				{Name: "Antecedent", Type: wmi.CIMTypeReference, Key: true},
+				{Name: "Dependent", Type: wmi.CIMTypeReference, Key: true},
+			},
+		},
+		{
+			Name: "Win32_DiskDriveToDiskPartition",
+			Properties: []wmitest.Property{
+				{Name: "Antecedent", Type: wmi.CIMTypeReference, Key: true},
+				{Name: "Dependent", Type: wmi.CIMTypeReference, Key: true},
+			},
+		},
+	}
+	for _, c := range classes {
+		if err := repo.AddClass(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, inst := range []struct {
+		class string
+		inst  wmitest.Instance
+	}{
+		{"Win32_DiskDrive", wmitest.Instance{"DeviceID": `\\.\PHYSICALDRIVE0`, "Model": "Disk"}},
+		{"Win32_DiskPartition", wmitest.Instance{"DeviceID": "Disk #0, Partition #1", "Size": 1 << 30}},
+		{"Win32_LogicalDisk", wmitest.Instance{"DeviceID": "C:", "FileSystem": "NTFS"}},
+		{"Win32_LogicalDiskToPartition", wmitest.Instance{
+			"Antecedent": `\\localhost\root\cimv2:Win32_DiskPartition.DeviceID="Disk #0, Partition #1"`,
+			"Dependent":  `\\localhost\root\cimv2:Win32_LogicalDisk.DeviceID="C:"`,
+		}},
+		{"Win32_DiskDriveToDiskPartition", wmitest.Instance{
+			"Antecedent": `Win32_DiskDrive.DeviceID="\\\\.\\PHYSICALDRIVE0"`,
+			"Dependent":  `Win32_DiskPartition.DeviceID="Disk #0, Partition #1"`,
+		}},
+	} {
+		if err := repo.AddInstance(inst.class, inst.inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestReferences(t *testing.T) {
+	c := &wmi.Client{Backend: newDiskRepository(t)}
+
+	var links []struct {
+		Antecedent wmi.ObjectPath
+		Dependent  *wmi.ObjectPath
+	}
+	if err := c.Query("SELECT * FROM Win32_LogicalDiskToPartition", &links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 {
+		t.Fatalf("got %d links", len(links))
+	}
+	ante, dep := links[0].Antecedent, links[0].Dependent
+	if id, _ := ante.KeyString("DeviceID"); ante.Class != "Win32_DiskPartition" || id != "Disk #0, Partition #1" || ante.Namespace != `root\cimv2` {
+		t.Errorf("got Antecedent %#v", ante)
+	}
+	if dep == nil || dep.Class != "Win32_LogicalDisk" {
+		t.Fatalf("got Dependent %#v", dep)
+	}
+
+	var disk struct {
+		DeviceID   string
+		FileSystem string
+	}
+	if err := c.Resolve(*dep, &disk); err != nil {
+		t.Fatal(err)
+	}
+	if disk.DeviceID != "C:" || disk.FileSystem != "NTFS" {
+		t.Errorf("got %+v", disk)
+	}
+	var part struct {
+		DeviceID string
+		Size     uint64
+	}
+	p, err := wmi.ParsePath(`Win32_DiskPartition='Disk #0, Partition #1'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Resolve(p, &part); err != nil {
+		t.Fatal(err)
+	}
+	if part.Size != 1<<30 {
+		t.Errorf("got %+v", part)
+	}
+	if err := c.Resolve(wmi.ObjectPath{Class: "Win32_LogicalDisk", Keys: []wmi.Key{{Name: "DeviceID", Value: "D:"}}}, &disk); err == nil {
+		t.Error("resolved a missing instance")
+	}
+	if err := c.Resolve(*dep, disk); err != wmi.ErrInvalidEntityType {
+		t.Errorf("got %v, want ErrInvalidEntityType", err)
+	}
+
+	// ObjectPath fields are written as references.
+	type Win32_LogicalDiskToPartition struct {
+		Antecedent wmi.ObjectPath
+		Dependent  wmi.ObjectPath
+	}
+	d := wmi.ObjectPath{Class: "Win32_LogicalDisk", Keys: []wmi.Key{{Name: "DeviceID", Value: "D:"}}}
+	if _, err := c.Create("", Win32_LogicalDiskToPartition{Antecedent: ante, Dependent: d}); err != nil {
+		t.Fatal(err)
+	}
+	links = nil
+	if err := c.Query(`SELECT * FROM Win32_LogicalDiskToPartition WHERE Dependent = 'Win32_LogicalDisk.DeviceID="D:"'`, &links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].Dependent.String() != d.String() {
+		t.Errorf("got %+v", links)
+	}
+}
+
+func TestAssociators(t *testing.T) {
+	c := &wmi.Client{Backend: newDiskRepository(t)}
+	type Win32_DiskPartition struct {
+		DeviceID string
+	}
+	part := Win32_DiskPartition{"Disk #0, Partition #1"}
+
+	var all []wmi.Record
+	if err := c.Associators(part, &all, wmi.AssocOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range all {
+		ids = append(ids, r.Get("DeviceID").(string))
+	}
+	if want := []string{`\\.\PHYSICALDRIVE0`, "C:"}; !equal(ids, want) {
+		t.Errorf("got %q, want %q", ids, want)
+	}
+
+	var disks []struct {
+		DeviceID   string
+		FileSystem string
+	}
+	opts := wmi.AssocOptions{AssocClass: "Win32_LogicalDiskToPartition", ResultRole: "Dependent"}
+	if err := c.Associators(`Win32_DiskPartition.DeviceID="Disk #0, Partition #1"`, &disks, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(disks) != 1 || disks[0].DeviceID != "C:" || disks[0].FileSystem != "NTFS" {
+		t.Errorf("got %+v", disks)
+	}
+	disks = nil
+	if err := c.Associators(part, &disks, wmi.AssocOptions{ResultClass: "Win32_LogicalDisk", Role: "Dependent"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(disks) != 0 {
+		t.Errorf("got %+v for Role Dependent", disks)
+	}
+	var classes []struct {
+		Class string `wmi:"__CLASS"`
+	}
+	if err := c.Associators(part, &classes, wmi.AssocOptions{ClassDefsOnly: true, ResultClass: "Win32_DiskDrive"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 1 || classes[0].Class != "Win32_DiskDrive" {
+		t.Errorf("got %+v", classes)
+	}
+
+	var refs []struct {
+		Class      string `wmi:"__CLASS"`
+		Antecedent wmi.ObjectPath
+		Dependent  wmi.ObjectPath
+	}
+	if err := c.References(part, &refs, wmi.AssocOptions{Role: "Dependent"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Class != "Win32_DiskDriveToDiskPartition" || refs[0].Antecedent.Class != "Win32_DiskDrive" {
+		t.Errorf("got %+v", refs)
+	}
+	refs = nil
+	if err := c.References(part, &refs, wmi.AssocOptions{ResultClass: "Win32_LogicalDiskToPartition", KeysOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].Dependent.Class != "Win32_LogicalDisk" {
+		t.Errorf("got %+v", refs)
+	}
+
+	if err := c.References(part, &refs, wmi.AssocOptions{AssocClass: "Win32_LogicalDiskToPartition"}); err == nil {
+		t.Error("expected error for AssocClass in References")
+	}
+	if err := c.Associators(part, &disks, wmi.AssocOptions{Role: "Dependent} WHERE"}); err == nil {
+		t.Error("expected error for invalid role")
+	}
+	if err := c.Associators(`Win32_DiskPartition.DeviceID="nothing"`, &disks, wmi.AssocOptions{}); err == nil {
+		t.Error("expected error for missing object")
+	}
+}
+
+type processEvent struct {
+	Class            string `wmi:"__CLASS"`
+	TargetInstance   Win32_Process
+	PreviousInstance *Win32_Process
+	TIME_CREATED     uint64
+}
+
+func TestSubscribe(t *testing.T) {
+	repo := newRepository(t)
+	c := &wmi.Client{Backend: repo}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan processEvent)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Subscribe(ctx, "SELECT * FROM __InstanceOperationEvent WITHIN 1 WHERE TargetInstance ISA 'Win32_Process'", ch)
+	}()
+
+	next := func() processEvent {
+		t.Helper()
+		select {
+		case e := <-ch:
+			return e
+		case err := <-errc:
+			t.Fatalf("Subscribe returned %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return processEvent{}
+	}
+
+	// Subscribe returns no sign that it is ready, so create processes until
+	// the first event arrives.
+	var e processEvent
+	for i := 0; ; i++ {
+		handle := strconv.Itoa(1000 + i)
+		if err := repo.AddInstance("Win32_Process", wmitest.Instance{"Handle": handle, "Name": "notepad.exe", "ProcessId": 1000 + i}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case e = <-ch:
+		case err := <-errc:
+			t.Fatalf("Subscribe returned %v", err)
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	if e.Class != "__InstanceCreationEvent" || e.TargetInstance.Name != "notepad.exe" || e.TIME_CREATED == 0 {
+		t.Errorf("got %+v, want creation of notepad.exe", e)
+	}
+
+	// Services don't match the query.
+	if err := repo.AddInstance("Win32_Service", wmitest.Instance{"Name": "W32Time"}); err != nil {
+		t.Fatal(err)
+	}
+	handle := strconv.Itoa(int(e.TargetInstance.ProcessId))
+	p := struct{ Handle, Name string }{handle, "notepad++.exe"}
+	if err := c.Put(p, wmi.PutOptions{Mode: wmi.UpdateOnly, Class: "Win32_Process"}); err != nil {
+		t.Fatal(err)
+	}
+	e = next()
+	if e.Class != "__InstanceModificationEvent" || e.TargetInstance.Name != "notepad++.exe" || e.PreviousInstance == nil || e.PreviousInstance.Name != "notepad.exe" {
+		t.Errorf("got %+v, want modification of notepad.exe", e)
+	}
+	if err := c.Delete(`Win32_Process.Handle="` + handle + `"`); err != nil {
+		t.Fatal(err)
+	}
+	e = next()
+	if e.Class != "__InstanceDeletionEvent" || e.TargetInstance.Name != "notepad++.exe" {
+		t.Errorf("got %+v, want deletion of notepad++.exe", e)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("Subscribe returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe didn't return after cancellation")
+	}
+}
+
+func TestExtrinsicEvents(t *testing.T) {
+	repo := wmitest.NewRepository()
+	if err := repo.AddClass(wmitest.Class{
+		Name:       "Test_Event",
+		Superclass: "__ExtrinsicEvent",
+		Properties: []wmitest.Property{{Name: "Message", Type: wmi.CIMTypeString}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := repo.Locator()
+	svc, err := l.ConnectServer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := svc.ExecNotificationQuery("SELECT * FROM Test_Event WHERE Message LIKE 'hello%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Release()
+	if _, err := events.NextEvent(0); err != wmi.ErrTimedOut {
+		t.Errorf("NextEvent returned %v, want %v", err, wmi.ErrTimedOut)
+	}
+	for _, msg := range []string{"goodbye", "hello, world"} {
+		if err := repo.AddEvent("Test_Event", wmitest.Instance{"Message": msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := events.NextEvent(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := e.GetProperty("Message"); err != nil || msg != "hello, world" {
+		t.Errorf("got message %v, %v", msg, err)
+	}
+	if _, err := events.NextEvent(0); err != wmi.ErrTimedOut {
+		t.Errorf("NextEvent returned %v, want %v", err, wmi.ErrTimedOut)
+	}
+
+	if err := repo.AddEvent("__Event", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := svc.ExecNotificationQuery("SELECT * FROM __InstanceCreationEvent GROUP WITHIN 10"); err == nil {
+		t.Error("expected error for GROUP WITHIN")
+	}
+	if _, err := svc.ExecNotificationQuery("SELECT * FROM __Namespace"); err == nil {
+		t.Error("expected error for a missing class")
+	}
+	if err := repo.AddClass(wmitest.Class{Name: "Test_Thing"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ExecNotificationQuery("SELECT * FROM Test_Thing"); err == nil {
+		t.Error("expected error for a class that isn't an event class")
+	}
+	if err := repo.AddEvent("Test_Thing", nil); err == nil {
+		t.Error("expected error for a class that isn't an event class")
+	}
+}
+
+func TestAddInstanceErrors(t *testing.T) {
+	repo := newRepository(t)
+	for _, inst := range []wmitest.Instance{
+		{"Nothing": 1},
+		{"ProcessId": -1},
+		{"ProcessId": uint64(1) << 32},
+		{"Name": 5},
+		{"CreationDate": 5},
+	} {
+		if err := repo.AddInstance("Win32_Process", inst); err == nil {
+			t.Errorf("%v: expected error", inst)
+		}
+	}
+	if err := repo.AddInstance("Win32_Nothing", nil); err == nil {
+		t.Error("expected error for unknown class")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,357 @@
+package wql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A Node is a node of the syntax tree. String returns the node as WQL text.
+type Node interface {
+	Pos() Pos
+	String() string
+}
+
+// A Statement is a complete query.
+type Statement interface {
+	Node
+	stmtNode()
+}
+
+// An Expr is a condition or an operand of a condition.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+type (
+	// An Ident is a property or class name. Properties of embedded
+	// objects are written with dots, as in TargetInstance.Name.
+	Ident struct {
+		NamePos Pos
+		Name    string
+	}
+
+	// A BasicLit is a constant. Kind is NUMBER, STRING, TRUE, FALSE or
+	// NULL. For STRING, Value is the unquoted string; for the others it
+	// is the source text.
+	BasicLit struct {
+		ValuePos Pos
+		Kind     Token
+		Value    string
+	}
+
+	// A ParenExpr is a parenthesized condition.
+	ParenExpr struct {
+		Lparen Pos
+		X      Expr
+		Rparen Pos
+	}
+
+	// A UnaryExpr is a negated condition. Op is always NOT.
+	UnaryExpr struct {
+		OpPos Pos
+		Op    Token
+		X     Expr
+	}
+
+	// A BinaryExpr is a logical operation (AND, OR), a comparison, or a
+	// LIKE or ISA test. Not is set for NOT LIKE.
+	BinaryExpr struct {
+		X     Expr
+		OpPos Pos
+		Op    Token
+		Not   bool
+		Y     Expr
+	}
+
+	// An IsNullExpr is an IS NULL or IS NOT NULL test.
+	IsNullExpr struct {
+		X   Expr
+		Is  Pos
+		Not bool
+	}
+)
+
+// Pos implements Node.
+func (x *Ident) Pos() Pos { return x.NamePos }
+
+// Pos implements Node.
+func (x *BasicLit) Pos() Pos { return x.ValuePos }
+
+// Pos implements Node.
+func (x *ParenExpr) Pos() Pos { return x.Lparen }
+
+// Pos implements Node.
+func (x *UnaryExpr) Pos() Pos { return x.OpPos }
+
+// Pos implements Node.
+func (x *BinaryExpr) Pos() Pos { return x.X.Pos() }
+
+// Pos implements Node.
+func (x *IsNullExpr) Pos() Pos { return x.X.Pos() }
+
+func (*Ident) exprNode()      {}
+func (*BasicLit) exprNode()   {}
+func (*ParenExpr) exprNode()  {}
+func (*UnaryExpr) exprNode()  {}
+func (*BinaryExpr) exprNode() {}
+func (*IsNullExpr) exprNode() {}
+
+func (x *Ident) String() string { return x.Name }
+
+func (x *BasicLit) String() string {
+	if x.Kind == STRING {
+		return Quote(x.Value)
+	}
+	return x.Value
+}
+
+func (x *ParenExpr) String() string { return "(" + x.X.String() + ")" }
+
+func (x *UnaryExpr) String() string {
+	return x.Op.String() + " " + operand(x.X, precedence(x))
+}
+
+func (x *BinaryExpr) String() string {
+	p := precedence(x)
+	op := x.Op.String()
+	if x.Not {
+		op = "NOT " + op
+	}
+	// AND and OR are left-associative, so a right operand of the same
+	// precedence needs parentheses to keep its grouping.
+	return operand(x.X, p) + " " + op + " " + operand(x.Y, p+1)
+}
+
+func (x *IsNullExpr) String() string {
+	if x.Not {
+		return operand(x.X, precedence(x)) + " IS NOT NULL"
+	}
+	return operand(x.X, precedence(x)) + " IS NULL"
+}
+
+// precedence returns the binding strength of x; higher binds tighter.
+func precedence(x Expr) int {
+	switch x := x.(type) {
+	case *BinaryExpr:
+		switch x.Op {
+		case OR:
+			return 1
+		case AND:
+			return 2
+		}
+		return 4
+	case *UnaryExpr:
+		return 3
+	case *IsNullExpr:
+		return 4
+	}
+	return 5
+}
+
+// operand formats x as an operand of an operator with precedence p.
+func operand(x Expr, p int) string {
+	if precedence(x) < p {
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// Quote returns s as a WQL string literal. Backslashes and single quotes
+// are escaped with a backslash.
+func Quote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' || s[i] == '\'' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+type (
+	// A SelectStatement is a data, schema or event query:
+	//
+	//	SELECT fields FROM class [WITHIN interval] [WHERE cond]
+	//		[GROUP WITHIN interval [BY fields] [HAVING cond]]
+	SelectStatement struct {
+		Select      Pos
+		Fields      []*Ident // nil for SELECT *
+		From        *Ident
+		Within      *BasicLit // polling interval in seconds, or nil
+		Where       Expr      // or nil
+		GroupWithin *BasicLit // or nil
+		GroupBy     []*Ident
+		Having      Expr // or nil
+	}
+
+	// An AssociatorsStatement is an ASSOCIATORS OF query:
+	//
+	//	ASSOCIATORS OF {path} [WHERE [AssocClass = class]
+	//		[ClassDefsOnly] [KeysOnly] [RequiredAssocQualifier = qualifier]
+	//		[RequiredQualifier = qualifier] [ResultClass = class]
+	//		[ResultRole = property] [Role = property] [SchemaOnly]]
+	AssociatorsStatement struct {
+		Associators            Pos
+		Object                 string // object path
+		AssocClass             string
+		ResultClass            string
+		Role                   string
+		ResultRole             string
+		RequiredAssocQualifier string
+		RequiredQualifier      string
+		ClassDefsOnly          bool
+		SchemaOnly             bool
+		KeysOnly               bool
+	}
+
+	// A ReferencesStatement is a REFERENCES OF query:
+	//
+	//	REFERENCES OF {path} [WHERE [ClassDefsOnly] [KeysOnly]
+	//		[RequiredQualifier = qualifier] [ResultClass = class]
+	//		[Role = property] [SchemaOnly]]
+	ReferencesStatement struct {
+		References        Pos
+		Object            string // object path
+		ResultClass       string
+		Role              string
+		RequiredQualifier string
+		ClassDefsOnly     bool
+		SchemaOnly        bool
+		KeysOnly          bool
+	}
+)
+
+// Pos implements Node.
+func (s *SelectStatement) Pos() Pos { return s.Select }
+
+// Pos implements Node.
+func (s *AssociatorsStatement) Pos() Pos { return s.Associators }
+
+// Pos implements Node.
+func (s *ReferencesStatement) Pos() Pos { return s.References }
+
+func (*SelectStatement) stmtNode()      {}
+func (*AssociatorsStatement) stmtNode() {}
+func (*ReferencesStatement) stmtNode()  {}
+
+func identList(idents []*Ident) string {
+	names := make([]string, len(idents))
+	for i, id := range idents {
+		names[i] = id.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *SelectStatement) String() string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if s.Fields == nil {
+		b.WriteString("*")
+	} else {
+		b.WriteString(identList(s.Fields))
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(s.From.Name)
+	if s.Within != nil {
+		b.WriteString(" WITHIN ")
+		b.WriteString(s.Within.String())
+	}
+	if s.Where != nil {
+		b.WriteString(" WHERE ")
+		b.WriteString(s.Where.String())
+	}
+	if s.GroupWithin != nil {
+		b.WriteString(" GROUP WITHIN ")
+		b.WriteString(s.GroupWithin.String())
+		if len(s.GroupBy) > 0 {
+			b.WriteString(" BY ")
+			b.WriteString(identList(s.GroupBy))
+		}
+		if s.Having != nil {
+			b.WriteString(" HAVING ")
+			b.WriteString(s.Having.String())
+		}
+	}
+	return b.String()
+}
+
+// assocWhere formats the WHERE clause of an ASSOCIATORS OF or REFERENCES OF
+// query. Pairs holds keyword and value pairs; flags the set keywords.
+func assocWhere(b *strings.Builder, pairs []string, flags ...string) {
+	var parts []string
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			parts = append(parts, pairs[i]+" = "+pairs[i+1])
+		}
+	}
+	parts = append(parts, flags...)
+	if len(parts) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(parts, " "))
+	}
+}
+
+func flags(names []string, set ...bool) []string {
+	var out []string
+	for i, ok := range set {
+		if ok {
+			out = append(out, names[i])
+		}
+	}
+	return out
+}
+
+func (s *AssociatorsStatement) String() string {
+	var b strings.Builder
+	b.WriteString("ASSOCIATORS OF {")
+	b.WriteString(s.Object)
+	b.WriteString("}")
+	assocWhere(&b, []string{
+		"AssocClass", s.AssocClass,
+		"RequiredAssocQualifier", s.RequiredAssocQualifier,
+		"RequiredQualifier", s.RequiredQualifier,
+		"ResultClass", s.ResultClass,
+		"ResultRole", s.ResultRole,
+		"Role", s.Role,
+	}, flags([]string{"ClassDefsOnly", "KeysOnly", "SchemaOnly"}, s.ClassDefsOnly, s.KeysOnly, s.SchemaOnly)...)
+	return b.String()
+}
+
+func (s *ReferencesStatement) String() string {
+	var b strings.Builder
+	b.WriteString("REFERENCES OF {")
+	b.WriteString(s.Object)
+	b.WriteString("}")
+	assocWhere(&b, []string{
+		"RequiredQualifier", s.RequiredQualifier,
+		"ResultClass", s.ResultClass,
+		"Role", s.Role,
+	}, flags([]string{"ClassDefsOnly", "KeysOnly", "SchemaOnly"}, s.ClassDefsOnly, s.KeysOnly, s.SchemaOnly)...)
+	return b.String()
+}
+
+// Int returns the value of a NUMBER literal as an int64.
+func (x *BasicLit) Int() (int64, error) {
+	return strconv.ParseInt(x.Value, 0, 64)
+}
+
+// Uint returns the value of a NUMBER literal as a uint64.
+func (x *BasicLit) Uint() (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(x.Value, "+"), 0, 64)
+}
+
+// Float returns the value of a NUMBER literal as a float64.
+func (x *BasicLit) Float() (float64, error) {
+	if i, err := x.Int(); err == nil {
+		return float64(i), nil
+	}
+	if u, err := x.Uint(); err == nil {
+		return float64(u), nil
+	}
+	return strconv.ParseFloat(x.Value, 64)
+}
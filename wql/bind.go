@@ -0,0 +1,189 @@
+package wql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A NamedArg is a value bound to an @name placeholder by Bind.
+type NamedArg struct {
+	Name  string
+	Value interface{}
+}
+
+// Named returns an argument for the @name placeholder.
+func Named(name string, value interface{}) NamedArg {
+	return NamedArg{Name: name, Value: value}
+}
+
+// Bind replaces the placeholders in query with args and returns the
+// resulting query.
+//
+// A ? placeholder takes the next argument that is not a NamedArg, and an
+// @name placeholder takes the NamedArg of that name, which may be used more
+// than once. Values are written as by Lit, so strings are quoted and
+// escaped and cannot change the structure of the query:
+//
+//	q, err := wql.Bind("SELECT * FROM Win32_Process WHERE Name = ? AND ProcessId > @pid",
+//		name, wql.Named("pid", 4))
+//
+// A placeholder that is the whole object path of an ASSOCIATORS OF or
+// REFERENCES OF query, as in "ASSOCIATORS OF {?}", takes a string or a
+// fmt.Stringer, which must be a well-formed path.
+//
+// Placeholders in string literals and object paths are left alone. It is an
+// error for an argument to be left over or missing, or for an object path to
+// be unterminated.
+func Bind(query string, args ...interface{}) (string, error) {
+	var positional []interface{}
+	named := make(map[string]interface{})
+	usedNamed := make(map[string]bool)
+	for _, arg := range args {
+		if n, ok := arg.(NamedArg); ok {
+			if n.Name == "" || nameLen(n.Name) != len(n.Name) {
+				return "", fmt.Errorf("wql: invalid argument name %q", n.Name)
+			}
+			key := strings.ToLower(n.Name)
+			if _, dup := named[key]; dup {
+				return "", fmt.Errorf("wql: duplicate argument @%s", n.Name)
+			}
+			named[key] = n.Value
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	var b strings.Builder
+	next := 0
+	arg := func(i int) (interface{}, string, error) {
+		switch query[i] {
+		case '?':
+			if next >= len(positional) {
+				return nil, "", fmt.Errorf("wql: missing argument for placeholder %d at offset %d", next+1, i)
+			}
+			next++
+			return positional[next-1], "?", nil
+		case '@':
+			end := i + 1 + nameLen(query[i+1:])
+			name := query[i+1 : end]
+			if name == "" {
+				return nil, "", fmt.Errorf("wql: missing argument name at offset %d", i)
+			}
+			v, ok := named[strings.ToLower(name)]
+			if !ok {
+				return nil, "", fmt.Errorf("wql: missing argument @%s", name)
+			}
+			usedNamed[strings.ToLower(name)] = true
+			return v, query[i:end], nil
+		}
+		return nil, "", nil
+	}
+
+	for i := 0; i < len(query); {
+		switch c := query[i]; c {
+		case '\'', '"':
+			end := skipString(query, i)
+			b.WriteString(query[i:end])
+			i = end
+		case '{':
+			end, err := bindPath(&b, query, i, arg)
+			if err != nil {
+				return "", err
+			}
+			i = end
+		case '?', '@':
+			v, text, err := arg(i)
+			if err != nil {
+				return "", err
+			}
+			lit, err := Lit(v)
+			if err != nil {
+				return "", fmt.Errorf("wql: argument %s: %v", text, strings.TrimPrefix(err.Error(), "wql: "))
+			}
+			b.WriteString(lit.String())
+			i += len(text)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	if next < len(positional) {
+		return "", fmt.Errorf("wql: %d arguments for %d placeholders", len(positional), next)
+	}
+	for name := range named {
+		if !usedNamed[name] {
+			return "", fmt.Errorf("wql: unused argument @%s", name)
+		}
+	}
+	return b.String(), nil
+}
+
+// nameLen returns the length of the argument name at the start of s. Names
+// consist of ASCII letters, digits and underscores.
+func nameLen(s string) int {
+	n := 0
+	for n < len(s) && (s[n] == '_' || 'a' <= s[n] && s[n] <= 'z' || 'A' <= s[n] && s[n] <= 'Z' || isDigit(s[n])) {
+		n++
+	}
+	return n
+}
+
+// skipString returns the offset just past the string literal starting at i.
+// An unterminated literal extends to the end of the query.
+func skipString(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// bindPath writes the object path starting with the brace at offset i to b,
+// substituting the path if it is a single placeholder, and returns the
+// offset just past the closing brace.
+func bindPath(b *strings.Builder, query string, i int, arg func(int) (interface{}, string, error)) (int, error) {
+	var s Scanner
+	s.Init(query[i:])
+	s.Scan()
+	if s.Err != nil {
+		return 0, fmt.Errorf("wql: %s at offset %d", s.Err.Msg, i)
+	}
+	end := i + s.offset
+	if end > len(query) {
+		return 0, fmt.Errorf("wql: unterminated object path at offset %d", i)
+	}
+	content := strings.TrimSpace(query[i+1 : end-1])
+	if content != "?" && !(len(content) > 1 && content[0] == '@' && nameLen(content[1:]) == len(content)-1) {
+		// Not just a placeholder; leave the path as written.
+		b.WriteString(query[i:end])
+		return end, nil
+	}
+	v, text, err := arg(i + 1 + strings.Index(query[i+1:], content))
+	if err != nil {
+		return 0, err
+	}
+	var path string
+	switch v := v.(type) {
+	case string:
+		path = v
+	case fmt.Stringer:
+		path = v.String()
+	default:
+		return 0, fmt.Errorf("wql: argument %s: object path must be a string, not %T", text, v)
+	}
+	s.Init("{" + path + "}")
+	if _, tok, lit := s.Scan(); tok != PATH || lit != strings.TrimSpace(path) || lit == "" {
+		return 0, fmt.Errorf("wql: argument %s: invalid object path %q", text, path)
+	}
+	if _, tok, _ := s.Scan(); tok != EOF {
+		return 0, fmt.Errorf("wql: argument %s: invalid object path %q", text, path)
+	}
+	b.WriteString("{" + path + "}")
+	return end, nil
+}
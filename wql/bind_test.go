@@ -0,0 +1,128 @@
+package wql
+
+import (
+	"testing"
+	"time"
+)
+
+type path string
+
+func (p path) String() string { return string(p) }
+
+type stringer struct{ s string }
+
+func (s *stringer) String() string { return s.s }
+
+func TestBind(t *testing.T) {
+	name := "x' OR Name LIKE '%"
+	pid := uint32(672)
+	var nilPid *uint32
+	est := time.FixedZone("EST", -5*60*60)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{"SELECT * FROM Win32_Process", nil, "SELECT * FROM Win32_Process"},
+		{
+			"SELECT * FROM Win32_Process WHERE Name = ? AND ProcessId > ?",
+			[]interface{}{name, 4},
+			`SELECT * FROM Win32_Process WHERE Name = 'x\' OR Name LIKE \'%' AND ProcessId > 4`,
+		},
+		{
+			"SELECT * FROM Win32_Directory WHERE Name = @dir OR Path = @DIR",
+			[]interface{}{Named("dir", `C:\Windows`)},
+			`SELECT * FROM Win32_Directory WHERE Name = 'C:\\Windows' OR Path = 'C:\\Windows'`,
+		},
+		{
+			"SELECT * FROM X WHERE A = ? AND B = @b AND C = ? AND D = ?",
+			[]interface{}{true, Named("b", int8(-3)), &pid, nilPid},
+			"SELECT * FROM X WHERE A = TRUE AND B = -3 AND C = 672 AND D = NULL",
+		},
+		{
+			"SELECT * FROM Win32_Process WHERE CreationDate > ?",
+			[]interface{}{time.Date(2021, 3, 4, 5, 6, 7, 891011000, est)},
+			"SELECT * FROM Win32_Process WHERE CreationDate > '20210304050607.891011-300'",
+		},
+		{
+			"SELECT * FROM Win32_Process WHERE CreationDate > ?",
+			[]interface{}{&created},
+			"SELECT * FROM Win32_Process WHERE CreationDate > '20200102030405.000000+000'",
+		},
+		{
+			"SELECT * FROM X WHERE Name = '?' AND Path = \"@p\" AND Other = ?",
+			[]interface{}{"it's"},
+			`SELECT * FROM X WHERE Name = '?' AND Path = "@p" AND Other = 'it\'s'`,
+		},
+		{
+			"ASSOCIATORS OF {?} WHERE ResultClass = Win32_Process",
+			[]interface{}{path(`Win32_Service.Name="Spooler"`)},
+			`ASSOCIATORS OF {Win32_Service.Name="Spooler"} WHERE ResultClass = Win32_Process`,
+		},
+		{
+			"REFERENCES OF { @p }",
+			[]interface{}{Named("p", `\\.\root\cimv2:Win32_Directory.Name="C:\\{x}"`)},
+			`REFERENCES OF {\\.\root\cimv2:Win32_Directory.Name="C:\\{x}"}`,
+		},
+		{
+			`ASSOCIATORS OF {Win32_Service.Name="?"} WHERE Role = ?`,
+			[]interface{}{"x"},
+			`ASSOCIATORS OF {Win32_Service.Name="?"} WHERE Role = 'x'`,
+		},
+		{
+			"SELECT * FROM X WHERE __PATH = ?",
+			[]interface{}{&stringer{`\\HOST\root\cimv2:X.Id="1"`}},
+			`SELECT * FROM X WHERE __PATH = '\\\\HOST\\root\\cimv2:X.Id="1"'`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := Bind(tt.query, tt.args...)
+		if err != nil {
+			t.Errorf("Bind(%q): %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Bind(%q):\ngot  %s\nwant %s", tt.query, got, tt.want)
+		}
+		if _, err := Parse(got); err != nil {
+			t.Errorf("Bind(%q) = %q: %v", tt.query, got, err)
+		}
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	hour := time.Hour
+	tests := []struct {
+		query string
+		args  []interface{}
+		want  string
+	}{
+		{"SELECT * FROM X WHERE A = ? AND B = ?", []interface{}{1}, "wql: missing argument for placeholder 2 at offset 36"},
+		{"SELECT * FROM X WHERE A = ?", []interface{}{1, 2}, "wql: 2 arguments for 1 placeholders"},
+		{"SELECT * FROM X WHERE A = @a", nil, "wql: missing argument @a"},
+		{"SELECT * FROM X WHERE A = @", nil, "wql: missing argument name at offset 26"},
+		{"SELECT * FROM X", []interface{}{Named("a", 1)}, "wql: unused argument @a"},
+		{"SELECT * FROM X WHERE A = @a", []interface{}{Named("a", 1), Named("A", 2)}, "wql: duplicate argument @A"},
+		{"SELECT * FROM X WHERE A = @a", []interface{}{Named("a b", 1)}, `wql: invalid argument name "a b"`},
+		{"SELECT * FROM X WHERE A = ?", []interface{}{[]byte("a")}, "wql: argument ?: unsupported value type []uint8"},
+		{"SELECT * FROM X WHERE A = @d", []interface{}{Named("d", time.Second)}, "wql: argument @d: unsupported value type time.Duration"},
+		{"SELECT * FROM X WHERE A = ?", []interface{}{&hour}, "wql: argument ?: unsupported value type time.Duration"},
+		{"ASSOCIATORS OF {?}", []interface{}{1}, "wql: argument ?: object path must be a string, not int"},
+		{"ASSOCIATORS OF {?}", []interface{}{`X.Name="a"} WHERE ResultClass = Y`}, `wql: argument ?: invalid object path "X.Name=\"a\"} WHERE ResultClass = Y"`},
+		{"ASSOCIATORS OF {?}", []interface{}{""}, `wql: argument ?: invalid object path ""`},
+		{`ASSOCIATORS OF {"\`, nil, "wql: unterminated object path at offset 15"},
+		{`ASSOCIATORS OF {"abc\`, nil, "wql: unterminated object path at offset 15"},
+		{"ASSOCIATORS OF {X.Name='?}", []interface{}{1}, "wql: unterminated object path at offset 15"},
+	}
+	for _, tt := range tests {
+		_, err := Bind(tt.query, tt.args...)
+		if err == nil {
+			t.Errorf("Bind(%q): no error", tt.query)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("Bind(%q):\ngot  %s\nwant %s", tt.query, err, tt.want)
+		}
+	}
+}
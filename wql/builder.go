@@ -0,0 +1,311 @@
+package wql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/StackExchange/wmi/cimdatetime"
+	"github.com/StackExchange/wmi/internal/fields"
+)
+
+// A Builder builds a SELECT query for the properties of a struct type, so
+// that conditions are written with Go values instead of quoted strings:
+//
+//	var dst []Win32_Service
+//	q := wql.Select(&dst).Where(wql.Eq("State", "Running")).And(wql.Like("Name", "sql%"))
+//	// SELECT ... FROM Win32_Service WHERE State = 'Running' AND Name LIKE 'sql%'
+//
+// Errors, such as an invalid property name or an unsupported value, are
+// reported by Statement. Pass the builder to wmi.Client.Run to run the query.
+type Builder struct {
+	dst    interface{}
+	fields []string
+	class  string
+	where  Expr
+	err    error
+}
+
+// Select starts a query that selects the fields of dst, which must be a
+// struct or a slice of structs, or a pointer to one of those. Fields are
+// mapped to properties by their wmi tags as in wmi.Query. The class defaults
+// to the one named by the wmi tag of a blank (_) field, or else the name of
+// the struct type.
+func Select(dst interface{}) *Builder {
+	b := &Builder{dst: dst}
+	t, ok := fields.StructType(dst)
+	if !ok {
+		b.err = fmt.Errorf("wql: cannot select into %T", dst)
+		return b
+	}
+	s := fields.Of(t)
+	b.fields = s.Selected()
+	b.class = s.Class
+	return b
+}
+
+// From sets the class to query, which is useful for anonymous structs and
+// for types named differently from the class.
+func (b *Builder) From(class string) *Builder {
+	b.class = class
+	return b
+}
+
+// Where sets the condition of the query, replacing any previous one.
+func (b *Builder) Where(cond Expr) *Builder {
+	b.where = cond
+	return b
+}
+
+// And adds cond to the condition of the query with AND.
+func (b *Builder) And(cond Expr) *Builder {
+	if b.where == nil {
+		b.where = cond
+	} else {
+		b.where = &BinaryExpr{X: b.where, OpPos: NoPos, Op: AND, Y: cond}
+	}
+	return b
+}
+
+// Or adds cond to the condition of the query with OR.
+func (b *Builder) Or(cond Expr) *Builder {
+	if b.where == nil {
+		b.where = cond
+	} else {
+		b.where = &BinaryExpr{X: b.where, OpPos: NoPos, Op: OR, Y: cond}
+	}
+	return b
+}
+
+// Dst returns the destination passed to Select.
+func (b *Builder) Dst() interface{} {
+	return b.dst
+}
+
+// Statement returns the syntax tree of the query, or the first error made
+// while building it.
+func (b *Builder) Statement() (*SelectStatement, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if !isIdent(b.class) {
+		if b.class == "" {
+			return nil, errors.New("wql: no class to select from")
+		}
+		return nil, fmt.Errorf("wql: invalid class name %q", b.class)
+	}
+	s := &SelectStatement{
+		Select: NoPos,
+		From:   &Ident{NamePos: NoPos, Name: b.class},
+		Where:  b.where,
+	}
+	// A struct without fields leaves Fields nil and selects all properties
+	// rather than producing an invalid query.
+	for _, f := range b.fields {
+		if !isIdent(f) {
+			return nil, fmt.Errorf("wql: invalid property name %q", f)
+		}
+		s.Fields = append(s.Fields, &Ident{NamePos: NoPos, Name: f})
+	}
+	if err := exprError(b.where); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// String returns the query as WQL, or "" if it could not be built.
+func (b *Builder) String() string {
+	s, err := b.Statement()
+	if err != nil {
+		return ""
+	}
+	return s.String()
+}
+
+// badExpr stands in for a condition that could not be built. It is reported
+// by Builder.Statement.
+type badExpr struct {
+	err error
+}
+
+func (x *badExpr) Pos() Pos       { return NoPos }
+func (x *badExpr) String() string { return "BAD" }
+func (*badExpr) exprNode()        {}
+
+// exprError returns the error of the first badExpr in x, if any.
+func exprError(x Expr) error {
+	switch x := x.(type) {
+	case *badExpr:
+		return x.err
+	case *ParenExpr:
+		return exprError(x.X)
+	case *UnaryExpr:
+		return exprError(x.X)
+	case *BinaryExpr:
+		if err := exprError(x.X); err != nil {
+			return err
+		}
+		return exprError(x.Y)
+	case *IsNullExpr:
+		return exprError(x.X)
+	}
+	return nil
+}
+
+// isIdent reports whether name is a single WQL identifier that is not a
+// keyword.
+func isIdent(name string) bool {
+	var s Scanner
+	s.Init(name)
+	_, tok, lit := s.Scan()
+	if tok != IDENT || lit != name {
+		return false
+	}
+	_, tok, _ = s.Scan()
+	return tok == EOF
+}
+
+func property(name string) Expr {
+	if !isIdent(name) {
+		return &badExpr{fmt.Errorf("wql: invalid property name %q", name)}
+	}
+	return &Ident{NamePos: NoPos, Name: name}
+}
+
+// Lit returns the WQL constant for v, which must be nil, a bool, a string,
+// an integer, a floating-point number, a time.Time or a fmt.Stringer, a type
+// based on one of those, or a pointer to one of those. A nil pointer is NULL.
+// Times are written as CIM DATETIME strings and Stringers, such as object
+// paths, as strings.
+func Lit(v interface{}) (*BasicLit, error) {
+	switch v := v.(type) {
+	case nil:
+		return &BasicLit{ValuePos: NoPos, Kind: NULL, Value: "NULL"}, nil
+	case time.Time:
+		return &BasicLit{ValuePos: NoPos, Kind: STRING, Value: cimdatetime.FormatTime(v)}, nil
+	case time.Duration:
+		// A Duration is an integer and a Stringer, but neither is what a
+		// query means by it.
+		return nil, fmt.Errorf("wql: unsupported value type %T", v)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return Lit(nil)
+		}
+		lit, err := Lit(rv.Elem().Interface())
+		if err == nil {
+			return lit, nil
+		}
+		// Stringers whose String method has a pointer receiver, but not
+		// *time.Duration, which is rejected like time.Duration.
+		if s, ok := v.(fmt.Stringer); ok && rv.Elem().Type() != reflect.TypeOf(time.Duration(0)) {
+			return &BasicLit{ValuePos: NoPos, Kind: STRING, Value: s.String()}, nil
+		}
+		return nil, err
+	case reflect.Bool:
+		if rv.Bool() {
+			return &BasicLit{ValuePos: NoPos, Kind: TRUE, Value: "TRUE"}, nil
+		}
+		return &BasicLit{ValuePos: NoPos, Kind: FALSE, Value: "FALSE"}, nil
+	case reflect.String:
+		return &BasicLit{ValuePos: NoPos, Kind: STRING, Value: rv.String()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &BasicLit{ValuePos: NoPos, Kind: NUMBER, Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &BasicLit{ValuePos: NoPos, Kind: NUMBER, Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("wql: cannot use %v in a query", f)
+		}
+		return &BasicLit{ValuePos: NoPos, Kind: NUMBER, Value: strconv.FormatFloat(f, 'g', -1, rv.Type().Bits())}, nil
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return &BasicLit{ValuePos: NoPos, Kind: STRING, Value: s.String()}, nil
+	}
+	return nil, fmt.Errorf("wql: unsupported value type %T", v)
+}
+
+func compare(op Token, prop string, value interface{}) Expr {
+	lit, err := Lit(value)
+	if err != nil {
+		return &badExpr{err}
+	}
+	return &BinaryExpr{X: property(prop), OpPos: NoPos, Op: op, Y: lit}
+}
+
+// Eq returns the condition prop = value. See Lit for the supported values.
+func Eq(prop string, value interface{}) Expr { return compare(EQL, prop, value) }
+
+// Ne returns the condition prop <> value.
+func Ne(prop string, value interface{}) Expr { return compare(NEQ, prop, value) }
+
+// Lt returns the condition prop < value.
+func Lt(prop string, value interface{}) Expr { return compare(LSS, prop, value) }
+
+// Le returns the condition prop <= value.
+func Le(prop string, value interface{}) Expr { return compare(LEQ, prop, value) }
+
+// Gt returns the condition prop > value.
+func Gt(prop string, value interface{}) Expr { return compare(GTR, prop, value) }
+
+// Ge returns the condition prop >= value.
+func Ge(prop string, value interface{}) Expr { return compare(GEQ, prop, value) }
+
+// Like returns the condition prop LIKE pattern. In the pattern, % matches
+// any string, _ any character and [...] any character of a set.
+func Like(prop, pattern string) Expr {
+	return &BinaryExpr{X: property(prop), OpPos: NoPos, Op: LIKE, Y: &BasicLit{ValuePos: NoPos, Kind: STRING, Value: pattern}}
+}
+
+// NotLike returns the condition prop NOT LIKE pattern.
+func NotLike(prop, pattern string) Expr {
+	return &BinaryExpr{X: property(prop), OpPos: NoPos, Op: LIKE, Not: true, Y: &BasicLit{ValuePos: NoPos, Kind: STRING, Value: pattern}}
+}
+
+// Isa returns the condition prop ISA 'class', which holds for embedded
+// objects of the class or a subclass, such as TargetInstance in event
+// queries.
+func Isa(prop, class string) Expr {
+	if !isIdent(class) {
+		return &badExpr{fmt.Errorf("wql: invalid class name %q", class)}
+	}
+	return &BinaryExpr{X: property(prop), OpPos: NoPos, Op: ISA, Y: &BasicLit{ValuePos: NoPos, Kind: STRING, Value: class}}
+}
+
+// IsNull returns the condition prop IS NULL.
+func IsNull(prop string) Expr {
+	return &IsNullExpr{X: property(prop), Is: NoPos}
+}
+
+// IsNotNull returns the condition prop IS NOT NULL.
+func IsNotNull(prop string) Expr {
+	return &IsNullExpr{X: property(prop), Is: NoPos, Not: true}
+}
+
+// Not returns the negation of cond.
+func Not(cond Expr) Expr {
+	return &UnaryExpr{OpPos: NoPos, Op: NOT, X: cond}
+}
+
+// All returns the conjunction of conds, which must not be empty.
+func All(conds ...Expr) Expr { return join(AND, conds) }
+
+// Any returns the disjunction of conds, which must not be empty.
+func Any(conds ...Expr) Expr { return join(OR, conds) }
+
+func join(op Token, conds []Expr) Expr {
+	if len(conds) == 0 {
+		return &badExpr{fmt.Errorf("wql: empty %s condition", op)}
+	}
+	x := conds[0]
+	for _, y := range conds[1:] {
+		x = &BinaryExpr{X: x, OpPos: NoPos, Op: op, Y: y}
+	}
+	return x
+}
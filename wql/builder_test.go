@@ -0,0 +1,112 @@
+package wql
+
+import (
+	"math"
+	"testing"
+)
+
+type Win32_Service struct {
+	Name    string
+	State   string
+	Started bool
+}
+
+func TestBuilder(t *testing.T) {
+	var dst []Win32_Service
+	var ptrs []*Win32_Service
+	type level int
+	tests := []struct {
+		b    *Builder
+		want string
+	}{
+		{Select(&dst), "SELECT Name, State, Started FROM Win32_Service"},
+		{Select(&ptrs), "SELECT Name, State, Started FROM Win32_Service"},
+		{Select(Win32_Service{}).From("Win32_BaseService"), "SELECT Name, State, Started FROM Win32_BaseService"},
+		{
+			Select(&dst).Where(Eq("State", "Running")).And(Like("Name", "sql%")),
+			"SELECT Name, State, Started FROM Win32_Service WHERE State = 'Running' AND Name LIKE 'sql%'",
+		},
+		{
+			Select(&dst).Where(Eq("Name", `O'Brien\svc`)),
+			`SELECT Name, State, Started FROM Win32_Service WHERE Name = 'O\'Brien\\svc'`,
+		},
+		{
+			Select(&dst).Where(Eq("Started", true)).Or(Ne("State", "Stopped")).And(IsNotNull("Name")),
+			"SELECT Name, State, Started FROM Win32_Service WHERE (Started = TRUE OR State <> 'Stopped') AND Name IS NOT NULL",
+		},
+		{
+			Select(&dst).And(Not(Any(Lt("A", -1), Le("B", uint64(math.MaxUint64)), All(Gt("C", 1.5), Ge("D", level(3)))))),
+			"SELECT Name, State, Started FROM Win32_Service WHERE NOT (A < -1 OR B <= 18446744073709551615 OR C > 1.5 AND D >= 3)",
+		},
+		{
+			Select(&dst).Where(NotLike("Name", "[a-c]%")).Or(IsNull("State")).Or(Eq("State", nil)),
+			"SELECT Name, State, Started FROM Win32_Service WHERE Name NOT LIKE '[a-c]%' OR State IS NULL OR State = NULL",
+		},
+		{
+			Select(&struct{ TargetInstance string }{}).From("__InstanceCreationEvent").Where(Isa("TargetInstance", "Win32_Process")),
+			"SELECT TargetInstance FROM __InstanceCreationEvent WHERE TargetInstance ISA 'Win32_Process'",
+		},
+		{Select(&struct{}{}).From("Win32_Process"), "SELECT * FROM Win32_Process"},
+		{
+			Select(&[]struct {
+				_       struct{} `wmi:"Win32_Process"`
+				ID      uint32   `wmi:"ProcessId"`
+				Name    string
+				Class   string `wmi:"__CLASS,noselect"`
+				Comment string `wmi:"-"`
+			}{}).Where(Gt("ProcessId", 4)),
+			"SELECT ProcessId, Name FROM Win32_Process WHERE ProcessId > 4",
+		},
+	}
+	for _, tt := range tests {
+		s, err := tt.b.Statement()
+		if err != nil {
+			t.Errorf("%s: %v", tt.want, err)
+			continue
+		}
+		if got := s.String(); got != tt.want {
+			t.Errorf("got  %s\nwant %s", got, tt.want)
+		}
+		if got := tt.b.String(); got != tt.want {
+			t.Errorf("String() = %s, want %s", got, tt.want)
+		}
+		// The built query must parse back to the same query.
+		if stmt, err := Parse(tt.want); err != nil {
+			t.Errorf("%s: %v", tt.want, err)
+		} else if stmt.String() != tt.want {
+			t.Errorf("reparsed %s as %s", tt.want, stmt)
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	var dst []Win32_Service
+	tests := []struct {
+		b    *Builder
+		want string
+	}{
+		{Select(&[]int{}), "wql: cannot select into *[]int"},
+		{Select(nil), "wql: cannot select into <nil>"},
+		{Select(&struct{ Name string }{}), "wql: no class to select from"},
+		{Select(&dst).From("Win32_Service WHERE 1 = 1"), `wql: invalid class name "Win32_Service WHERE 1 = 1"`},
+		{Select(&dst).Where(Eq("Name = 'x' OR Name", "y")), `wql: invalid property name "Name = 'x' OR Name"`},
+		{Select(&dst).Where(Eq("Name", []string{"a"})), "wql: unsupported value type []string"},
+		{Select(&dst).Where(Eq("Name", "a")).And(Gt("Size", math.NaN())), "wql: cannot use NaN in a query"},
+		{Select(&dst).Where(Isa("TargetInstance", "x'y")), `wql: invalid class name "x'y"`},
+		{Select(&dst).Where(Any()), "wql: empty OR condition"},
+		{Select(&dst).Where(Not(IsNull("Select"))), `wql: invalid property name "Select"`},
+	}
+	for _, tt := range tests {
+		_, err := tt.b.Statement()
+		if err == nil {
+			t.Errorf("%s: no error", tt.want)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("got %q, want %q", err, tt.want)
+		}
+		if s := tt.b.String(); s != "" {
+			t.Errorf("String() = %q, want empty", s)
+		}
+	}
+}
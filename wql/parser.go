@@ -0,0 +1,419 @@
+// Package wql parses queries written in the WMI Query Language.
+//
+// Parse turns a query into a syntax tree of SelectStatement,
+// AssociatorsStatement or ReferencesStatement nodes, and the String method
+// of every node turns it back into WQL. Errors are reported as
+// *SyntaxError values with the line and column of the offending token.
+//
+//	stmt, err := wql.Parse("SELECT Name FROM Win32_Service WHERE State = 'Running'")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	sel := stmt.(*wql.SelectStatement)
+//	fmt.Println(sel.From.Name, sel.Where) // Win32_Service State = 'Running'
+//
+// Select goes the other way and builds a query for a struct type from
+// conditions such as Eq and Like, quoting values as needed. Bind fills the
+// ? and @name placeholders of a query written by hand.
+package wql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse parses a single WQL query.
+func Parse(query string) (Statement, error) {
+	var p parser
+	p.init(query)
+	var stmt Statement
+	err := p.run(func() {
+		stmt = p.parseStatement()
+		p.expect(EOF)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// ParseExpr parses a condition such as the WHERE clause of a query.
+func ParseExpr(x string) (Expr, error) {
+	var p parser
+	p.init(x)
+	var expr Expr
+	err := p.run(func() {
+		expr = p.parseExpr()
+		p.expect(EOF)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+type parser struct {
+	scanner Scanner
+
+	// current token
+	pos Pos
+	tok Token
+	lit string
+}
+
+// bailout is used by error to abort parsing; run recovers it.
+type bailout struct{ err *SyntaxError }
+
+func (p *parser) init(src string) {
+	p.scanner.Init(src)
+}
+
+// run scans the first token and calls f, returning the syntax error that
+// either of them bails out with.
+func (p *parser) run(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+	p.next()
+	f()
+	return nil
+}
+
+func (p *parser) next() {
+	p.pos, p.tok, p.lit = p.scanner.Scan()
+	if p.scanner.Err != nil {
+		panic(bailout{p.scanner.Err})
+	}
+}
+
+func (p *parser) error(pos Pos, msg string) {
+	panic(bailout{&SyntaxError{Pos: position(p.scanner.src, pos), Msg: msg}})
+}
+
+// found describes the current token for error messages.
+func (p *parser) found() string {
+	switch p.tok {
+	case EOF:
+		return "end of query"
+	case IDENT, NUMBER:
+		return fmt.Sprintf("'%s'", p.lit)
+	case STRING:
+		return "string " + Quote(p.lit)
+	case PATH:
+		return "object path {" + p.lit + "}"
+	}
+	return p.tok.String()
+}
+
+func (p *parser) errorExpected(what string) {
+	p.error(p.pos, "expected "+what+", found "+p.found())
+}
+
+func (p *parser) expect(tok Token) Pos {
+	pos := p.pos
+	if p.tok != tok {
+		p.errorExpected(tok.String())
+	}
+	p.next()
+	return pos
+}
+
+func (p *parser) got(tok Token) bool {
+	if p.tok == tok {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseStatement() Statement {
+	switch p.tok {
+	case SELECT:
+		return p.parseSelect()
+	case ASSOCIATORS:
+		return p.parseAssociators()
+	case REFERENCES:
+		return p.parseReferences()
+	}
+	p.errorExpected("SELECT, ASSOCIATORS or REFERENCES")
+	return nil
+}
+
+func (p *parser) parseIdent() *Ident {
+	id := &Ident{NamePos: p.pos, Name: p.lit}
+	if p.tok != IDENT {
+		p.errorExpected("name")
+	}
+	p.next()
+	return id
+}
+
+func (p *parser) parseIdentList() []*Ident {
+	list := []*Ident{p.parseIdent()}
+	for p.got(COMMA) {
+		list = append(list, p.parseIdent())
+	}
+	return list
+}
+
+func (p *parser) parseNumber() *BasicLit {
+	lit := &BasicLit{ValuePos: p.pos, Kind: NUMBER, Value: p.lit}
+	if p.tok != NUMBER {
+		p.errorExpected("number")
+	}
+	p.next()
+	return lit
+}
+
+func (p *parser) parseSelect() *SelectStatement {
+	s := &SelectStatement{Select: p.expect(SELECT)}
+	if !p.got(STAR) {
+		s.Fields = p.parseIdentList()
+	}
+	p.expect(FROM)
+	s.From = p.parseIdent()
+	if p.got(WITHIN) {
+		s.Within = p.parseNumber()
+	}
+	if p.got(WHERE) {
+		s.Where = p.parseExpr()
+	}
+	if p.got(GROUP) {
+		p.expect(WITHIN)
+		s.GroupWithin = p.parseNumber()
+		if p.got(BY) {
+			s.GroupBy = p.parseIdentList()
+		}
+		if p.got(HAVING) {
+			s.Having = p.parseExpr()
+		}
+	}
+	return s
+}
+
+// parseExpr parses a condition. OR binds loosest, then AND, then NOT.
+func (p *parser) parseExpr() Expr {
+	x := p.parseAnd()
+	for p.tok == OR {
+		pos := p.pos
+		p.next()
+		x = &BinaryExpr{X: x, OpPos: pos, Op: OR, Y: p.parseAnd()}
+	}
+	return x
+}
+
+func (p *parser) parseAnd() Expr {
+	x := p.parseNot()
+	for p.tok == AND {
+		pos := p.pos
+		p.next()
+		x = &BinaryExpr{X: x, OpPos: pos, Op: AND, Y: p.parseNot()}
+	}
+	return x
+}
+
+func (p *parser) parseNot() Expr {
+	if p.tok == NOT {
+		pos := p.pos
+		p.next()
+		return &UnaryExpr{OpPos: pos, Op: NOT, X: p.parseNot()}
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() Expr {
+	if p.tok == LPAREN {
+		lparen := p.pos
+		p.next()
+		x := p.parseExpr()
+		return &ParenExpr{Lparen: lparen, X: x, Rparen: p.expect(RPAREN)}
+	}
+
+	x := p.parseOperand()
+	switch {
+	case p.tok.IsComparison():
+		pos, op := p.pos, p.tok
+		p.next()
+		return &BinaryExpr{X: x, OpPos: pos, Op: op, Y: p.parseOperand()}
+	case p.tok == IS:
+		is := p.pos
+		p.next()
+		not := p.got(NOT)
+		p.expect(NULL)
+		return &IsNullExpr{X: x, Is: is, Not: not}
+	case p.tok == NOT, p.tok == LIKE:
+		pos := p.pos
+		not := p.got(NOT)
+		p.expect(LIKE)
+		y := p.parseOperand()
+		if lit, ok := y.(*BasicLit); !ok || lit.Kind != STRING {
+			p.error(y.Pos(), "LIKE pattern must be a string")
+		}
+		return &BinaryExpr{X: x, OpPos: pos, Op: LIKE, Not: not, Y: y}
+	case p.tok == ISA:
+		pos := p.pos
+		p.next()
+		y := p.parseOperand()
+		if lit, ok := y.(*BasicLit); !ok || lit.Kind != STRING {
+			p.error(y.Pos(), "ISA requires a class name string")
+		}
+		return &BinaryExpr{X: x, OpPos: pos, Op: ISA, Y: y}
+	}
+	p.errorExpected("comparison, LIKE, ISA or IS")
+	return nil
+}
+
+func (p *parser) parseOperand() Expr {
+	switch p.tok {
+	case IDENT:
+		return p.parseIdent()
+	case NUMBER, STRING, TRUE, FALSE, NULL:
+		lit := &BasicLit{ValuePos: p.pos, Kind: p.tok, Value: p.lit}
+		p.next()
+		return lit
+	}
+	p.errorExpected("operand")
+	return nil
+}
+
+func (p *parser) parsePath() string {
+	if p.tok != PATH {
+		p.errorExpected("object path")
+	}
+	path := p.lit
+	if path == "" {
+		p.error(p.pos, "empty object path")
+	}
+	p.next()
+	return path
+}
+
+// assocClause parses the WHERE clause of ASSOCIATORS OF and REFERENCES OF
+// queries. Each keyword is either a flag or takes "= value"; set is called
+// with the canonical keyword and the value, and returns false if the
+// keyword is not accepted.
+func (p *parser) assocClause(set func(keyword, value string) bool) {
+	if !p.got(WHERE) {
+		return
+	}
+	seen := make(map[string]bool)
+	for p.tok == IDENT {
+		pos, keyword := p.pos, p.lit
+		p.next()
+		value := ""
+		if p.got(EQL) {
+			if p.tok != IDENT && p.tok != STRING {
+				p.errorExpected("name")
+			}
+			value = p.lit
+			if value == "" {
+				p.errorExpected("name")
+			}
+			p.next()
+		}
+		canonical, ok := assocKeywords[strings.ToLower(keyword)]
+		if !ok || !set(canonical, value) {
+			p.error(pos, fmt.Sprintf("unknown keyword %s", keyword))
+		}
+		if isFlag := assocFlags[canonical]; isFlag != (value == "") {
+			if isFlag {
+				p.error(pos, canonical+" does not take a value")
+			}
+			p.error(pos, canonical+" requires a value")
+		}
+		if seen[canonical] {
+			p.error(pos, "duplicate "+canonical)
+		}
+		seen[canonical] = true
+	}
+	if len(seen) == 0 {
+		p.errorExpected("keyword")
+	}
+}
+
+var assocKeywords = make(map[string]string)
+
+var assocFlags = map[string]bool{
+	"ClassDefsOnly": true,
+	"KeysOnly":      true,
+	"SchemaOnly":    true,
+}
+
+func init() {
+	for _, k := range []string{
+		"AssocClass", "ClassDefsOnly", "KeysOnly", "RequiredAssocQualifier",
+		"RequiredQualifier", "ResultClass", "ResultRole", "Role", "SchemaOnly",
+	} {
+		assocKeywords[strings.ToLower(k)] = k
+	}
+}
+
+func (p *parser) parseAssociators() *AssociatorsStatement {
+	s := &AssociatorsStatement{Associators: p.expect(ASSOCIATORS)}
+	p.expect(OF)
+	s.Object = p.parsePath()
+	p.assocClause(func(keyword, value string) bool {
+		switch keyword {
+		case "AssocClass":
+			s.AssocClass = value
+		case "ClassDefsOnly":
+			s.ClassDefsOnly = true
+		case "KeysOnly":
+			s.KeysOnly = true
+		case "RequiredAssocQualifier":
+			s.RequiredAssocQualifier = value
+		case "RequiredQualifier":
+			s.RequiredQualifier = value
+		case "ResultClass":
+			s.ResultClass = value
+		case "ResultRole":
+			s.ResultRole = value
+		case "Role":
+			s.Role = value
+		case "SchemaOnly":
+			s.SchemaOnly = true
+		default:
+			return false
+		}
+		return true
+	})
+	if s.ClassDefsOnly && s.SchemaOnly {
+		p.error(s.Associators, "ClassDefsOnly and SchemaOnly cannot be used together")
+	}
+	return s
+}
+
+func (p *parser) parseReferences() *ReferencesStatement {
+	s := &ReferencesStatement{References: p.expect(REFERENCES)}
+	p.expect(OF)
+	s.Object = p.parsePath()
+	p.assocClause(func(keyword, value string) bool {
+		switch keyword {
+		case "ClassDefsOnly":
+			s.ClassDefsOnly = true
+		case "KeysOnly":
+			s.KeysOnly = true
+		case "RequiredQualifier":
+			s.RequiredQualifier = value
+		case "ResultClass":
+			s.ResultClass = value
+		case "Role":
+			s.Role = value
+		case "SchemaOnly":
+			s.SchemaOnly = true
+		default:
+			return false
+		}
+		return true
+	})
+	if s.ClassDefsOnly && s.SchemaOnly {
+		p.error(s.References, "ClassDefsOnly and SchemaOnly cannot be used together")
+	}
+	return s
+}
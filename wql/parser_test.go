@@ -0,0 +1,176 @@
+package wql
+
+import "testing"
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string // if different from query
+	}{
+		{query: "SELECT * FROM Win32_Process"},
+		{query: "SELECT Name, ProcessId FROM Win32_Process WHERE ProcessId > 4"},
+		{
+			query: "select name from win32_service where state='Running' and (startmode = 'Auto' or not started = TRUE)",
+			want:  "SELECT name FROM win32_service WHERE state = 'Running' AND (startmode = 'Auto' OR NOT started = TRUE)",
+		},
+		{query: "SELECT * FROM Win32_Service WHERE NOT (Started = TRUE AND State <> 'Running')"},
+		{query: "SELECT * FROM Win32_Service WHERE A = 1 OR B = 2 AND C = 3"},
+		{query: "SELECT * FROM Win32_Service WHERE (A = 1 OR B = 2) AND C = 3"},
+		{query: "SELECT * FROM X WHERE A = 1 AND (B = 2 AND C = 3)"},
+		{
+			query: "SELECT * FROM X WHERE ((A = 1))",
+			want:  "SELECT * FROM X WHERE ((A = 1))",
+		},
+		{query: "SELECT * FROM Win32_Process WHERE Name LIKE 'svc%' AND Path NOT LIKE 'C:\\\\Windows\\\\%'"},
+		{query: "SELECT * FROM Win32_Process WHERE ExecutablePath IS NULL OR CommandLine IS NOT NULL"},
+		{query: "SELECT * FROM Win32_LogicalDisk WHERE FreeSpace < 1073741824 AND Size >= 0x10 AND Ratio <= -0.5"},
+		{
+			query: `SELECT * FROM X WHERE Name = "it's"`,
+			want:  `SELECT * FROM X WHERE Name = 'it\'s'`,
+		},
+		{query: "SELECT * FROM X WHERE 'Spooler' = Name AND NULL = Value"},
+		{query: "SELECT * FROM __InstanceCreationEvent WITHIN 5 WHERE TargetInstance ISA 'Win32_Process'"},
+		{query: "SELECT * FROM __InstanceModificationEvent WITHIN 1 WHERE TargetInstance ISA 'Win32_Service' AND TargetInstance.State <> PreviousInstance.State"},
+		{query: "SELECT * FROM __InstanceCreationEvent WHERE TargetInstance ISA 'Win32_NTLogEvent' GROUP WITHIN 600 BY TargetInstance.SourceName HAVING NumberOfEvents > 25"},
+		{query: "SELECT * FROM Win32_ProcessStartTrace GROUP WITHIN 10"},
+		{query: `ASSOCIATORS OF {Win32_Service.Name="Spooler"}`},
+		{
+			query: `associators of { Win32_Service.Name="Spooler" } where resultclass = Win32_Process role=Service classdefsonly`,
+			want:  `ASSOCIATORS OF {Win32_Service.Name="Spooler"} WHERE ResultClass = Win32_Process Role = Service ClassDefsOnly`,
+		},
+		{query: `ASSOCIATORS OF {Win32_NetworkAdapter.DeviceID="1"} WHERE AssocClass = Win32_NetworkAdapterSetting RequiredAssocQualifier = Association RequiredQualifier = Dynamic ResultRole = Setting KeysOnly SchemaOnly`},
+		{query: `REFERENCES OF {Win32_LogicalDisk.DeviceID="C:"} WHERE ResultClass = Win32_LogicalDiskToPartition Role = Dependent KeysOnly`},
+		{query: `REFERENCES OF {\\.\root\cimv2:Win32_Directory.Name="C:\\Windows"}`},
+	}
+	for _, tt := range tests {
+		stmt, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		want := tt.want
+		if want == "" {
+			want = tt.query
+		}
+		if got := stmt.String(); got != want {
+			t.Errorf("Parse(%q).String():\ngot  %s\nwant %s", tt.query, got, want)
+			continue
+		}
+		again, err := Parse(want)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", want, err)
+		} else if again.String() != want {
+			t.Errorf("round trip of %q: got %q", want, again.String())
+		}
+	}
+}
+
+func TestParseTree(t *testing.T) {
+	stmt, err := Parse("SELECT Name FROM Win32_Service WHERE NOT Started = TRUE AND State = 'Stopped' OR Name LIKE 'sql%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, ok := stmt.(*SelectStatement)
+	if !ok {
+		t.Fatalf("got %T, want *SelectStatement", stmt)
+	}
+	if len(sel.Fields) != 1 || sel.Fields[0].Name != "Name" || sel.Fields[0].Pos() != 7 {
+		t.Errorf("fields: %+v", sel.Fields)
+	}
+	if sel.From.Name != "Win32_Service" || sel.From.Pos() != 17 {
+		t.Errorf("from: %+v", sel.From)
+	}
+	or, ok := sel.Where.(*BinaryExpr)
+	if !ok || or.Op != OR {
+		t.Fatalf("where: got %s, want OR", sel.Where)
+	}
+	and, ok := or.X.(*BinaryExpr)
+	if !ok || and.Op != AND {
+		t.Fatalf("left of OR: got %s, want AND", or.X)
+	}
+	if not, ok := and.X.(*UnaryExpr); !ok || not.Op != NOT || not.Pos() != 37 {
+		t.Errorf("left of AND: got %s, want NOT", and.X)
+	}
+	eq, ok := and.Y.(*BinaryExpr)
+	if !ok || eq.Op != EQL || eq.OpPos != 66 {
+		t.Fatalf("right of AND: got %s, want =", and.Y)
+	}
+	if lit, ok := eq.Y.(*BasicLit); !ok || lit.Kind != STRING || lit.Value != "Stopped" {
+		t.Errorf("right of =: got %#v", eq.Y)
+	}
+	if like, ok := or.Y.(*BinaryExpr); !ok || like.Op != LIKE || like.Not {
+		t.Errorf("right of OR: got %s, want LIKE", or.Y)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	x, err := ParseExpr("ProcessId >= 100 AND Name IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := x.String(), "ProcessId >= 100 AND Name IS NOT NULL"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	n := x.(*BinaryExpr).X.(*BinaryExpr).Y.(*BasicLit)
+	if v, err := n.Int(); err != nil || v != 100 {
+		t.Errorf("Int() = %v, %v", v, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "1:1: expected SELECT, ASSOCIATORS or REFERENCES, found end of query"},
+		{"DELETE FROM X", "1:1: expected SELECT, ASSOCIATORS or REFERENCES, found 'DELETE'"},
+		{"SELECT FROM X", "1:8: expected name, found FROM"},
+		{"SELECT * Win32_Process", "1:10: expected FROM, found 'Win32_Process'"},
+		{"SELECT * FROM", "1:14: expected name, found end of query"},
+		{"SELECT a, FROM X", "1:11: expected name, found FROM"},
+		{"SELECT * FROM X WHERE", "1:22: expected operand, found end of query"},
+		{"SELECT * FROM X\nWHERE A = 1 AND", "2:16: expected operand, found end of query"},
+		{"SELECT * FROM X WHERE A", "1:24: expected comparison, LIKE, ISA or IS, found end of query"},
+		{"SELECT * FROM X WHERE (A = 1", "1:29: expected ), found end of query"},
+		{"SELECT * FROM X WHERE A = 1)", "1:28: expected EOF, found )"},
+		{"SELECT * FROM X WHERE A IS 1", "1:28: expected NULL, found '1'"},
+		{"SELECT * FROM X WHERE A LIKE 1", "1:30: LIKE pattern must be a string"},
+		{"SELECT * FROM X WHERE A ISA B", "1:29: ISA requires a class name string"},
+		{"SELECT * FROM X WHERE A NOT = 1", "1:29: expected LIKE, found ="},
+		{"SELECT * FROM X WITHIN 'a'", "1:24: expected number, found string 'a'"},
+		{"SELECT * FROM X GROUP BY A", "1:23: expected WITHIN, found BY"},
+		{"SELECT * FROM X WHERE A = 'b", "1:27: unterminated string"},
+		{"!", "1:1: unexpected character '!'"},
+		{"#SELECT * FROM X", "1:1: unexpected character '#'"},
+		{`\\HOST\root\cimv2:X`, `1:1: unexpected character '\\'`},
+		{"'SELECT * FROM X", "1:1: unterminated string"},
+		{"ASSOCIATORS {X}", "1:13: expected OF, found object path {X}"},
+		{"ASSOCIATORS OF X", "1:16: expected object path, found 'X'"},
+		{"ASSOCIATORS OF { }", "1:16: empty object path"},
+		{"ASSOCIATORS OF {X} WHERE", "1:25: expected keyword, found end of query"},
+		{"ASSOCIATORS OF {X} WHERE Foo = Bar", "1:26: unknown keyword Foo"},
+		{"ASSOCIATORS OF {X} WHERE Role = A role = B", "1:35: duplicate Role"},
+		{"ASSOCIATORS OF {X} WHERE Role", "1:26: Role requires a value"},
+		{"ASSOCIATORS OF {X} WHERE KeysOnly = A", "1:26: KeysOnly does not take a value"},
+		{"ASSOCIATORS OF {X} WHERE ClassDefsOnly SchemaOnly", "1:1: ClassDefsOnly and SchemaOnly cannot be used together"},
+		{"REFERENCES OF {X} WHERE ResultRole = A", "1:25: unknown keyword ResultRole"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		if err == nil {
+			t.Errorf("Parse(%q): no error", tt.query)
+			continue
+		}
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Parse(%q): got %T, want *SyntaxError", tt.query, err)
+		}
+		if want := "wql: syntax error at " + tt.want; err.Error() != want {
+			t.Errorf("Parse(%q):\ngot  %s\nwant %s", tt.query, err, want)
+		}
+	}
+
+	// A bad first token is an error, not a panic, for expressions too.
+	if _, err := ParseExpr("#A = 1"); err == nil {
+		t.Error(`ParseExpr("#A = 1"): no error`)
+	}
+}
@@ -0,0 +1,262 @@
+package wql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pos is the byte offset of a token or node in a query, starting at 0.
+type Pos int
+
+// NoPos means that there is no position, for example for nodes that were
+// built rather than parsed.
+const NoPos Pos = -1
+
+// IsValid reports whether the position is valid.
+func (p Pos) IsValid() bool {
+	return p >= 0
+}
+
+// Position is a printable position in a query.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (byte count)
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// position converts pos to a Position in src.
+func position(src string, pos Pos) Position {
+	p := Position{Offset: int(pos), Line: 1, Column: 1}
+	if int(pos) > len(src) {
+		p.Offset = len(src)
+	}
+	before := src[:p.Offset]
+	p.Line += strings.Count(before, "\n")
+	p.Column += len(before) - (strings.LastIndex(before, "\n") + 1)
+	return p
+}
+
+// A SyntaxError describes a problem in a query.
+type SyntaxError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("wql: syntax error at %s: %s", e.Pos, e.Msg)
+}
+
+// A Scanner splits a query into tokens.
+type Scanner struct {
+	src    string
+	offset int
+
+	// Err is the first error encountered, if any.
+	Err *SyntaxError
+}
+
+// Init prepares s to tokenize src.
+func (s *Scanner) Init(src string) {
+	s.src = src
+	s.offset = 0
+	s.Err = nil
+}
+
+func (s *Scanner) error(pos int, msg string) {
+	if s.Err == nil {
+		s.Err = &SyntaxError{Pos: position(s.src, Pos(pos)), Msg: msg}
+	}
+}
+
+func isLetter(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// Scan returns the next token, its position and its literal value. For
+// IDENT and NUMBER tokens the literal is the source text; for STRING tokens
+// it is the unquoted and unescaped value; for PATH tokens it is the text
+// between the braces with surrounding spaces removed; for ILLEGAL tokens it
+// is the offending text. At the end of the input Scan returns EOF.
+func (s *Scanner) Scan() (pos Pos, tok Token, lit string) {
+	for s.offset < len(s.src) && strings.IndexByte(" \t\r\n", s.src[s.offset]) >= 0 {
+		s.offset++
+	}
+	pos = Pos(s.offset)
+	if s.offset >= len(s.src) {
+		return pos, EOF, ""
+	}
+
+	start := s.offset
+	c := s.src[s.offset]
+	r, w := utf8.DecodeRuneInString(s.src[s.offset:])
+	switch {
+	case isLetter(r):
+		s.offset += w
+		for s.offset < len(s.src) {
+			r, w := utf8.DecodeRuneInString(s.src[s.offset:])
+			if !isLetter(r) && !unicode.IsDigit(r) && r != '.' {
+				break
+			}
+			s.offset += w
+		}
+		lit = s.src[start:s.offset]
+		return pos, Lookup(lit), lit
+	case isDigit(c) || (c == '-' || c == '+' || c == '.') && s.offset+1 < len(s.src) && isDigit(s.src[s.offset+1]):
+		return pos, NUMBER, s.scanNumber()
+	case c == '\'' || c == '"':
+		return pos, STRING, s.scanString()
+	case c == '{':
+		return pos, PATH, s.scanPath()
+	}
+
+	s.offset++
+	switch c {
+	case '=':
+		return pos, EQL, "="
+	case '<':
+		switch s.next() {
+		case '>':
+			s.offset++
+			return pos, NEQ, "<>"
+		case '=':
+			s.offset++
+			return pos, LEQ, "<="
+		}
+		return pos, LSS, "<"
+	case '>':
+		if s.next() == '=' {
+			s.offset++
+			return pos, GEQ, ">="
+		}
+		return pos, GTR, ">"
+	case '!':
+		if s.next() == '=' {
+			s.offset++
+			return pos, NEQ, "!="
+		}
+	case '(':
+		return pos, LPAREN, "("
+	case ')':
+		return pos, RPAREN, ")"
+	case ',':
+		return pos, COMMA, ","
+	case '*':
+		return pos, STAR, "*"
+	}
+	s.offset = start + w
+	lit = s.src[start:s.offset]
+	s.error(start, fmt.Sprintf("unexpected character %q", r))
+	return pos, ILLEGAL, lit
+}
+
+// next returns the byte at the current offset, or 0 at the end of the input.
+func (s *Scanner) next() byte {
+	if s.offset < len(s.src) {
+		return s.src[s.offset]
+	}
+	return 0
+}
+
+func (s *Scanner) digits(isDigit func(byte) bool) int {
+	n := 0
+	for s.offset < len(s.src) && isDigit(s.src[s.offset]) {
+		s.offset++
+		n++
+	}
+	return n
+}
+
+func (s *Scanner) scanNumber() string {
+	start := s.offset
+	if c := s.next(); c == '-' || c == '+' {
+		s.offset++
+	}
+	if s.next() == '0' && s.offset+1 < len(s.src) && (s.src[s.offset+1] == 'x' || s.src[s.offset+1] == 'X') {
+		s.offset += 2
+		if s.digits(isHexDigit) == 0 {
+			s.error(start, "invalid hexadecimal number")
+		}
+		return s.src[start:s.offset]
+	}
+	s.digits(isDigit)
+	if s.next() == '.' {
+		s.offset++
+		s.digits(isDigit)
+	}
+	if c := s.next(); c == 'e' || c == 'E' {
+		s.offset++
+		if c := s.next(); c == '-' || c == '+' {
+			s.offset++
+		}
+		if s.digits(isDigit) == 0 {
+			s.error(start, "invalid exponent")
+		}
+	}
+	return s.src[start:s.offset]
+}
+
+func (s *Scanner) scanString() string {
+	start := s.offset
+	quote := s.src[s.offset]
+	s.offset++
+	var b strings.Builder
+	for {
+		if s.offset >= len(s.src) {
+			s.error(start, "unterminated string")
+			return b.String()
+		}
+		c := s.src[s.offset]
+		s.offset++
+		switch c {
+		case quote:
+			return b.String()
+		case '\\':
+			if s.offset >= len(s.src) {
+				s.error(start, "unterminated string")
+				return b.String()
+			}
+			c = s.src[s.offset]
+			s.offset++
+		}
+		b.WriteByte(c)
+	}
+}
+
+func (s *Scanner) scanPath() string {
+	start := s.offset
+	s.offset++ // {
+	// quote is the quote of the key value being scanned, if any.
+	var quote byte
+	for s.offset < len(s.src) {
+		c := s.src[s.offset]
+		s.offset++
+		switch {
+		case c == '\\' && quote != 0:
+			if s.offset < len(s.src) {
+				s.offset++
+			}
+		case c == quote:
+			quote = 0
+		case (c == '"' || c == '\'') && quote == 0:
+			quote = c
+		case c == '}' && quote == 0:
+			return strings.TrimSpace(s.src[start+1 : s.offset-1])
+		}
+	}
+	s.error(start, "unterminated object path")
+	return strings.TrimSpace(s.src[start+1:])
+}
@@ -0,0 +1,128 @@
+package wql
+
+import "testing"
+
+type scanned struct {
+	pos Pos
+	tok Token
+	lit string
+}
+
+func scanAll(src string) ([]scanned, *SyntaxError) {
+	var s Scanner
+	s.Init(src)
+	var out []scanned
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == EOF {
+			return out, s.Err
+		}
+		out = append(out, scanned{pos, tok, lit})
+	}
+}
+
+func TestScan(t *testing.T) {
+	got, err := scanAll(`select Name,* FROM Win32_Service where TargetInstance.State<>'Run\'ning' ` +
+		`and X != "a\\b" or Y>=-1.5e3 Z<=0x1F {Win32_Disk.DeviceID="C:\\}"} isA`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scanned{
+		{0, SELECT, "select"},
+		{7, IDENT, "Name"},
+		{11, COMMA, ","},
+		{12, STAR, "*"},
+		{14, FROM, "FROM"},
+		{19, IDENT, "Win32_Service"},
+		{33, WHERE, "where"},
+		{39, IDENT, "TargetInstance.State"},
+		{59, NEQ, "<>"},
+		{61, STRING, "Run'ning"},
+		{73, AND, "and"},
+		{77, IDENT, "X"},
+		{79, NEQ, "!="},
+		{82, STRING, `a\b`},
+		{89, OR, "or"},
+		{92, IDENT, "Y"},
+		{93, GEQ, ">="},
+		{95, NUMBER, "-1.5e3"},
+		{102, IDENT, "Z"},
+		{103, LEQ, "<="},
+		{105, NUMBER, "0x1F"},
+		{110, PATH, `Win32_Disk.DeviceID="C:\\}"`},
+		{140, ISA, "isA"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestScanPathQuotes(t *testing.T) {
+	for _, path := range []string{
+		`Win32_Service.Name='a}b'`,
+		`Win32_Service.Name='O\'Brien}'`,
+		`Win32_Service.Name="O'Brien}"`,
+		`Win32_Directory.Name="C:\\}"`,
+	} {
+		got, err := scanAll("{" + path + "} X")
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if len(got) != 2 || got[0].tok != PATH || got[0].lit != path {
+			t.Errorf("%s: got %v", path, got)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"Name = 'abc", "wql: syntax error at 1:8: unterminated string"},
+		{"Name = #", "wql: syntax error at 1:8: unexpected character '#'"},
+		{"SELECT *\nFROM X\nWHERE A = 0x", "wql: syntax error at 3:11: invalid hexadecimal number"},
+		{"A = 1e", "wql: syntax error at 1:5: invalid exponent"},
+		{"ASSOCIATORS OF {X.Name=\"}", "wql: syntax error at 1:16: unterminated object path"},
+		{`ASSOCIATORS OF {"\`, "wql: syntax error at 1:16: unterminated object path"},
+		{`ASSOCIATORS OF {X.Name="abc\`, "wql: syntax error at 1:16: unterminated object path"},
+		{"ASSOCIATORS OF {X.Name='}", "wql: syntax error at 1:16: unterminated object path"},
+	}
+	for _, tt := range tests {
+		_, err := scanAll(tt.src)
+		if err == nil {
+			t.Errorf("%q: no error", tt.src)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", `''`},
+		{"Spooler", `'Spooler'`},
+		{`C:\Windows`, `'C:\\Windows'`},
+		{"O'Brien", `'O\'Brien'`},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		var s Scanner
+		s.Init(tt.want)
+		if _, tok, lit := s.Scan(); tok != STRING || lit != tt.in {
+			t.Errorf("Scan(%s) = %v %q, want STRING %q", tt.want, tok, lit, tt.in)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package wql
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Token is a lexical token of WQL.
+type Token int
+
+// The list of tokens.
+const (
+	ILLEGAL Token = iota
+	EOF
+
+	literalBeg
+	IDENT  // Name, TargetInstance.Name
+	NUMBER // 42, -1, 0x1F, 1.5
+	STRING // 'abc', "abc"
+	PATH   // {Win32_Service.Name="Spooler"}
+	literalEnd
+
+	operatorBeg
+	EQL    // =
+	NEQ    // <> or !=
+	LSS    // <
+	GTR    // >
+	LEQ    // <=
+	GEQ    // >=
+	LPAREN // (
+	RPAREN // )
+	COMMA  // ,
+	STAR   // *
+	operatorEnd
+
+	keywordBeg
+	AND
+	ASSOCIATORS
+	BY
+	FALSE
+	FROM
+	GROUP
+	HAVING
+	IS
+	ISA
+	LIKE
+	NOT
+	NULL
+	OF
+	OR
+	REFERENCES
+	SELECT
+	TRUE
+	WHERE
+	WITHIN
+	keywordEnd
+)
+
+var tokens = [...]string{
+	ILLEGAL: "ILLEGAL",
+	EOF:     "EOF",
+
+	IDENT:  "IDENT",
+	NUMBER: "NUMBER",
+	STRING: "STRING",
+	PATH:   "PATH",
+
+	EQL:    "=",
+	NEQ:    "<>",
+	LSS:    "<",
+	GTR:    ">",
+	LEQ:    "<=",
+	GEQ:    ">=",
+	LPAREN: "(",
+	RPAREN: ")",
+	COMMA:  ",",
+	STAR:   "*",
+
+	AND:         "AND",
+	ASSOCIATORS: "ASSOCIATORS",
+	BY:          "BY",
+	FALSE:       "FALSE",
+	FROM:        "FROM",
+	GROUP:       "GROUP",
+	HAVING:      "HAVING",
+	IS:          "IS",
+	ISA:         "ISA",
+	LIKE:        "LIKE",
+	NOT:         "NOT",
+	NULL:        "NULL",
+	OF:          "OF",
+	OR:          "OR",
+	REFERENCES:  "REFERENCES",
+	SELECT:      "SELECT",
+	TRUE:        "TRUE",
+	WHERE:       "WHERE",
+	WITHIN:      "WITHIN",
+}
+
+// String returns the string corresponding to the token tok. For operators
+// and keywords it is the actual character sequence; for other tokens it is
+// the token name.
+func (tok Token) String() string {
+	if 0 <= tok && int(tok) < len(tokens) && tokens[tok] != "" {
+		return tokens[tok]
+	}
+	return "token(" + strconv.Itoa(int(tok)) + ")"
+}
+
+// IsLiteral reports whether tok is an identifier or a literal.
+func (tok Token) IsLiteral() bool { return literalBeg < tok && tok < literalEnd }
+
+// IsOperator reports whether tok is an operator or delimiter.
+func (tok Token) IsOperator() bool { return operatorBeg < tok && tok < operatorEnd }
+
+// IsKeyword reports whether tok is a keyword.
+func (tok Token) IsKeyword() bool { return keywordBeg < tok && tok < keywordEnd }
+
+// IsComparison reports whether tok is a comparison operator.
+func (tok Token) IsComparison() bool { return EQL <= tok && tok <= GEQ }
+
+var keywords map[string]Token
+
+func init() {
+	keywords = make(map[string]Token)
+	for i := keywordBeg + 1; i < keywordEnd; i++ {
+		keywords[tokens[i]] = i
+	}
+}
+
+// Lookup maps an identifier to its keyword token or IDENT if it is not a
+// keyword. Keywords are case-insensitive.
+func Lookup(ident string) Token {
+	if tok, ok := keywords[strings.ToUpper(ident)]; ok {
+		return tok
+	}
+	return IDENT
+}